@@ -7,9 +7,13 @@ package expvar
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // RemoveAll removes all exported variables.
@@ -165,3 +169,83 @@ func TestHandler(t *testing.T) {
 		t.Errorf("HTTP handler wrote:\n%s\nWant:\n%s", got, want)
 	}
 }
+
+func TestPublishServer(t *testing.T) {
+	RemoveAll()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	PublishServer("server", ts.Config)
+	ts.Start()
+	defer ts.Close()
+
+	tr := &http.Transport{}
+	c := &http.Client{Transport: tr}
+	for _, path := range []string{"/", "/missing"} {
+		res, err := c.Post(ts.URL+path, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	v := Get("server").(*Map)
+	for _, tt := range []struct{ key, want string }{
+		{"requests", `{"2xx": 1, "4xx": 1}`},
+		{"bytes_in", "5"},
+		{"bytes_out", strconv.Itoa(len("hello") + len("404 page not found\n"))},
+		{"active_conns", "1"},
+	} {
+		if got := v.Get(tt.key).String(); got != tt.want {
+			t.Errorf("%s = %s; want %s", tt.key, got, tt.want)
+		}
+	}
+	var n int64
+	v.Get("latency").(*Map).Do(func(kv KeyValue) {
+		n += kv.Value.(*Int).i
+	})
+	if n != 2 {
+		t.Errorf("latency histogram counts %d requests; want 2", n)
+	}
+
+	tr.CloseIdleConnections()
+	for deadline := time.Now().Add(5 * time.Second); v.Get("active_conns").String() != "0"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("active_conns = %s after closing connections; want 0", v.Get("active_conns"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublishServerWriterInterfaces(t *testing.T) {
+	RemoveAll()
+	var cn, hj bool
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, cn = w.(http.CloseNotifier)
+		_, hj = w.(http.Hijacker)
+	})}
+	PublishServer("server", srv)
+
+	// A ResponseRecorder is neither a CloseNotifier nor a Hijacker.
+	srv.Handler.ServeHTTP(httptest.NewRecorder(), &http.Request{Method: "GET"})
+	if cn || hj {
+		t.Errorf("wrapping a ResponseRecorder: CloseNotifier %v, Hijacker %v; want neither", cn, hj)
+	}
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if !cn || !hj {
+		t.Errorf("wrapping the server's writer: CloseNotifier %v, Hijacker %v; want both", cn, hj)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package expvar
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the handler latency
+// histogram published by PublishServer, with their names.
+var latencyBuckets = []struct {
+	name  string
+	bound time.Duration
+}{
+	{"1ms", time.Millisecond},
+	{"10ms", 10 * time.Millisecond},
+	{"100ms", 100 * time.Millisecond},
+	{"1s", time.Second},
+	{"10s", 10 * time.Second},
+}
+
+// PublishServer publishes counters for srv as a Map under name:
+//
+//	requests      requests served, keyed by status class ("2xx", "4xx", ...)
+//	active_conns  client connections currently open
+//	bytes_in      request body bytes read by handlers
+//	bytes_out     response body bytes written by handlers
+//	latency       requests keyed by the smallest of the bounds
+//	              "1ms", "10ms", "100ms", "1s" and "10s" their handler
+//	              finished within, or "+Inf"
+//
+// PublishServer wraps srv.Handler (or DefaultServeMux if it is nil)
+// and srv.ConnState, so it must be called before srv starts serving.
+// Like Publish, it panics if name is already registered.
+func PublishServer(name string, srv *http.Server) {
+	m := new(serverMetrics)
+	m.requests.Init()
+	m.latency.Init()
+	v := new(Map).Init()
+	v.Set("requests", &m.requests)
+	v.Set("active_conns", &m.activeConns)
+	v.Set("bytes_in", &m.bytesIn)
+	v.Set("bytes_out", &m.bytesOut)
+	v.Set("latency", &m.latency)
+	Publish(name, v)
+
+	h := srv.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	srv.Handler = &metricsHandler{m, h}
+	hook := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			m.activeConns.Add(1)
+		case http.StateHijacked, http.StateClosed:
+			m.activeConns.Add(-1)
+		}
+		if hook != nil {
+			hook(c, state)
+		}
+	}
+}
+
+type serverMetrics struct {
+	requests    Map
+	activeConns Int
+	bytesIn     Int
+	bytesOut    Int
+	latency     Map
+}
+
+type metricsHandler struct {
+	m *serverMetrics
+	h http.Handler
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Body != nil {
+		// Count through a copy of r, so the server's own discarding
+		// of unread body bytes isn't counted.
+		r2 := *r
+		r2.Body = &countingBody{r.Body, &h.m.bytesIn}
+		r = &r2
+	}
+	mw := &metricsWriter{ResponseWriter: w, m: h.m}
+	h.h.ServeHTTP(mw.wrap(), r)
+	d := time.Since(start)
+
+	if mw.code == 0 {
+		mw.code = http.StatusOK
+	}
+	h.m.requests.Add(string('0'+mw.code/100)+"xx", 1)
+	bucket := "+Inf"
+	for _, b := range latencyBuckets {
+		if d <= b.bound {
+			bucket = b.name
+			break
+		}
+	}
+	h.m.latency.Add(bucket, 1)
+}
+
+// countingBody is a request body that adds the number of bytes read
+// from it to n.
+type countingBody struct {
+	io.ReadCloser
+	n *Int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// metricsWriter is a ResponseWriter that records the status code
+// and counts the body bytes written through it. It passes Flush
+// through to the underlying ResponseWriter, if that supports it.
+type metricsWriter struct {
+	http.ResponseWriter
+	m    *serverMetrics
+	code int
+}
+
+func (w *metricsWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metricsWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.m.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (w *metricsWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wrap returns w as a ResponseWriter that also implements whichever
+// of CloseNotifier and Hijacker the underlying ResponseWriter does,
+// so handlers checking for them see what the server supports.
+func (w *metricsWriter) wrap() http.ResponseWriter {
+	_, cn := w.ResponseWriter.(http.CloseNotifier)
+	_, hj := w.ResponseWriter.(http.Hijacker)
+	switch {
+	case cn && hj:
+		return struct {
+			*metricsWriter
+			closeNotifier
+			hijacker
+		}{w, closeNotifier{w}, hijacker{w}}
+	case cn:
+		return struct {
+			*metricsWriter
+			closeNotifier
+		}{w, closeNotifier{w}}
+	case hj:
+		return struct {
+			*metricsWriter
+			hijacker
+		}{w, hijacker{w}}
+	}
+	return w
+}
+
+type closeNotifier struct{ w *metricsWriter }
+
+func (c closeNotifier) CloseNotify() <-chan bool {
+	return c.w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+type hijacker struct{ w *metricsWriter }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.w.ResponseWriter.(http.Hijacker).Hijack()
+}
@@ -15,11 +15,13 @@ package multipart
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net/textproto"
+	"strings"
 )
 
 var emptyParams = make(map[string]string)
@@ -72,6 +74,24 @@ func (p *Part) FileName() string {
 	return p.dispositionParams["filename"]
 }
 
+// MultipartReader returns a Reader for the parts nested in p, if p
+// has a multipart Content-Type itself, as is the case for a
+// multipart/alternative body within a multipart/mixed email. The
+// returned Reader reads from p, so p's Read method must not be used
+// as well.
+func (p *Part) MultipartReader() (*Reader, error) {
+	v := p.Header.Get("Content-Type")
+	d, params, err := mime.ParseMediaType(v)
+	if err != nil || !strings.HasPrefix(d, "multipart/") {
+		return nil, fmt.Errorf("multipart: part Content-Type %q is not multipart", v)
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return nil, errors.New("multipart: no boundary param in part Content-Type")
+	}
+	return NewReader(p, boundary), nil
+}
+
 func (p *Part) parseContentDisposition() {
 	v := p.Header.Get("Content-Disposition")
 	var err error
@@ -109,7 +129,7 @@ func newPart(mr *Reader) (*Part, error) {
 	}
 	bp.r = partReader{bp}
 	const cte = "Content-Transfer-Encoding"
-	if bp.Header.Get(cte) == "quoted-printable" {
+	if strings.EqualFold(bp.Header.Get(cte), "quoted-printable") {
 		bp.Header.Del(cte)
 		bp.r = newQuotedPrintableReader(bp.r)
 	}
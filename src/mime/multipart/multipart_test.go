@@ -422,6 +422,56 @@ func TestNested(t *testing.T) {
 	}
 }
 
+func TestPartMultipartReader(t *testing.T) {
+	f, err := os.Open("testdata/nested-mime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mr := NewReader(f, "e89a8ff1c1e83553e304be640612")
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("reading first section (alternative): %v", err)
+	}
+	mr2, err := p.MultipartReader()
+	if err != nil {
+		t.Fatalf("MultipartReader of alternative section: %v", err)
+	}
+	var inner []string
+	for {
+		p2, err := mr2.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("inner NextPart: %v", err)
+		}
+		if _, err := p2.MultipartReader(); err == nil {
+			t.Errorf("MultipartReader of %s part succeeded", p2.Header.Get("Content-Type"))
+		}
+		b, err := ioutil.ReadAll(p2)
+		if err != nil {
+			t.Fatalf("reading inner part: %v", err)
+		}
+		inner = append(inner, string(b))
+	}
+	if want := []string{"*body*\r\n", "<b>body</b>\r\n"}; !reflect.DeepEqual(inner, want) {
+		t.Errorf("inner parts = %q; want %q", inner, want)
+	}
+}
+
+func TestQuotedPrintableCaseInsensitive(t *testing.T) {
+	body := "--b\r\nContent-Transfer-Encoding: Quoted-Printable\r\n\r\nfoo=3Dbar\r\n--b--\r\n"
+	p, err := NewReader(strings.NewReader(body), "b").NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(p)
+	if err != nil || string(b) != "foo=bar" {
+		t.Errorf("got %q, %v; want %q", b, err, "foo=bar")
+	}
+}
+
 type headerBody struct {
 	header textproto.MIMEHeader
 	body   string
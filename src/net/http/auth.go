@@ -0,0 +1,376 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// An Authenticator supplies credentials in response to an
+// authentication challenge from a server or proxy.
+//
+// Implementations of Authenticator must be safe for concurrent use by
+// multiple goroutines.
+type Authenticator interface {
+	// Authenticate is called when req received a 401 (Unauthorized)
+	// or 407 (Proxy Authentication Required) response resp. The
+	// challenges are in resp's WWW-Authenticate or
+	// Proxy-Authenticate header respectively.
+	//
+	// To answer the challenge, Authenticate returns the header
+	// fields, typically Authorization or Proxy-Authorization, to
+	// set on a retry of req. It returns a nil Header if it does not
+	// handle any of the challenges. A non-nil error stops the
+	// request and is returned by the Client.
+	//
+	// Authenticate must not modify req or read resp.Body.
+	Authenticate(req *Request, resp *Response) (Header, error)
+}
+
+// The AuthenticatorFunc type is an adapter to allow the use of
+// ordinary functions as Authenticators, such as one answering Bearer
+// challenges with a token. If f is a function with the appropriate
+// signature, AuthenticatorFunc(f) is an Authenticator that calls f.
+type AuthenticatorFunc func(req *Request, resp *Response) (Header, error)
+
+// Authenticate calls f(req, resp).
+func (f AuthenticatorFunc) Authenticate(req *Request, resp *Response) (Header, error) {
+	return f(req, resp)
+}
+
+// BasicAuthenticator returns an Authenticator that answers Basic
+// challenges with the provided username and password.
+func BasicAuthenticator(username, password string) Authenticator {
+	return &basicAuthenticator{username, password}
+}
+
+type basicAuthenticator struct {
+	username, password string
+}
+
+func (a *basicAuthenticator) Authenticate(req *Request, resp *Response) (Header, error) {
+	key := authorizationKey(resp.StatusCode)
+	if key == "" || !hasChallenge(resp.Header, challengeKey(resp.StatusCode), "Basic") {
+		return nil, nil
+	}
+	return Header{key: {"Basic " + basicAuth(a.username, a.password)}}, nil
+}
+
+// DigestAuthenticator returns an Authenticator that answers Digest
+// challenges, as described in RFC 2617, with the provided username
+// and password. It supports the MD5 and MD5-sess algorithms, with
+// qop "auth" or without qop; challenges it cannot answer are left to
+// other Authenticators.
+func DigestAuthenticator(username, password string) Authenticator {
+	return &digestAuthenticator{username, password}
+}
+
+type digestAuthenticator struct {
+	username, password string
+}
+
+// digestCnonce returns a client nonce. Tests replace it to get
+// repeatable responses.
+var digestCnonce = func() string {
+	b := make([]byte, 8)
+	io.ReadFull(rand.Reader, b)
+	return fmt.Sprintf("%x", b)
+}
+
+func (a *digestAuthenticator) Authenticate(req *Request, resp *Response) (Header, error) {
+	key := authorizationKey(resp.StatusCode)
+	if key == "" {
+		return nil, nil
+	}
+	c := challengeParams(resp.Header, challengeKey(resp.StatusCode), "Digest")
+	if c == nil || c["nonce"] == "" {
+		return nil, nil
+	}
+	algorithm := c["algorithm"]
+	if algorithm != "" && !strings.EqualFold(algorithm, "MD5") && !strings.EqualFold(algorithm, "MD5-sess") {
+		return nil, nil
+	}
+	qop := ""
+	if q, ok := c["qop"]; ok {
+		for _, v := range strings.Split(q, ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return nil, nil
+		}
+	}
+
+	realm, nonce, uri := c["realm"], c["nonce"], req.URL.RequestURI()
+	const nc = "00000001"
+	cnonce := ""
+	ha1 := md5Hex(a.username + ":" + realm + ":" + a.password)
+	if qop != "" || strings.EqualFold(algorithm, "MD5-sess") {
+		cnonce = digestCnonce()
+	}
+	if strings.EqualFold(algorithm, "MD5-sess") {
+		ha1 = md5Hex(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := md5Hex(req.Method + ":" + uri)
+	var response string
+	if qop != "" {
+		response = md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	v := fmt.Sprintf(`Digest username=%s, realm=%s, nonce=%s, uri=%s, response="%s"`,
+		quote(a.username), quote(realm), quote(nonce), quote(uri), response)
+	if algorithm != "" {
+		v += ", algorithm=" + algorithm
+	}
+	if opaque, ok := c["opaque"]; ok {
+		v += ", opaque=" + quote(opaque)
+	}
+	if qop != "" {
+		v += ", qop=" + qop + ", nc=" + nc
+	}
+	if cnonce != "" {
+		v += ", cnonce=" + quote(cnonce)
+	}
+	return Header{key: {v}}, nil
+}
+
+func md5Hex(s string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+}
+
+// quote returns s as a quoted-string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// challengeKey returns the response header holding the
+// authentication challenges for a response with the given status.
+func challengeKey(statusCode int) string {
+	switch statusCode {
+	case StatusUnauthorized:
+		return "Www-Authenticate"
+	case StatusProxyAuthRequired:
+		return "Proxy-Authenticate"
+	}
+	return ""
+}
+
+// authorizationKey returns the request header that answers the
+// challenges of a response with the given status.
+func authorizationKey(statusCode int) string {
+	switch statusCode {
+	case StatusUnauthorized:
+		return "Authorization"
+	case StatusProxyAuthRequired:
+		return "Proxy-Authorization"
+	}
+	return ""
+}
+
+// hasChallenge reports whether the header h[key] contains a
+// challenge for the given authentication scheme, compared
+// case-insensitively. A header value may hold several
+// comma-separated challenges, each followed by its parameters.
+func hasChallenge(h Header, key, scheme string) bool {
+	for _, v := range h[key] {
+		for _, part := range splitUnquoted(v, ',') {
+			part = strings.TrimSpace(part)
+			i := strings.IndexAny(part, " \t=")
+			if i >= 0 && part[i] == '=' {
+				// A parameter of the previous challenge.
+				continue
+			}
+			if i >= 0 {
+				part = part[:i]
+			}
+			if strings.EqualFold(part, scheme) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// challengeParams returns the parameters of the first challenge for
+// the given authentication scheme in the header h[key], with quoted
+// values unquoted, or nil if there is no such challenge.
+func challengeParams(h Header, key, scheme string) map[string]string {
+	var params map[string]string
+	for _, v := range h[key] {
+		in := false
+		for _, part := range splitUnquoted(v, ',') {
+			part = strings.TrimSpace(part)
+			i := strings.IndexAny(part, " \t=")
+			if i < 0 || part[i] != '=' {
+				// The start of a new challenge.
+				if params != nil {
+					return params
+				}
+				name, rest := part, ""
+				if i >= 0 {
+					name, rest = part[:i], strings.TrimSpace(part[i:])
+				}
+				part = rest
+				if in = strings.EqualFold(name, scheme); !in {
+					continue
+				}
+				params = make(map[string]string)
+				if part == "" {
+					continue
+				}
+			}
+			if !in {
+				continue
+			}
+			if i = strings.Index(part, "="); i < 0 {
+				continue
+			}
+			params[strings.ToLower(strings.TrimSpace(part[:i]))] = unquote(strings.TrimSpace(part[i+1:]))
+		}
+		if params != nil {
+			return params
+		}
+	}
+	return params
+}
+
+// unquote returns the value of s if it is a quoted-string, and s
+// unchanged otherwise.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// splitUnquoted splits s around each instance of sep that is not
+// within a quoted-string.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// sendAuth is like send, but if the Client has Authenticators and
+// the response is an authentication challenge one of them answers,
+// req is retried once with the credentials they supply. The
+// Authenticators are only consulted if req is for host, the host of
+// the request the caller passed to the Client, so that credentials
+// never follow a redirect to another host. If req is upgraded to
+// HTTPS by the Client's HSTS store or retried, setReq, if non-nil,
+// is called with the new request before it is sent.
+func (c *Client) sendAuth(req *Request, host string, setReq func(*Request)) (*Response, error) {
+	if ureq := c.hstsUpgrade(req); ureq != nil {
+		req = ureq
+		if setReq != nil {
+			setReq(req)
+		}
+	}
+	if len(c.Authenticators) == 0 {
+		return c.send(req, setReq)
+	}
+	resp, err := c.send(req, setReq)
+	if err != nil {
+		return nil, err
+	}
+	if !sameHost(req.URL.Host, host) {
+		return resp, nil
+	}
+	areq, err := c.authenticate(req, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if areq == nil {
+		return resp, nil
+	}
+	if setReq != nil {
+		setReq(areq)
+	}
+	return c.send(areq, setReq)
+}
+
+// authenticate consults c.Authenticators about resp, the response
+// to req. It returns the request to retry with credentials, or nil if
+// resp should be returned to the caller as is. If it returns a
+// request, resp.Body has been closed.
+func (c *Client) authenticate(req *Request, resp *Response) (*Request, error) {
+	if authorizationKey(resp.StatusCode) == "" {
+		return nil, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// The body was consumed by the first attempt.
+		return nil, nil
+	}
+	for _, a := range c.Authenticators {
+		h, err := a.Authenticate(req, resp)
+		if err != nil {
+			return nil, err
+		}
+		if h == nil {
+			continue
+		}
+		nreq := req.Clone()
+		if nreq.Header == nil {
+			nreq.Header = make(Header)
+		}
+		for k, vv := range h {
+			nreq.Header[CanonicalHeaderKey(k)] = vv
+		}
+		if req.GetBody != nil {
+			if nreq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		// Read the body if small so underlying TCP connection will be re-used.
+		const maxBodySlurpSize = 2 << 10
+		if resp.ContentLength == -1 || resp.ContentLength <= maxBodySlurpSize {
+			io.CopyN(ioutil.Discard, resp.Body, maxBodySlurpSize)
+		}
+		resp.Body.Close()
+		return nreq, nil
+	}
+	return nil, nil
+}
+
+// sameHost reports whether the "host" or "host:port" strings a and
+// b name the same host, ignoring ports and case.
+func sameHost(a, b string) bool {
+	if hasPort(a) {
+		a, _, _ = net.SplitHostPort(a)
+	}
+	if hasPort(b) {
+		b, _, _ = net.SplitHostPort(b)
+	}
+	return strings.EqualFold(a, b)
+}
@@ -10,12 +10,15 @@
 package http
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/url"
 	"strings"
 	"sync"
@@ -28,6 +31,9 @@ import (
 // The Client's Transport typically has internal state (cached TCP
 // connections), so Clients should be reused instead of created as
 // needed. Clients are safe for concurrent use by multiple goroutines.
+// A Client does not modify the Requests it is given: default headers,
+// cookies and credentials are added to copies, and the Client's
+// redirect state is kept per call.
 //
 // A Client is higher-level than a RoundTripper (such as Transport)
 // and additionally handles HTTP details such as cookies and
@@ -48,14 +54,48 @@ type Client struct {
 	// issuing the Request req.
 	//
 	// If CheckRedirect is nil, the Client uses its default policy,
-	// which is to stop after 10 consecutive requests.
+	// which is to stop after MaxRedirects consecutive requests with
+	// a *TooManyRedirectsError.
 	CheckRedirect func(req *Request, via []*Request) error
 
+	// MaxRedirects is the number of redirects the default redirect
+	// policy follows before giving up. If zero, 10 is used. It is
+	// ignored if CheckRedirect is set.
+	MaxRedirects int
+
+	// Header contains header fields, such as User-Agent, to add to
+	// every request the Client sends, including redirects and
+	// authentication retries. A field the request already has is
+	// left as is.
+	Header Header
+
 	// Jar specifies the cookie jar.
 	// If Jar is nil, cookies are not sent in requests and ignored
 	// in responses.
 	Jar CookieJar
 
+	// HSTS specifies the store of HTTP Strict Transport Security
+	// policies. If HSTS is not nil, Strict-Transport-Security
+	// headers received over HTTPS are recorded in it, and requests
+	// for http URLs of the hosts it holds policies for are made
+	// over HTTPS instead, including redirects.
+	// If HSTS is nil, such headers are ignored.
+	HSTS HSTSStore
+
+	// Authenticators answer authentication challenges. When a
+	// request receives a 401 (Unauthorized) or 407 (Proxy
+	// Authentication Required) response, each Authenticator is
+	// consulted in order, and the first to supply credentials
+	// causes the request to be sent once more with them. A request
+	// with a Body is only retried if its GetBody field is set.
+	// Challenges received after a redirect to a different host are
+	// not answered, so credentials are only sent to the host
+	// of the request passed to the Client.
+	//
+	// If Authenticators is empty, such responses are returned to
+	// the caller.
+	Authenticators []Authenticator
+
 	// Timeout specifies a time limit for requests made by this
 	// Client. The timeout includes connection time, any
 	// redirects, and reading the response body. The timer remains
@@ -133,13 +173,18 @@ type readClose struct {
 	io.Closer
 }
 
-func (c *Client) send(req *Request) (*Response, error) {
-	if c.Jar != nil {
-		for _, cookie := range c.Jar.Cookies(req.URL) {
-			req.AddCookie(cookie)
+// send sends req with the Client's default headers and the cookies
+// from its Jar added, and records any cookies and HSTS policy in the
+// response. The headers are added to a copy, leaving req unmodified;
+// if a copy is sent, setReq, if non-nil, is called with it first.
+func (c *Client) send(req *Request, setReq func(*Request)) (*Response, error) {
+	if nreq := c.addHeaders(req); nreq != req {
+		req = nreq
+		if setReq != nil {
+			setReq(req)
 		}
 	}
-	resp, err := send(req, c.transport())
+	resp, err := send(req, c.transport(), setReq)
 	if err != nil {
 		return nil, err
 	}
@@ -148,15 +193,53 @@ func (c *Client) send(req *Request) (*Response, error) {
 			c.Jar.SetCookies(req.URL, rc)
 		}
 	}
+	c.recordHSTS(req, resp)
 	return resp, err
 }
 
+// addHeaders returns req with the Client's default headers and the
+// cookies from its Jar added. If there are any to add, it returns a
+// copy of req; otherwise it returns req itself.
+func (c *Client) addHeaders(req *Request) *Request {
+	var cookies []*Cookie
+	if c.Jar != nil {
+		cookies = c.Jar.Cookies(req.URL)
+	}
+	var missing []string
+	for k := range c.Header {
+		if _, ok := req.Header[CanonicalHeaderKey(k)]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	if len(cookies) == 0 && len(missing) == 0 {
+		return req
+	}
+	req = req.Clone()
+	if req.Header == nil {
+		req.Header = make(Header)
+	}
+	for _, k := range missing {
+		req.Header[CanonicalHeaderKey(k)] = append([]string(nil), c.Header[k]...)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
 // Do sends an HTTP request and returns an HTTP response, following
 // policy (e.g. redirects, cookies, auth) as configured on the client.
 //
 // An error is returned if caused by client policy (such as
 // CheckRedirect), or if there was an HTTP protocol error.
-// A non-2xx response doesn't cause an error.
+// A non-2xx response doesn't cause an error. Failures to connect,
+// expired time limits and the default redirect policy are reported
+// as a *DialError, *TimeoutError or *TooManyRedirectsError, wrapped
+// in a *url.Error, which carries the request URL.
+//
+// Redirects are followed for GET, HEAD, POST and PUT requests; for
+// other methods the redirect response is returned. The Client's
+// Timeout applies to requests of every method.
 //
 // When err is nil, resp always contains a non-nil resp.Body.
 //
@@ -170,13 +253,13 @@ func (c *Client) send(req *Request) (*Response, error) {
 //
 // Generally Get, Post, or PostForm will be used instead of Do.
 func (c *Client) Do(req *Request) (resp *Response, err error) {
-	if req.Method == "GET" || req.Method == "HEAD" {
+	if req.Method == MethodGet || req.Method == MethodHead {
 		return c.doFollowingRedirects(req, shouldRedirectGet)
 	}
-	if req.Method == "POST" || req.Method == "PUT" {
+	if req.Method == MethodPost || req.Method == MethodPut {
 		return c.doFollowingRedirects(req, shouldRedirectPost)
 	}
-	return c.send(req)
+	return c.doFollowingRedirects(req, shouldRedirectNone)
 }
 
 func (c *Client) transport() RoundTripper {
@@ -186,9 +269,11 @@ func (c *Client) transport() RoundTripper {
 	return DefaultTransport
 }
 
-// send issues an HTTP request.
+// send issues an HTTP request with t. Headers it must add, the
+// Authorization for a URL with user information, are set on a copy
+// of req, which is passed to setReq, if non-nil, before being sent.
 // Caller should close resp.Body when done reading from it.
-func send(req *Request, t RoundTripper) (resp *Response, err error) {
+func send(req *Request, t RoundTripper, setReq func(*Request)) (resp *Response, err error) {
 	if t == nil {
 		req.closeBody()
 		return nil, errors.New("http: no Client.Transport or DefaultTransport")
@@ -207,14 +292,19 @@ func send(req *Request, t RoundTripper) (resp *Response, err error) {
 	// Most the callers of send (Get, Post, et al) don't need
 	// Headers, leaving it uninitialized.  We guarantee to the
 	// Transport that this has been initialized, though.
-	if req.Header == nil {
-		req.Header = make(Header)
-	}
-
-	if u := req.URL.User; u != nil {
-		username := u.Username()
-		password, _ := u.Password()
-		req.Header.Set("Authorization", "Basic "+basicAuth(username, password))
+	if req.Header == nil || req.URL.User != nil {
+		req = req.Clone()
+		if req.Header == nil {
+			req.Header = make(Header)
+		}
+		if u := req.URL.User; u != nil {
+			username := u.Username()
+			password, _ := u.Password()
+			req.Header.Set("Authorization", "Basic "+basicAuth(username, password))
+		}
+		if setReq != nil {
+			setReq(req)
+		}
 	}
 	resp, err = t.RoundTrip(req)
 	if err != nil {
@@ -240,7 +330,7 @@ func basicAuth(username, password string) string {
 // automatically redirect.
 func shouldRedirectGet(statusCode int) bool {
 	switch statusCode {
-	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect:
+	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
 		return true
 	}
 	return false
@@ -250,12 +340,25 @@ func shouldRedirectGet(statusCode int) bool {
 // automatically redirect.
 func shouldRedirectPost(statusCode int) bool {
 	switch statusCode {
-	case StatusFound, StatusSeeOther:
+	case StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
 		return true
 	}
 	return false
 }
 
+// shouldRedirectNone is the redirect policy for methods other than
+// GET, HEAD, POST and PUT, whose redirects are returned to the caller.
+func shouldRedirectNone(statusCode int) bool {
+	return false
+}
+
+// preservesMethod reports whether a redirect with the given status
+// code must be followed with the original method and body, rather
+// than with a GET.
+func preservesMethod(statusCode int) bool {
+	return statusCode == StatusTemporaryRedirect || statusCode == StatusPermanentRedirect
+}
+
 // Get issues a GET to the specified URL.  If the response is one of the following
 // redirect codes, Get follows the redirect, up to a maximum of 10 redirects:
 //
@@ -263,6 +366,7 @@ func shouldRedirectPost(statusCode int) bool {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // An error is returned if there were too many redirects or if there
 // was an HTTP protocol error. A non-2xx response doesn't cause an
@@ -284,6 +388,7 @@ func Get(url string) (resp *Response, err error) {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // An error is returned if the Client's CheckRedirect function fails
 // or if there was an HTTP protocol error. A non-2xx response doesn't
@@ -292,7 +397,7 @@ func Get(url string) (resp *Response, err error) {
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Get(url string) (resp *Response, err error) {
-	req, err := NewRequest("GET", url, nil)
+	req, err := NewRequest(MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -303,9 +408,10 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 	var base *url.URL
 	redirectChecker := c.CheckRedirect
 	if redirectChecker == nil {
-		redirectChecker = defaultCheckRedirect
+		redirectChecker = c.defaultCheckRedirect
 	}
 	var via []*Request
+	var redirects []*Response
 
 	if ireq.URL == nil {
 		ireq.closeBody()
@@ -315,7 +421,13 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 	var reqmu sync.Mutex // guards req
 	req := ireq
 
-	var timer *time.Timer
+	var timer stopper
+	var timedOut bool // guarded by reqmu
+	didTimeout := func() bool {
+		reqmu.Lock()
+		defer reqmu.Unlock()
+		return timedOut
+	}
 	if c.Timeout > 0 {
 		type canceler interface {
 			CancelRequest(*Request)
@@ -324,9 +436,10 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 		if !ok {
 			return nil, fmt.Errorf("net/http: Client Transport of type %T doesn't support CancelRequest; Timeout not supported", c.transport())
 		}
-		timer = time.AfterFunc(c.Timeout, func() {
+		timer = timeAfterFunc(c.Timeout, func() {
 			reqmu.Lock()
 			defer reqmu.Unlock()
+			timedOut = true
 			tr.CancelRequest(req)
 		})
 	}
@@ -337,10 +450,26 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 		if redirect != 0 {
 			nreq := new(Request)
 			nreq.Method = ireq.Method
-			if ireq.Method == "POST" || ireq.Method == "PUT" {
-				nreq.Method = "GET"
-			}
 			nreq.Header = make(Header)
+			if preservesMethod(redirects[len(redirects)-1].StatusCode) {
+				if ireq.Body != nil {
+					if ireq.GetBody == nil {
+						err = fmt.Errorf("http: cannot follow %d redirect: request body cannot be replayed (Request.GetBody is nil)", redirects[len(redirects)-1].StatusCode)
+						break
+					}
+					if nreq.Body, err = ireq.GetBody(); err != nil {
+						break
+					}
+					nreq.GetBody = ireq.GetBody
+					nreq.ContentLength = ireq.ContentLength
+					if ct := ireq.Header.Get("Content-Type"); ct != "" {
+						nreq.Header.Set("Content-Type", ct)
+					}
+				}
+			} else if ireq.Method == MethodPost || ireq.Method == MethodPut {
+				nreq.Method = MethodGet
+			}
+			nreq.Trace = ireq.Trace
 			nreq.URL, err = base.Parse(urlStr)
 			if err != nil {
 				break
@@ -364,7 +493,15 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 		}
 
 		urlStr = req.URL.String()
-		if resp, err = c.send(req); err != nil {
+		resp, err = c.sendAuth(req, ireq.URL.Host, func(areq *Request) {
+			reqmu.Lock()
+			req = areq
+			reqmu.Unlock()
+		})
+		if err != nil {
+			if timer != nil && didTimeout() {
+				err = &TimeoutError{Limit: LimitClientTimeout}
+			}
 			break
 		}
 
@@ -382,15 +519,20 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 			}
 			base = req.URL
 			via = append(via, req)
+			redirects = append(redirects, resp)
 			continue
 		}
 		if timer != nil {
-			resp.Body = &cancelTimerBody{timer, resp.Body}
+			resp.Body = &cancelTimerBody{timer, resp.Body, didTimeout}
 		}
+		resp.Redirects = redirects
 		return resp, nil
 	}
 
 	method := ireq.Method
+	if method == "" {
+		method = MethodGet
+	}
 	urlErr := &url.Error{
 		Op:  method[0:1] + strings.ToLower(method[1:]),
 		URL: urlStr,
@@ -401,6 +543,9 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 		// Special case for Go 1 compatibility: return both the response
 		// and an error if the CheckRedirect function failed.
 		// See http://golang.org/issue/3795
+		if n := len(redirects); n > 1 {
+			resp.Redirects = redirects[:n-1]
+		}
 		return resp, urlErr
 	}
 
@@ -410,9 +555,23 @@ func (c *Client) doFollowingRedirects(ireq *Request, shouldRedirect func(int) bo
 	return nil, urlErr
 }
 
-func defaultCheckRedirect(req *Request, via []*Request) error {
-	if len(via) >= 10 {
-		return errors.New("stopped after 10 redirects")
+// A TooManyRedirectsError is returned by a Client using the default
+// redirect policy when a request is redirected more than Max times.
+type TooManyRedirectsError struct {
+	Max int
+}
+
+func (e *TooManyRedirectsError) Error() string {
+	return fmt.Sprintf("stopped after %d redirects", e.Max)
+}
+
+func (c *Client) defaultCheckRedirect(req *Request, via []*Request) error {
+	max := c.MaxRedirects
+	if max == 0 {
+		max = 10
+	}
+	if len(via) >= max {
+		return &TooManyRedirectsError{Max: max}
 	}
 	return nil
 }
@@ -432,8 +591,13 @@ func Post(url string, bodyType string, body io.Reader) (resp *Response, err erro
 //
 // If the provided body is also an io.Closer, it is closed after the
 // request.
+//
+// A 302 or 303 redirect is followed with a GET. A 307 or 308
+// redirect is followed with the same POST, which requires a body
+// NewRequest knows how to replay, such as a *bytes.Buffer or
+// *strings.Reader; otherwise an error is returned.
 func (c *Client) Post(url string, bodyType string, body io.Reader) (resp *Response, err error) {
-	req, err := NewRequest("POST", url, body)
+	req, err := NewRequest(MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -461,6 +625,55 @@ func (c *Client) PostForm(url string, data url.Values) (resp *Response, err erro
 	return c.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
 
+// PostJSON issues a POST to the specified URL, with v encoded as JSON
+// as the request body.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+//
+// PostJSON is a wrapper around DefaultClient.PostJSON
+func PostJSON(url string, v interface{}) (resp *Response, err error) {
+	return DefaultClient.PostJSON(url, v)
+}
+
+// PostJSON issues a POST to the specified URL, with v encoded as JSON
+// as the request body and a Content-Type of application/json.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) PostJSON(url string, v interface{}) (resp *Response, err error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.Post(url, "application/json", bytes.NewReader(body))
+}
+
+// DecodeJSON decodes the JSON body of resp into v and closes the
+// body. It returns an error without reading the body if resp's
+// Content-Type is not application/json or another JSON type such as
+// application/problem+json, and an error if the body is longer than
+// maxBytes. If maxBytes is zero or negative, 10 MB is used.
+func DecodeJSON(resp *Response, v interface{}, maxBytes int64) error {
+	defer resp.Body.Close()
+	ct := resp.Header.Get("Content-Type")
+	mediatype, _, err := mime.ParseMediaType(ct)
+	if err != nil || mediatype != "application/json" && !strings.HasSuffix(mediatype, "+json") {
+		return fmt.Errorf("http: response Content-Type %q is not JSON", ct)
+	}
+	if maxBytes <= 0 {
+		maxBytes = 10 << 20
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > maxBytes {
+		return fmt.Errorf("http: JSON response body larger than %d bytes", maxBytes)
+	}
+	return json.Unmarshal(body, v)
+}
+
 // Head issues a HEAD to the specified URL.  If the response is one of the
 // following redirect codes, Head follows the redirect after calling the
 // Client's CheckRedirect function.
@@ -469,6 +682,7 @@ func (c *Client) PostForm(url string, data url.Values) (resp *Response, err erro
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // Head is a wrapper around DefaultClient.Head
 func Head(url string) (resp *Response, err error) {
@@ -483,8 +697,9 @@ func Head(url string) (resp *Response, err error) {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 func (c *Client) Head(url string) (resp *Response, err error) {
-	req, err := NewRequest("HEAD", url, nil)
+	req, err := NewRequest(MethodHead, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -492,14 +707,17 @@ func (c *Client) Head(url string) (resp *Response, err error) {
 }
 
 type cancelTimerBody struct {
-	t  *time.Timer
-	rc io.ReadCloser
+	t          stopper
+	rc         io.ReadCloser
+	didTimeout func() bool
 }
 
 func (b *cancelTimerBody) Read(p []byte) (n int, err error) {
 	n, err = b.rc.Read(p)
 	if err == io.EOF {
 		b.t.Stop()
+	} else if err != nil && b.didTimeout() {
+		err = &TimeoutError{Limit: LimitClientTimeout}
 	}
 	return
 }
@@ -192,6 +192,186 @@ func TestPostFormRequestFormat(t *testing.T) {
 	}
 }
 
+func TestClientPostJSON(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/echo":
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q; want application/json", ct)
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			io.Copy(w, r.Body)
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			io.WriteString(w, `{"Name":"problem"}`)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, `{"Name":"html"}`)
+		}
+	}))
+	defer ts.Close()
+
+	type msg struct {
+		Name string
+		N    int
+	}
+	res, err := PostJSON(ts.URL+"/echo", msg{"gopher", 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got msg
+	if err := DecodeJSON(res, &got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got != (msg{"gopher", 42}) {
+		t.Errorf("echoed %+v; want {gopher 42}", got)
+	}
+
+	res, err = PostJSON(ts.URL+"/echo", msg{"gopher", 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeJSON(res, &got, 10); err == nil || !strings.Contains(err.Error(), "larger than 10 bytes") {
+		t.Errorf("DecodeJSON with small limit = %v; want size error", err)
+	}
+
+	res, err = Get(ts.URL + "/problem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeJSON(res, &got, 0); err != nil || got.Name != "problem" {
+		t.Errorf("DecodeJSON of +json type = %+v, %v; want problem", got, err)
+	}
+
+	res, err = Get(ts.URL + "/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeJSON(res, &got, 0); err == nil || !strings.Contains(err.Error(), "not JSON") {
+		t.Errorf("DecodeJSON of text/html = %v; want Content-Type error", err)
+	}
+
+	if _, err := PostJSON(ts.URL+"/echo", make(chan int)); err == nil {
+		t.Error("PostJSON of unencodable value succeeded")
+	}
+}
+
+func TestClientHeader(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/redirect" {
+			Redirect(w, r, "/", StatusFound)
+			return
+		}
+		fmt.Fprintf(w, "%s|%s|%s", r.UserAgent(), r.Header.Get("X-Api-Key"), r.Header.Get("Accept"))
+	}))
+	defer ts.Close()
+
+	c := &Client{Header: Header{
+		"User-Agent": {"apiclient/1.0"},
+		"x-api-key":  {"k"},
+		"Accept":     {"application/json"},
+	}}
+	tests := []struct {
+		path   string
+		accept string
+		want   string
+	}{
+		{"/", "", "apiclient/1.0|k|application/json"},
+		{"/redirect", "", "apiclient/1.0|k|application/json"},
+		{"/", "text/plain", "apiclient/1.0|k|text/plain"},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", ts.URL+tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(got) != tt.want {
+			t.Errorf("%s with Accept %q: server saw %q; want %q", tt.path, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestClientDoesNotModifyRequest(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		SetCookie(w, &Cookie{Name: "session", Value: "abc"})
+		fmt.Fprintf(w, "%s|%s", r.UserAgent(), r.Header.Get("Cookie"))
+	}))
+	defer ts.Close()
+
+	c := &Client{
+		Jar:    new(TestJar),
+		Header: Header{"User-Agent": {"shared/1.0"}},
+	}
+	req, _ := NewRequest("GET", ts.URL, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if len(req.Header) != 0 {
+		t.Errorf("request Header modified to %v", req.Header)
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "shared/1.0|session=abc"; string(got) != want {
+		t.Errorf("server saw %q; want %q", got, want)
+	}
+	if res.Request == req || res.Request.Header.Get("Cookie") != "session=abc" {
+		t.Errorf("Response.Request = %p with Cookie %q; want copy of %p with the Jar's cookie", res.Request, res.Request.Header.Get("Cookie"), req)
+	}
+}
+
+// Basic auth from URL user information is added to a copy too.
+func TestClientDoesNotModifyRequestAuth(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.User = url.UserPassword("user", "pass")
+	req := &Request{Method: "GET", URL: u} // no Header
+	for i := 0; i < 2; i++ {
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass")); string(got) != want {
+			t.Errorf("server saw Authorization %q; want %q", got, want)
+		}
+	}
+	if req.Header != nil {
+		t.Errorf("request Header set to %v", req.Header)
+	}
+}
+
 func TestClientRedirects(t *testing.T) {
 	defer afterTest(t)
 	var ts *httptest.Server
@@ -216,6 +396,11 @@ func TestClientRedirects(t *testing.T) {
 	if e, g := "Get /?n=10: stopped after 10 redirects", fmt.Sprintf("%v", err); e != g {
 		t.Errorf("with default client Get, expected error %q, got %q", e, g)
 	}
+	if ue, ok := err.(*url.Error); !ok {
+		t.Errorf("Get error is %T; want *url.Error", err)
+	} else if e, ok := ue.Err.(*TooManyRedirectsError); !ok || e.Max != 10 {
+		t.Errorf("Get error cause = %#v; want *TooManyRedirectsError with Max 10", ue.Err)
+	}
 
 	// HEAD request should also have the ability to follow redirects.
 	_, err = c.Head(ts.URL)
@@ -230,13 +415,29 @@ func TestClientRedirects(t *testing.T) {
 		t.Errorf("with default client Do, expected error %q, got %q", e, g)
 	}
 
+	// MaxRedirects changes the default policy's limit.
+	c = &Client{MaxRedirects: 3}
+	_, err = c.Get(ts.URL)
+	if e, g := "Get /?n=3: stopped after 3 redirects", fmt.Sprintf("%v", err); e != g {
+		t.Errorf("with MaxRedirects 3, expected error %q, got %q", e, g)
+	}
+	c.MaxRedirects = 20
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("with MaxRedirects 20, Get error: %v", err)
+	}
+	res.Body.Close()
+	if len(res.Redirects) != 15 {
+		t.Errorf("with MaxRedirects 20, got %d redirects; want 15", len(res.Redirects))
+	}
+
 	var checkErr error
 	var lastVia []*Request
 	c = &Client{CheckRedirect: func(_ *Request, via []*Request) error {
 		lastVia = via
 		return checkErr
 	}}
-	res, err := c.Get(ts.URL)
+	res, err = c.Get(ts.URL)
 	if err != nil {
 		t.Fatalf("Get error: %v", err)
 	}
@@ -251,6 +452,18 @@ func TestClientRedirects(t *testing.T) {
 	if e, g := 15, len(lastVia); e != g {
 		t.Errorf("expected lastVia to have contained %d elements; got %d", e, g)
 	}
+	if e, g := 15, len(res.Redirects); e != g {
+		t.Fatalf("expected Redirects to have %d elements; got %d", e, g)
+	}
+	for i, rres := range res.Redirects {
+		if rres.StatusCode != StatusFound || rres.Request != lastVia[i] {
+			t.Errorf("Redirects[%d] = %d response to %v; want 302 response to %v", i, rres.StatusCode, rres.Request.URL, lastVia[i].URL)
+		}
+		loc, err := rres.Location()
+		if err != nil || loc.String() != fmt.Sprintf("%s/?n=%d", ts.URL, i+1) {
+			t.Errorf("Redirects[%d].Location() = %v, %v; want %s/?n=%d", i, loc, err, ts.URL, i+1)
+		}
+	}
 
 	checkErr = errors.New("no redirects allowed")
 	res, err = c.Get(ts.URL)
@@ -264,6 +477,9 @@ func TestClientRedirects(t *testing.T) {
 	if res.Header.Get("Location") == "" {
 		t.Errorf("no Location header in Response")
 	}
+	if res.Redirects != nil {
+		t.Errorf("Redirects of first redirect response = %v; want nil", res.Redirects)
+	}
 }
 
 func TestPostRedirects(t *testing.T) {
@@ -294,6 +510,8 @@ func TestPostRedirects(t *testing.T) {
 		{"/?code=301", 301},
 		{"/?code=302", 200},
 		{"/?code=303", 200},
+		{"/?code=307", 200},
+		{"/?code=308", 200},
 		{"/?code=404", 404},
 	}
 	for _, tt := range tests {
@@ -308,12 +526,43 @@ func TestPostRedirects(t *testing.T) {
 	log.Lock()
 	got := log.String()
 	log.Unlock()
-	want := "POST / POST /?code=301 POST /?code=302 GET / POST /?code=303 GET / POST /?code=404 "
+	want := "POST / POST /?code=301 POST /?code=302 GET / POST /?code=303 GET / POST /?code=307 POST / POST /?code=308 POST / POST /?code=404 "
 	if got != want {
 		t.Errorf("Log differs.\n Got: %q\nWant: %q", got, want)
 	}
 }
 
+func TestClientRedirectPreservesBody(t *testing.T) {
+	defer afterTest(t)
+	var ts *httptest.Server
+	ts = httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/redirect" {
+			Redirect(w, r, "/final", StatusTemporaryRedirect)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("Content-Type"), body)
+	}))
+	defer ts.Close()
+
+	res, err := Post(ts.URL+"/redirect", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "POST text/plain hello"; string(got) != want {
+		t.Errorf("after 307, server saw %q; want %q", got, want)
+	}
+
+	// A body that can't be replayed can't follow the redirect.
+	req, _ := NewRequest("PUT", ts.URL+"/redirect", ioutil.NopCloser(strings.NewReader("hello")))
+	_, err = DefaultClient.Do(req)
+	if err == nil || !strings.Contains(err.Error(), "cannot be replayed") {
+		t.Errorf("Do with unreplayable body = %v; want replay error", err)
+	}
+}
+
 var expectedCookies = []*Cookie{
 	{Name: "ChocolateChip", Value: "tasty"},
 	{Name: "First", Value: "Hit"},
@@ -842,6 +1091,251 @@ func TestBasicAuth(t *testing.T) {
 	}
 }
 
+func TestClientAuthenticators(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "gopher" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="a, b", Basic realm="test"`)
+			w.WriteHeader(StatusUnauthorized)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	c := &Client{}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusUnauthorized {
+		t.Errorf("without Authenticators, status = %d; want %d", res.StatusCode, StatusUnauthorized)
+	}
+
+	c.Authenticators = []Authenticator{BasicAuthenticator("gopher", "secret")}
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		req, _ := NewRequest(method, ts.URL, strings.NewReader("body"))
+		res, err = c.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		slurp, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if res.StatusCode != StatusOK || string(slurp) != "body" {
+			t.Errorf("%s: got %d %q; want 200 %q", method, res.StatusCode, slurp, "body")
+		}
+	}
+
+	// A body that can't be replayed is not retried.
+	res, err = c.Post(ts.URL, "text/plain", ioutil.NopCloser(strings.NewReader("body")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusUnauthorized {
+		t.Errorf("unreplayable body: status = %d; want %d", res.StatusCode, StatusUnauthorized)
+	}
+
+	// A request with a nil Header gets one for the credentials.
+	u, _ := url.Parse(ts.URL)
+	res, err = c.Do(&Request{Method: "GET", URL: u})
+	if err != nil {
+		t.Fatalf("nil Header: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusOK {
+		t.Errorf("nil Header: status = %d; want %d", res.StatusCode, StatusOK)
+	}
+}
+
+func TestClientAuthenticatorsCrossHostRedirect(t *testing.T) {
+	defer afterTest(t)
+	var mu sync.Mutex
+	var evilAuth []string
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.Host {
+		case "good.example":
+			if _, _, ok := r.BasicAuth(); !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="good"`)
+				w.WriteHeader(StatusUnauthorized)
+				return
+			}
+			Redirect(w, r, "http://evil.example/", StatusFound)
+		case "evil.example":
+			mu.Lock()
+			evilAuth = append(evilAuth, r.Header.Get("Authorization"))
+			mu.Unlock()
+			w.Header().Set("WWW-Authenticate", `Digest realm="evil", nonce="n", qop="auth"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="evil"`)
+			w.WriteHeader(StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	tr := &Transport{Dial: func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, ts.Listener.Addr().String())
+	}}
+	defer tr.CloseIdleConnections()
+	c := &Client{
+		Transport: tr,
+		Authenticators: []Authenticator{
+			DigestAuthenticator("gopher", "secret"),
+			BasicAuthenticator("gopher", "secret"),
+		},
+	}
+	res, err := c.Get("http://good.example/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusUnauthorized || res.Request.URL.Host != "evil.example" {
+		t.Errorf("got %d from %s; want the other host's %d", res.StatusCode, res.Request.URL.Host, StatusUnauthorized)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(evilAuth) != 1 || evilAuth[0] != "" {
+		t.Errorf("redirect target got Authorization headers %q; want one request without", evilAuth)
+	}
+}
+
+func TestClientAuthenticatorFunc(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", scope="read"`)
+			w.WriteHeader(StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	var calls int
+	bearer := AuthenticatorFunc(func(req *Request, resp *Response) (Header, error) {
+		calls++
+		if !ExportHasChallenge(resp.Header, "Www-Authenticate", "Bearer") {
+			return nil, nil
+		}
+		return Header{"Authorization": {"Bearer t0ken"}}, nil
+	})
+	c := &Client{Authenticators: []Authenticator{BasicAuthenticator("gopher", "secret"), bearer}}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusOK || calls != 1 {
+		t.Errorf("status = %d after %d calls; want %d after 1", res.StatusCode, calls, StatusOK)
+	}
+
+	// An error from the Authenticator stops the request.
+	errStop := errors.New("no token")
+	c.Authenticators = []Authenticator{AuthenticatorFunc(func(*Request, *Response) (Header, error) {
+		return nil, errStop
+	})}
+	if _, err := c.Get(ts.URL); err == nil || !strings.Contains(err.Error(), errStop.Error()) {
+		t.Errorf("Get error = %v; want %v", err, errStop)
+	}
+}
+
+func TestHasChallenge(t *testing.T) {
+	tests := []struct {
+		v      string
+		scheme string
+		want   bool
+	}{
+		{`Basic realm="x"`, "Basic", true},
+		{`basic`, "Basic", true},
+		{`Bearer realm="x", error="invalid_token"`, "Basic", false},
+		{`Digest realm="Basic, here", nonce="1", Bearer`, "Basic", false},
+		{`Digest realm="Basic, here", nonce="1", Bearer`, "Bearer", true},
+		{`Digest realm="a\"b, Basic"`, "Basic", false},
+	}
+	for _, tt := range tests {
+		h := Header{"Www-Authenticate": {tt.v}}
+		if got := ExportHasChallenge(h, "Www-Authenticate", tt.scheme); got != tt.want {
+			t.Errorf("HasChallenge(%q, %q) = %v; want %v", tt.v, tt.scheme, got, tt.want)
+		}
+	}
+}
+
+func TestDigestAuthenticator(t *testing.T) {
+	defer SetDigestCnonce("0a4f113b")()
+	const nonce = `nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`
+	const creds = `Digest username="Mufasa", realm="testrealm@host.com", ` + nonce
+	tests := []struct {
+		method, uri string
+		code        int
+		challenge   string
+		want        string // Authorization value, or "" for no answer
+	}{
+		// The example from RFC 2617, section 3.5.
+		{"GET", "/dir/index.html", 401,
+			`Digest realm="testrealm@host.com", qop="auth,auth-int", ` + nonce + `, opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+			creds + `, uri="/dir/index.html", response="6629fae49393a05397450978507c4ef1", opaque="5ccc069c403ebaf9f0171e9517f40e41", qop=auth, nc=00000001, cnonce="0a4f113b"`},
+		{"GET", "/dir/index.html", 401,
+			`Basic realm="x", Digest realm="testrealm@host.com", ` + nonce + `, algorithm=MD5-sess, qop="auth"`,
+			creds + `, uri="/dir/index.html", response="8e3825c57e897f5a0dec6c2d4e5059d0", algorithm=MD5-sess, qop=auth, nc=00000001, cnonce="0a4f113b"`},
+		// RFC 2069 compatibility: no qop.
+		{"GET", "/dir/index.html", 401,
+			`Digest realm="testrealm@host.com", ` + nonce,
+			creds + `, uri="/dir/index.html", response="670fd8c2df070c60b045671b8b24ff02"`},
+		{"POST", "/dir/index.html?x=1", 407,
+			`Digest realm="testrealm@host.com", ` + nonce + `, algorithm=MD5`,
+			creds + `, uri="/dir/index.html?x=1", response="0f83abcdcc235a4778461fe9746b37f7", algorithm=MD5`},
+
+		{"GET", "/", 401, `Digest realm="testrealm@host.com", ` + nonce + `, algorithm=SHA-256`, ""},
+		{"GET", "/", 401, `Digest realm="testrealm@host.com", ` + nonce + `, qop="auth-int"`, ""},
+		{"GET", "/", 401, `Digest realm="testrealm@host.com"`, ""},
+		{"GET", "/", 401, `Basic realm="testrealm@host.com"`, ""},
+	}
+	a := DigestAuthenticator("Mufasa", "Circle Of Life")
+	for i, tt := range tests {
+		req, _ := NewRequest(tt.method, "http://www.example.com"+tt.uri, nil)
+		challengeKey, authKey := "Www-Authenticate", "Authorization"
+		if tt.code == StatusProxyAuthRequired {
+			challengeKey, authKey = "Proxy-Authenticate", "Proxy-Authorization"
+		}
+		res := &Response{StatusCode: tt.code, Header: Header{challengeKey: {tt.challenge}}}
+		h, err := a.Authenticate(req, res)
+		if err != nil {
+			t.Errorf("%d. Authenticate error: %v", i, err)
+			continue
+		}
+		if got := h.Get(authKey); got != tt.want || (tt.want == "" && h != nil) {
+			t.Errorf("%d. %s = %q\nwant %q", i, authKey, got, tt.want)
+		}
+	}
+}
+
+func TestClientDigestAuthenticator(t *testing.T) {
+	defer afterTest(t)
+	defer SetDigestCnonce("0a4f113b")()
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), `response="6629fae49393a05397450978507c4ef1"`) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="testrealm@host.com", qop="auth", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`)
+			w.WriteHeader(StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	c := &Client{Authenticators: []Authenticator{DigestAuthenticator("Mufasa", "Circle Of Life")}}
+	res, err := c.Get(ts.URL + "/dir/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusOK {
+		t.Errorf("status = %d; want %d", res.StatusCode, StatusOK)
+	}
+}
+
 func TestClientTimeout(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping in short mode")
@@ -907,6 +1401,89 @@ func TestClientTimeout(t *testing.T) {
 	}
 }
 
+func TestClientTimeoutFakeClock(t *testing.T) {
+	defer afterTest(t)
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+	unblock := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("Hello"))
+		w.(Flusher).Flush()
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr, Timeout: time.Hour}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(res.Body)
+		errc <- err
+	}()
+	clock.Advance(time.Hour)
+	select {
+	case err := <-errc:
+		if e, ok := err.(*TimeoutError); !ok || e.Limit != LimitClientTimeout {
+			t.Errorf("ReadAll error = %#v; want *TimeoutError for Client.Timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client.Timeout did not fire after advancing the clock")
+	}
+}
+
+// Client.Timeout applies to requests with methods Do follows no
+// redirects for.
+func TestClientTimeoutOtherMethods(t *testing.T) {
+	defer afterTest(t)
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+	gotReq := make(chan bool, 1)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		gotReq <- true
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr, Timeout: time.Hour}
+	for _, method := range []string{MethodDelete, MethodPatch, MethodOptions} {
+		req, _ := NewRequest(method, ts.URL, nil)
+		errc := make(chan error, 1)
+		go func() {
+			res, err := c.Do(req)
+			if err == nil {
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+		<-gotReq
+		clock.Advance(time.Hour)
+		select {
+		case err := <-errc:
+			ue, ok := err.(*url.Error)
+			if !ok {
+				t.Errorf("%s: Do error = %#v; want *url.Error", method, err)
+				continue
+			}
+			if e, ok := ue.Err.(*TimeoutError); !ok || e.Limit != LimitClientTimeout {
+				t.Errorf("%s: Do error = %v; want *TimeoutError for Client.Timeout", method, ue.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Client.Timeout did not fire after advancing the clock", method)
+		}
+	}
+}
+
 func TestClientRedirectEatsBody(t *testing.T) {
 	defer afterTest(t)
 	saw := make(chan string, 2)
@@ -0,0 +1,21 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import "time"
+
+// A stopper is a timer that can be stopped, such as a *time.Timer.
+type stopper interface {
+	Stop() bool
+}
+
+// Time source for the timeout machinery of the Client, Transport and
+// Server. Tests replace these hooks with a fake clock so timeouts
+// fire deterministically rather than after real delays.
+var (
+	timeNow       = time.Now
+	timeAfter     = time.After
+	timeAfterFunc = func(d time.Duration, f func()) stopper { return time.AfterFunc(d, f) }
+)
@@ -0,0 +1,58 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+)
+
+// SetConditional makes r conditional on the resource having changed
+// since cached, an earlier response for it: it sets If-None-Match to
+// cached's ETag and If-Modified-Since to its Last-Modified time, for
+// those cached has. A server whose resource has not changed then
+// answers with 304 Not Modified and no body; see Revalidate.
+func (r *Request) SetConditional(cached *Response) {
+	if etag := cached.Header.get("Etag"); etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lm := cached.Header.get("Last-Modified"); lm != "" {
+		r.Header.Set("If-Modified-Since", lm)
+	}
+}
+
+// Revalidate interprets res, the response to a request made
+// conditional on cached with SetConditional. If res is not a 304 Not
+// Modified response, the resource has changed and Revalidate returns
+// res. Otherwise it closes res.Body and returns a copy of cached that
+// has body as its Body and cached's header fields updated with those
+// res carries, as RFC 7234 section 4.3.4 requires. cached.Body is
+// not used, as it has typically already been read.
+func Revalidate(res, cached *Response, body []byte) *Response {
+	if res.StatusCode != StatusNotModified {
+		return res
+	}
+	res.Body.Close()
+	r := new(Response)
+	*r = *cached
+	r.Header = cached.Header.clone()
+	for k, vv := range res.Header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			// These describe the (empty) 304 body, not the
+			// cached one.
+			continue
+		}
+		r.Header[k] = vv
+	}
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	r.ContentLength = int64(len(body))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.Request = res.Request
+	r.Redirects = res.Redirects
+	r.TLS = res.TLS
+	return r
+}
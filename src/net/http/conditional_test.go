@@ -0,0 +1,85 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConditionalRequest(t *testing.T) {
+	defer afterTest(t)
+	var mu sync.Mutex
+	version, hits := "v1", 0
+	modtime := time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		v := version
+		hits++
+		w.Header().Set("X-Hits", strings.Repeat("*", hits))
+		mu.Unlock()
+		w.Header().Set("ETag", `"`+v+`"`)
+		mt := modtime
+		if v != "v1" {
+			mt = mt.Add(time.Hour)
+		}
+		ServeContent(w, r, "x.txt", mt, strings.NewReader("content "+v))
+	}))
+	defer ts.Close()
+
+	get := func(cached *Response) *Response {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		if cached != nil {
+			req.SetConditional(cached)
+			if req.Header.Get("If-None-Match") != `"v1"` || req.Header.Get("If-Modified-Since") != modtime.Format(TimeFormat) {
+				t.Errorf("conditional request headers = %v", req.Header)
+			}
+		}
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	cached := get(nil)
+	body, err := ioutil.ReadAll(cached.Body)
+	cached.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged: the cached response comes back, with updated
+	// headers.
+	res := Revalidate(get(cached), cached, body)
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != StatusOK || string(got) != "content v1" {
+		t.Errorf("revalidated response = %d %q; want 200 %q", res.StatusCode, got, "content v1")
+	}
+	if res.Header.Get("X-Hits") != "**" || res.Header.Get("Content-Type") != cached.Header.Get("Content-Type") {
+		t.Errorf("revalidated header = %v", res.Header)
+	}
+	if res.ContentLength != int64(len(body)) || cached.Header.Get("X-Hits") != "*" {
+		t.Errorf("ContentLength = %d, cached X-Hits = %q; want %d, %q", res.ContentLength, cached.Header.Get("X-Hits"), len(body), "*")
+	}
+
+	// Changed: the new response is returned as is.
+	mu.Lock()
+	version = "v2"
+	mu.Unlock()
+	fresh := get(cached)
+	res = Revalidate(fresh, cached, body)
+	got, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res != fresh || string(got) != "content v2" {
+		t.Errorf("after change, got %q; want the new response", got)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Save writes the jar's persistent cookies that have not expired to
+// w, so that they can be restored into a later jar with Load. Session
+// cookies, those without an Expires or Max-Age attribute, end with
+// the jar and are not written.
+//
+// The format is a JSON array with one object per cookie, in the order
+// the cookies were created. Each object has the string fields Name,
+// Value, Domain and Path, the boolean fields Secure, HttpOnly,
+// Persistent and HostOnly, and the RFC 3339 time fields Expires,
+// Creation and LastAccess, holding the cookie's attributes as
+// described in RFC 6265 section 5.3. Domain has no leading dot.
+func (j *Jar) Save(w io.Writer) error {
+	return j.save(w, time.Now())
+}
+
+// save is like Save but takes the current time as a parameter.
+func (j *Jar) save(w io.Writer, now time.Time) error {
+	j.mu.Lock()
+	var entries []entry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if e.Persistent && e.Expires.After(now) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	j.mu.Unlock()
+	sort.Sort(byCreation(entries))
+	saved := make([]savedCookie, len(entries))
+	for i, e := range entries {
+		saved[i] = savedCookie{
+			Name:       e.Name,
+			Value:      e.Value,
+			Domain:     e.Domain,
+			Path:       e.Path,
+			Secure:     e.Secure,
+			HttpOnly:   e.HttpOnly,
+			Persistent: e.Persistent,
+			HostOnly:   e.HostOnly,
+			Expires:    e.Expires,
+			Creation:   e.Creation,
+			LastAccess: e.LastAccess,
+		}
+	}
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// Load reads cookies written by Save from r and adds them to the jar,
+// replacing any it already holds with the same name, domain and path.
+// Cookies that have expired since they were saved are dropped.
+//
+// The cookies are checked as the jar checks those it is given by
+// SetCookies: a domain cookie's domain must not be a public suffix
+// or an IP address, for instance. If any cookie fails the checks,
+// Load returns an error and adds none of them.
+func (j *Jar) Load(r io.Reader) error {
+	return j.load(r, time.Now())
+}
+
+// load is like Load but takes the current time as a parameter.
+func (j *Jar) load(r io.Reader, now time.Time) error {
+	var saved []savedCookie
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return err
+	}
+	var loaded []entry
+	for _, c := range saved {
+		e, err := j.loadedEntry(c)
+		if err != nil {
+			return err
+		}
+		if e.Persistent && e.Expires.After(now) {
+			loaded = append(loaded, e)
+		}
+	}
+	sort.Sort(byCreation(loaded))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range loaded {
+		key := jarKey(e.Domain, j.psList)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]entry)
+			j.entries[key] = submap
+		}
+		e.seqNum = j.nextSeqNum
+		j.nextSeqNum++
+		submap[e.id()] = e
+	}
+	return nil
+}
+
+// savedCookie is a cookie as written by Save. It is kept apart from
+// entry so that the format doesn't change with the jar's internals.
+type savedCookie struct {
+	Name       string
+	Value      string
+	Domain     string
+	Path       string
+	Secure     bool
+	HttpOnly   bool
+	Persistent bool
+	HostOnly   bool
+	Expires    time.Time
+	Creation   time.Time
+	LastAccess time.Time
+}
+
+// loadedEntry returns the entry for c, a saved cookie, after checking
+// that the jar could have made it: newEntry gives host cookies the
+// canonical host they came from, and domain cookies a domain accepted
+// by domainAndType from a host of that same name.
+func (j *Jar) loadedEntry(c savedCookie) (entry, error) {
+	if c.Name == "" || c.Domain == "" || c.Path == "" {
+		return entry{}, errors.New("cookiejar: saved cookie missing Name, Domain or Path")
+	}
+	if c.Path[0] != '/' {
+		return entry{}, fmt.Errorf("cookiejar: saved cookie %q has malformed Path %q", c.Name, c.Path)
+	}
+	valid := false
+	if h, err := canonicalHost(c.Domain); err == nil && h == c.Domain {
+		if c.HostOnly {
+			valid = true
+		} else {
+			d, hostOnly, err := j.domainAndType(c.Domain, c.Domain)
+			valid = err == nil && !hostOnly && d == c.Domain
+		}
+	}
+	if !valid {
+		return entry{}, fmt.Errorf("cookiejar: saved cookie %q has illegal Domain %q", c.Name, c.Domain)
+	}
+	return entry{
+		Name:       c.Name,
+		Value:      c.Value,
+		Domain:     c.Domain,
+		Path:       c.Path,
+		Secure:     c.Secure,
+		HttpOnly:   c.HttpOnly,
+		Persistent: c.Persistent,
+		HostOnly:   c.HostOnly,
+		Expires:    c.Expires,
+		Creation:   c.Creation,
+		LastAccess: c.LastAccess,
+	}, nil
+}
+
+// byCreation is a sort.Interface ordering entries by creation time,
+// and then by sequence number.
+type byCreation []entry
+
+func (s byCreation) Len() int { return len(s) }
+
+func (s byCreation) Less(i, j int) bool {
+	if !s[i].Creation.Equal(s[j].Creation) {
+		return s[i].Creation.Before(s[j].Creation)
+	}
+	return s[i].seqNum < s[j].seqNum
+}
+
+func (s byCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,110 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	jar := newTestJar()
+	var set []*http.Cookie
+	for _, cs := range []string{
+		"a=1; max-age=3600",
+		"b=2; max-age=3600; domain=example.co.uk; path=/p; secure",
+		"c=3",             // session cookie: not saved
+		"d=4; max-age=10", // expires before loading
+		"e=5; max-age=-1", // deleted
+		"f=6; " + expiresIn(7200),
+	} {
+		set = append(set, (&http.Response{Header: http.Header{"Set-Cookie": {cs}}}).Cookies()...)
+	}
+	jar.setCookies(mustParseURL("http://www.example.co.uk/p/x"), set, tNow)
+
+	var buf bytes.Buffer
+	if err := jar.save(&buf, tNow); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.String()
+	if strings.Contains(saved, `"c"`) || strings.Contains(saved, `"e"`) {
+		t.Errorf("saved session or deleted cookie: %s", saved)
+	}
+
+	jar2 := newTestJar()
+	if err := jar2.load(strings.NewReader(saved), tNow.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url, want string
+	}{
+		{"http://www.example.co.uk/p/x", "a=1 f=6"},
+		{"https://www.example.co.uk/p/x", "a=1 b=2 f=6"},
+		{"https://other.example.co.uk/p/", "b=2"},
+		{"https://other.example.co.uk/", ""},
+	}
+	for _, tt := range tests {
+		var s []string
+		for _, c := range jar2.cookies(mustParseURL(tt.url), tNow.Add(2*time.Minute)) {
+			s = append(s, c.Name+"="+c.Value)
+		}
+		if got := strings.Join(s, " "); got != tt.want {
+			t.Errorf("Cookies(%q) = %q; want %q", tt.url, got, tt.want)
+		}
+	}
+
+	// Saving the loaded jar gives back what was loaded.
+	buf.Reset()
+	if err := jar2.save(&buf, tNow.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	jar3 := newTestJar()
+	if err := jar3.load(&buf, tNow.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(jar3.cookies(mustParseURL("https://www.example.co.uk/p/x"), tNow.Add(2*time.Minute))); got != 3 {
+		t.Errorf("after second save and load, got %d cookies; want 3", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`{"Name":"a"}`,
+		`[{"Name":"a","Value":"1","Path":"/"}]`,
+		saved("co.uk", false, "/"), // public suffix
+		saved("1.2.3.4", false, "/"),
+		saved("Example.com", true, "/"),
+		saved(".example.com", false, "/"),
+		saved("example.com.", true, "/"),
+		saved("example.com:80", true, "/"),
+		saved("example.com", false, "p"),
+	} {
+		if err := newTestJar().Load(strings.NewReader(in)); err == nil {
+			t.Errorf("Load(%q) succeeded; want error", in)
+		}
+	}
+	for _, in := range []string{
+		`[]`,
+		saved("co.uk", true, "/"),
+		saved("1.2.3.4", true, "/"),
+		saved("www.example.com", false, "/p"),
+	} {
+		if err := newTestJar().Load(strings.NewReader(in)); err != nil {
+			t.Errorf("Load(%q): %v", in, err)
+		}
+	}
+}
+
+// saved returns the Save output for one cookie with the given domain,
+// host-only attribute and path.
+func saved(domain string, hostOnly bool, path string) string {
+	return fmt.Sprintf(`[{"Name":"a","Value":"1","Domain":%q,"Path":%q,"Persistent":true,"HostOnly":%v,"Expires":"2099-01-01T00:00:00Z"}]`,
+		domain, path, hostOnly)
+}
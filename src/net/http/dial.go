@@ -0,0 +1,230 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Dialing for Transports without a Dial function
+
+package http
+
+import (
+	"net"
+	"time"
+)
+
+// defaultFallbackDelay is the Transport.FallbackDelay used if it is
+// zero.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// lookupIP and dialTCPAddr are how dialTCP resolves host names, if
+// the Transport has no Resolver, and connects to a single address.
+// They are replaced by tests.
+var (
+	lookupIP    = net.LookupIP
+	dialTCPAddr = func(d *net.Dialer, addr string) (net.Conn, error) { return d.Dial("tcp", addr) }
+)
+
+// A Resolver looks up host names for a Transport.
+type Resolver interface {
+	// LookupIP returns the IPv4 and IPv6 addresses of host.
+	LookupIP(host string) ([]net.IP, error)
+}
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// lookupIP resolves host with t.Resolver or the system resolver,
+// through the cache if t.DNSCacheTTL is positive.
+func (t *Transport) lookupIP(host string) ([]net.IP, error) {
+	if t.DNSCacheTTL > 0 {
+		t.dnsMu.Lock()
+		e, ok := t.dnsCache[host]
+		t.dnsMu.Unlock()
+		if ok && timeNow().Before(e.expires) {
+			return e.ips, nil
+		}
+	}
+	var ips []net.IP
+	var err error
+	if t.Resolver != nil {
+		ips, err = t.Resolver.LookupIP(host)
+	} else {
+		ips, err = lookupIP(host)
+	}
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.DNSCacheTTL > 0 {
+		t.dnsMu.Lock()
+		if t.dnsCache == nil {
+			t.dnsCache = make(map[string]dnsCacheEntry)
+		}
+		t.dnsCache[host] = dnsCacheEntry{ips, timeNow().Add(t.DNSCacheTTL)}
+		t.dnsMu.Unlock()
+	}
+	return ips, nil
+}
+
+// FlushDNSCache discards the host name lookups cached because of
+// DNSCacheTTL.
+func (t *Transport) FlushDNSCache() {
+	t.dnsMu.Lock()
+	t.dnsCache = nil
+	t.dnsMu.Unlock()
+}
+
+// dialTCP connects to addr, a "host:port". When the host has both
+// IPv6 and IPv4 addresses, the family of its first address is tried
+// first, and the other family is raced against it once
+// t.FallbackDelay has passed or the first family has failed.
+func (t *Transport) dialTCP(addr string) (net.Conn, error) {
+	d := new(net.Dialer)
+	if t.LocalAddr != nil {
+		d.LocalAddr = t.LocalAddr
+	}
+	var lookupDeadline time.Time
+	if t.DialTimeout > 0 {
+		// The net package holds d to its deadline by the real
+		// clock; only the lookup, done here, follows timeNow.
+		d.Deadline = time.Now().Add(t.DialTimeout)
+		lookupDeadline = timeNow().Add(t.DialTimeout)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return dialTCPAddr(d, addr)
+	}
+	ips, err := t.lookupDeadline(host, lookupDeadline)
+	if err != nil {
+		return nil, err
+	}
+	if la := t.LocalAddr; la != nil && la.IP != nil && !la.IP.IsUnspecified() {
+		// Only addresses of the local address's family
+		// can be reached from it.
+		var same []net.IP
+		for _, ip := range ips {
+			if (ip.To4() != nil) == (la.IP.To4() != nil) {
+				same = append(same, ip)
+			}
+		}
+		if len(same) == 0 {
+			return nil, &net.AddrError{Err: "no address of the family of local address " + la.String(), Addr: host}
+		}
+		ips = same
+	}
+
+	var primaries, fallbacks []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(fallbacks) == 0 || t.FallbackDelay < 0 {
+		return dialSerial(d, append(primaries, fallbacks...), port)
+	}
+
+	type dialResult struct {
+		c       net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+	dial := func(ips []net.IP, primary bool) {
+		c, err := dialSerial(d, ips, port)
+		select {
+		case results <- dialResult{c, err, primary}:
+		case <-returned:
+			// The other family won.
+			if c != nil {
+				c.Close()
+			}
+		}
+	}
+
+	delay := t.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	go dial(primaries, true)
+	pending := 1
+	fallbackc := timeAfter(delay)
+	var firstErr error
+	for {
+		select {
+		case <-fallbackc:
+			fallbackc = nil
+			go dial(fallbacks, false)
+			pending++
+		case r := <-results:
+			if r.err == nil {
+				return r.c, nil
+			}
+			pending--
+			if r.primary || firstErr == nil {
+				firstErr = r.err
+			}
+			if fallbackc != nil {
+				// Don't wait out the delay once the
+				// primaries have failed.
+				fallbackc = nil
+				go dial(fallbacks, false)
+				pending++
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// lookupDeadline resolves host, giving up at deadline if it is
+// non-zero.
+func (t *Transport) lookupDeadline(host string, deadline time.Time) ([]net.IP, error) {
+	if deadline.IsZero() {
+		return t.lookupIP(host)
+	}
+	type lookupResult struct {
+		ips []net.IP
+		err error
+	}
+	ch := make(chan lookupResult, 1)
+	go func() {
+		ips, err := t.lookupIP(host)
+		ch <- lookupResult{ips, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.ips, r.err
+	case <-timeAfter(deadline.Sub(timeNow())):
+		return nil, &TimeoutError{Limit: LimitDialTimeout}
+	}
+}
+
+// dialSerial connects to the first of ips that accepts a connection
+// on port, returning the error from the first if none does.
+func dialSerial(d *net.Dialer, ips []net.IP, port string) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range ips {
+		c, err := dialTCPAddr(d, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if !d.Deadline.IsZero() && !time.Now().Before(d.Deadline) {
+			break
+		}
+	}
+	return nil, firstErr
+}
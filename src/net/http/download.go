@@ -0,0 +1,194 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Download fetches a resource into an io.WriterAt, using Range
+// requests to resume after an interrupted transfer rather than
+// starting over. Its requests ask for the identity encoding, so that
+// the ranges count bytes of the resource as stored.
+//
+// A resumed transfer sends the resource's validator, its ETag or
+// Last-Modified time, in an If-Range header, so that the server sends
+// the whole resource again if it has changed, and the Download starts
+// over. Servers that do not support ranges also send the whole
+// resource, and answers with Accept-Ranges "none" are not resumed.
+//
+// A Download's fields record its progress; a Download whose Run
+// failed can be saved and run again later to pick up where it left
+// off.
+type Download struct {
+	// URL is the resource to fetch.
+	URL string
+
+	// Dest receives the resource's bytes, each at its offset in
+	// the resource. When the transfer starts over, because the
+	// resource changed or can't be resumed, Dest is truncated to
+	// zero length if it has a Truncate(size int64) error method,
+	// as *os.File does. Otherwise, if the new version is shorter,
+	// Dest is left with bytes of the old one past its end.
+	Dest io.WriterAt
+
+	// Client is used to make the requests.
+	// If nil, DefaultClient is used.
+	Client *Client
+
+	// MaxAttempts is the number of requests Run makes before
+	// giving up on a transfer that keeps being interrupted.
+	// If zero, 1 is used.
+	MaxAttempts int
+
+	// Written is the number of leading bytes of the resource
+	// already in Dest. Run starts from there and updates it as it
+	// goes.
+	Written int64
+
+	// Size is the resource's size, or -1 if the server did not
+	// report it. It is set by Run.
+	Size int64
+
+	// Validator identifies the version of the resource in Dest.
+	// It is set by Run and sent in If-Range when resuming; a
+	// transfer with no Validator is not resumed.
+	Validator string
+}
+
+// Run fetches the rest of d's resource into d.Dest. It returns nil
+// once the whole resource has been written.
+func (d *Download) Run() error {
+	c := d.Client
+	if c == nil {
+		c = DefaultClient
+	}
+	attempts := d.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for try := 0; try < attempts; try++ {
+		var resumable bool
+		resumable, err = d.fetch(c)
+		if err == nil || !resumable {
+			return err
+		}
+	}
+	return err
+}
+
+// fetch makes one request for the remainder of d's resource. It
+// reports whether a later request could resume the transfer.
+func (d *Download) fetch(c *Client) (resumable bool, err error) {
+	if d.Validator == "" {
+		d.Written = 0
+	}
+	req, err := NewRequest("GET", d.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	// With a compressed encoding, the Transport's transparent
+	// decompression would make Written count bytes of another
+	// representation than the one ranges are of.
+	req.Header.Set("Accept-Encoding", "identity")
+	if d.Written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.Written))
+		req.Header.Set("If-Range", d.Validator)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return d.Validator != "", err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case StatusOK:
+		// The whole resource: it was asked for, or it has changed,
+		// or the server doesn't support ranges. Start over.
+		if t, ok := d.Dest.(interface {
+			Truncate(int64) error
+		}); ok {
+			if err := t.Truncate(0); err != nil {
+				return false, err
+			}
+		}
+		d.Written = 0
+		d.Size = res.ContentLength
+		d.Validator = validator(res)
+	case StatusPartialContent:
+		start, size, ok := parseContentRange(res.Header.get("Content-Range"))
+		if !ok || start != d.Written {
+			return false, fmt.Errorf("http: bad Content-Range %q resuming download at byte %d", res.Header.get("Content-Range"), d.Written)
+		}
+		d.Size = size
+	case StatusRequestedRangeNotSatisfiable:
+		if d.Written > 0 && d.Written == d.Size {
+			return false, nil
+		}
+		fallthrough
+	default:
+		return false, fmt.Errorf("http: unexpected status %q downloading %s", res.Status, d.URL)
+	}
+
+	_, err = io.Copy(&offsetWriter{d.Dest, &d.Written}, res.Body)
+	if err == nil && d.Size >= 0 && d.Written != d.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return d.Validator != "" && res.Header.get("Accept-Ranges") != "none", err
+}
+
+// validator returns the strong validator of res to use in If-Range:
+// its ETag unless that is weak, or else its Last-Modified time.
+func validator(res *Response) string {
+	if etag := res.Header.get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return res.Header.get("Last-Modified")
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes first-last/size", returning size -1 if it is "*".
+func parseContentRange(s string) (first, size int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, false
+	}
+	s = s[len("bytes "):]
+	slash := strings.Index(s, "/")
+	dash := strings.Index(s, "-")
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(s[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if _, err := strconv.ParseInt(s[dash+1:slash], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if s[slash+1:] == "*" {
+		return first, -1, true
+	}
+	size, err = strconv.ParseInt(s[slash+1:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return first, size, true
+}
+
+// offsetWriter writes to w at *off, advancing *off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off *int64
+}
+
+func (o *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = o.w.WriteAt(p, *o.off)
+	*o.off += int64(n)
+	return
+}
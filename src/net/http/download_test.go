@@ -0,0 +1,159 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"fmt"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFile is an in-memory io.WriterAt that can be truncated.
+type memFile struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if need := int(off) + len(p); need > len(f.buf) {
+		f.buf = append(f.buf, make([]byte, need-len(f.buf))...)
+	}
+	copy(f.buf[off:], p)
+	return len(p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf = f.buf[:size]
+	return nil
+}
+
+func (f *memFile) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return string(f.buf)
+}
+
+func TestDownloadResume(t *testing.T) {
+	defer afterTest(t)
+	content := strings.Repeat("0123456789", 100)
+	var mu sync.Mutex
+	var reqs []string
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		n := len(reqs)
+		reqs = append(reqs, fmt.Sprintf("Range=%q If-Range=%q Accept-Encoding=%q", r.Header.Get("Range"), r.Header.Get("If-Range"), r.Header.Get("Accept-Encoding")))
+		mu.Unlock()
+		if n == 0 {
+			// Cut the first transfer short.
+			conn, bufrw, err := w.(Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			fmt.Fprintf(bufrw, "HTTP/1.1 200 OK\r\nETag: \"v1\"\r\nAccept-Ranges: bytes\r\nContent-Length: %d\r\n\r\n%s", len(content), content[:300])
+			bufrw.Flush()
+			conn.Close()
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	dest := new(memFile)
+	d := &Download{URL: ts.URL, Dest: dest, MaxAttempts: 2}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != content {
+		t.Errorf("downloaded %d bytes, %q...; want %d bytes", len(dest.String()), dest.String()[:20], len(content))
+	}
+	if d.Written != int64(len(content)) || d.Size != int64(len(content)) || d.Validator != `"v1"` {
+		t.Errorf("Download = Written %d, Size %d, Validator %s; want %d, %d, \"v1\"", d.Written, d.Size, d.Validator, len(content), len(content))
+	}
+	want := []string{
+		`Range="" If-Range="" Accept-Encoding="identity"`,
+		`Range="bytes=300-" If-Range="\"v1\"" Accept-Encoding="identity"`,
+	}
+	if fmt.Sprint(reqs) != fmt.Sprint(want) {
+		t.Errorf("requests:\n%q\nwant:\n%q", reqs, want)
+	}
+
+	// Running a finished Download again writes nothing.
+	reqs = nil
+	if err := d.Run(); err != nil {
+		t.Errorf("second Run: %v", err)
+	}
+	if dest.String() != content {
+		t.Error("second Run changed the destination")
+	}
+}
+
+func TestDownloadChanged(t *testing.T) {
+	defer afterTest(t)
+	content := "the new version of the resource"
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("ETag", `"v2"`)
+		ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	// A download of the old version was interrupted.
+	dest := &memFile{buf: []byte("the old")}
+	d := &Download{URL: ts.URL, Dest: dest, Written: 7, Validator: `"v1"`}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dest.String(); got != content {
+		t.Errorf("downloaded %q; want %q", got, content)
+	}
+	if d.Validator != `"v2"` {
+		t.Errorf("Validator = %s; want \"v2\"", d.Validator)
+	}
+
+	// A longer old version is truncated.
+	dest = &memFile{buf: []byte("the old version of the resource, much longer")}
+	d = &Download{URL: ts.URL, Dest: dest, Written: 7, Validator: `"v1"`}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dest.String(); got != content {
+		t.Errorf("downloaded over a longer version: %q; want %q", got, content)
+	}
+}
+
+func TestDownloadNotResumable(t *testing.T) {
+	defer afterTest(t)
+	var mu sync.Mutex
+	n := 0
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		conn, bufrw, _ := w.(Hijacker).Hijack()
+		fmt.Fprintf(bufrw, "HTTP/1.1 200 OK\r\nAccept-Ranges: none\r\nETag: \"v1\"\r\nContent-Length: 10\r\n\r\n01234")
+		bufrw.Flush()
+		conn.Close()
+	}))
+	defer ts.Close()
+
+	d := &Download{URL: ts.URL, Dest: new(memFile), MaxAttempts: 3}
+	if err := d.Run(); err == nil {
+		t.Fatal("Run succeeded on a truncated body")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if n != 1 {
+		t.Errorf("made %d requests; want 1 when the server doesn't accept ranges", n)
+	}
+}
@@ -19,6 +19,10 @@ func NewLoggingConn(baseName string, c net.Conn) net.Conn {
 
 var ExportAppendTime = appendTime
 
+var ExportHasChallenge = hasChallenge
+
+var ExportReadLineLimit = readLineLimit
+
 func (t *Transport) NumPendingRequestsForTesting() int {
 	t.reqMu.Lock()
 	defer t.reqMu.Unlock()
@@ -27,45 +31,48 @@ func (t *Transport) NumPendingRequestsForTesting() int {
 
 func (t *Transport) IdleConnKeysForTesting() (keys []string) {
 	keys = make([]string, 0)
-	t.idleMu.Lock()
-	defer t.idleMu.Unlock()
-	if t.idleConn == nil {
-		return
-	}
-	for key := range t.idleConn {
-		keys = append(keys, key.String())
+	seen := make(map[string]bool)
+	for _, c := range t.IdleConns() {
+		key := idleConnKey(c)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
 	}
 	return
 }
 
-func (t *Transport) IdleConnCountForTesting(cacheKey string) int {
-	t.idleMu.Lock()
-	defer t.idleMu.Unlock()
-	if t.idleConn == nil {
-		return 0
-	}
-	for k, conns := range t.idleConn {
-		if k.String() == cacheKey {
-			return len(conns)
+func (t *Transport) IdleConnCountForTesting(cacheKey string) (n int) {
+	for _, c := range t.IdleConns() {
+		if idleConnKey(c) == cacheKey {
+			n++
 		}
 	}
-	return 0
+	return
+}
+
+// idleConnKey returns the connectMethodKey, in string form, of the
+// idle conn described by c.
+func idleConnKey(c IdleConnInfo) string {
+	return connectMethodKey{proxy: c.Proxy, scheme: c.Scheme, addr: c.Addr}.String()
 }
 
 func (t *Transport) IdleConnChMapSizeForTesting() int {
-	t.idleMu.Lock()
-	defer t.idleMu.Unlock()
-	return len(t.idleConnCh)
+	p := t.pool()
+	p.idleMu.Lock()
+	defer p.idleMu.Unlock()
+	return len(p.idleConnCh)
 }
 
 func (t *Transport) IsIdleForTesting() bool {
-	t.idleMu.Lock()
-	defer t.idleMu.Unlock()
-	return t.wantIdle
+	p := t.pool()
+	p.idleMu.Lock()
+	defer p.idleMu.Unlock()
+	return p.wantIdle
 }
 
 func (t *Transport) RequestIdleConnChForTesting() {
-	t.getIdleConnCh(connectMethod{nil, "http", "example.com"})
+	t.getIdleConnCh(connectMethod{targetScheme: "http", targetAddr: "example.com"})
 }
 
 func (t *Transport) PutIdleTestConn() bool {
@@ -74,7 +81,7 @@ func (t *Transport) PutIdleTestConn() bool {
 		t:        t,
 		conn:     c,                   // dummy
 		closech:  make(chan struct{}), // so it can be closed
-		cacheKey: connectMethodKey{"", "http", "example.com"},
+		cacheKey: connectMethodKey{scheme: "http", addr: "example.com"},
 	})
 }
 
@@ -103,6 +110,79 @@ func SetPendingDialHooks(before, after func()) {
 	prePendingDial, postPendingDial = before, after
 }
 
+// SetHandlerSlotWaitHook sets the hook that runs when a request
+// starts waiting for a MaxConcurrentRequests slot. It returns a
+// function removing it.
+func SetHandlerSlotWaitHook(f func()) (restore func()) {
+	handlerSlotWait = f
+	return func() { handlerSlotWait = nil }
+}
+
 var ExportServerNewConn = (*Server).newConn
 
 var ExportCloseWriteAndWait = (*conn).closeWriteAndWait
+
+// A Stopper is a timer returned by the afterFunc hook of SetTimeHooks.
+type Stopper interface {
+	Stop() bool
+}
+
+// SetTimeHooks replaces the time source used by the package's
+// timeout machinery. It returns a function restoring the real one.
+func SetTimeHooks(now func() time.Time, after func(time.Duration) <-chan time.Time, afterFunc func(time.Duration, func()) Stopper) (restore func()) {
+	oldNow, oldAfter, oldAfterFunc := timeNow, timeAfter, timeAfterFunc
+	timeNow, timeAfter = now, after
+	timeAfterFunc = func(d time.Duration, f func()) stopper { return afterFunc(d, f) }
+	return func() {
+		timeNow, timeAfter, timeAfterFunc = oldNow, oldAfter, oldAfterFunc
+	}
+}
+
+// SetDialHooks replaces how Transports without a Dial function
+// resolve host names and connect to addresses. It returns a function
+// restoring the real ones.
+func SetDialHooks(lookup func(host string) ([]net.IP, error), dial func(addr string) (net.Conn, error)) (restore func()) {
+	oldLookup, oldDial := lookupIP, dialTCPAddr
+	lookupIP = lookup
+	dialTCPAddr = func(_ *net.Dialer, addr string) (net.Conn, error) { return dial(addr) }
+	return func() {
+		lookupIP, dialTCPAddr = oldLookup, oldDial
+	}
+}
+
+// SetLookupHook replaces how host names are resolved for dialing
+// and by PAC scripts. It returns a function restoring the real one.
+func SetLookupHook(lookup func(host string) ([]net.IP, error)) (restore func()) {
+	old := lookupIP
+	lookupIP = lookup
+	return func() { lookupIP = old }
+}
+
+// SetDigestCnonce makes DigestAuthenticator use cnonce as its client
+// nonce. It returns a function restoring random ones.
+func SetDigestCnonce(cnonce string) (restore func()) {
+	old := digestCnonce
+	digestCnonce = func() string { return cnonce }
+	return func() { digestCnonce = old }
+}
+
+var ExportParseHSTS = parseHSTS
+
+// ServeSPDYEcho serves c, a connection that negotiated SPDY, by
+// answering each request with a body of "spdy " and its path.
+var ServeSPDYEcho = serveSPDYEcho
+
+// RunPACScript compiles a PAC script and calls its FindProxyForURL.
+func RunPACScript(script, url, host string) (string, error) {
+	s, err := compilePAC(script)
+	if err != nil {
+		return "", err
+	}
+	return s.findProxyForURL(url, host)
+}
+
+func (p *PACResolver) CacheSizeForTesting() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cache)
+}
@@ -407,9 +407,64 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 		return
 	}
 
+	// Serve a precompressed version of the file if there is one,
+	// typing it by the uncompressed file's name.
+	typeName := d.Name()
+	if gf, gd := openPrecompressed(w, r, fs, name); gf != nil {
+		defer gf.Close()
+		f, d = gf, gd
+	}
+
 	// serveContent will check modification time
 	sizeFunc := func() (int64, error) { return d.Size(), nil }
-	serveContent(w, r, d.Name(), d.ModTime(), sizeFunc, f)
+	serveContent(w, r, typeName, d.ModTime(), sizeFunc, f)
+}
+
+// openPrecompressed opens the gzip-compressed sibling of the named
+// file, name+".gz", if it exists and the client accepts gzip, and
+// sets the response's Content-Encoding accordingly. It also marks
+// the response as varying by Accept-Encoding whenever the sibling
+// exists. Files whose type can't be determined from their extension
+// are always served uncompressed, as sniffing would see gzip data.
+func openPrecompressed(w ResponseWriter, r *Request, fs FileSystem, name string) (File, os.FileInfo) {
+	if strings.HasSuffix(name, ".gz") || mime.TypeByExtension(filepath.Ext(name)) == "" {
+		return nil, nil
+	}
+	f, err := fs.Open(name + ".gz")
+	if err != nil {
+		return nil, nil
+	}
+	d, err := f.Stat()
+	if err != nil || d.IsDir() {
+		f.Close()
+		return nil, nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r.Header.get("Accept-Encoding")) {
+		f.Close()
+		return nil, nil
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	return f, d
+}
+
+// acceptsGzip reports whether the Accept-Encoding header value v
+// lists gzip without a zero quality value.
+func acceptsGzip(v string) bool {
+	for _, coding := range strings.Split(v, ",") {
+		params := strings.Split(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.Replace(p, " ", "", -1)
+			if p == "q=0" || strings.HasPrefix(p, "q=0.") && strings.Trim(p[len("q=0."):], "0") == "" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
 // localRedirect gives a Moved Permanently response.
@@ -435,6 +490,10 @@ type fileHandler struct {
 // FileServer returns a handler that serves HTTP requests
 // with the contents of the file system rooted at root.
 //
+// If a file such as "style.css" has a precompressed sibling
+// "style.css.gz", the sibling is served with "Content-Encoding: gzip"
+// to clients that accept gzip.
+//
 // To use the operating system's file system implementation,
 // use http.Dir:
 //
@@ -443,6 +443,68 @@ func TestServeFileWithContentEncoding(t *testing.T) {
 	}
 }
 
+func TestFileServerPrecompressed(t *testing.T) {
+	defer afterTest(t)
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer mustRemoveAll(tempDir)
+	files := map[string]string{
+		"style.css":    "plain css",
+		"style.css.gz": "gzipped css",
+		"other.css":    "plain other",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	ts := httptest.NewServer(FileServer(Dir(tempDir)))
+	defer ts.Close()
+
+	tests := []struct {
+		path, acceptEncoding string
+		wantBody, wantCE     string
+		wantVary             string
+	}{
+		{"/style.css", "gzip, deflate", "gzipped css", "gzip", "Accept-Encoding"},
+		{"/style.css", "deflate;q=1, GZIP;q=0.5", "gzipped css", "gzip", "Accept-Encoding"},
+		{"/style.css", "gzip;q=0", "plain css", "", "Accept-Encoding"},
+		{"/style.css", "identity", "plain css", "", "Accept-Encoding"},
+		{"/style.css.gz", "gzip", "gzipped css", "", ""},
+		{"/other.css", "gzip", "plain other", "", ""},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", ts.URL+tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := tt.path + " with " + tt.acceptEncoding
+		if string(b) != tt.wantBody {
+			t.Errorf("%s: body = %q; want %q", name, b, tt.wantBody)
+		}
+		if g := res.Header.Get("Content-Encoding"); g != tt.wantCE {
+			t.Errorf("%s: Content-Encoding = %q; want %q", name, g, tt.wantCE)
+		}
+		if g := res.Header.Get("Vary"); g != tt.wantVary {
+			t.Errorf("%s: Vary = %q; want %q", name, g, tt.wantVary)
+		}
+		if tt.wantCE != "" {
+			if g, e := res.Header.Get("Content-Type"), "text/css; charset=utf-8"; g != e {
+				t.Errorf("%s: Content-Type = %q; want %q", name, g, e)
+			}
+		}
+	}
+}
+
 func TestServeIndexHtml(t *testing.T) {
 	defer afterTest(t)
 	const want = "index.html says hello\n"
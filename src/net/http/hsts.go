@@ -0,0 +1,184 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An HSTSStore records the HTTP Strict Transport Security policies
+// of hosts, as described in RFC 6797, and answers whether a request
+// to a host must be upgraded to HTTPS. The Client parses the
+// Strict-Transport-Security headers; a store only keeps the results,
+// so an implementation may persist them anywhere.
+//
+// Host names passed to an HSTSStore are lower case, without a port
+// or trailing dot, and never IP addresses.
+//
+// Implementations of HSTSStore must be safe for concurrent use by
+// multiple goroutines.
+type HSTSStore interface {
+	// SetPolicy records that host requires HTTPS until expires,
+	// for its subdomains too if includeSubdomains is set. An
+	// expires not after the current time removes host's policy.
+	SetPolicy(host string, expires time.Time, includeSubdomains bool)
+
+	// MustUpgrade reports whether host, or a superdomain whose
+	// policy includes subdomains, has a policy in effect.
+	MustUpgrade(host string) bool
+}
+
+// An HSTSCache is an in-memory HSTSStore. The zero value is an empty
+// cache ready to use.
+type HSTSCache struct {
+	mu       sync.Mutex
+	policies map[string]hstsPolicy
+}
+
+type hstsPolicy struct {
+	expires           time.Time
+	includeSubdomains bool
+}
+
+// SetPolicy records that host requires HTTPS until expires, for its
+// subdomains too if includeSubdomains is set, replacing any policy
+// it had. An expires not after the current time removes the policy.
+func (c *HSTSCache) SetPolicy(host string, expires time.Time, includeSubdomains bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !expires.After(timeNow()) {
+		delete(c.policies, host)
+		return
+	}
+	if c.policies == nil {
+		c.policies = make(map[string]hstsPolicy)
+	}
+	c.policies[host] = hstsPolicy{expires, includeSubdomains}
+}
+
+// MustUpgrade reports whether host, or a superdomain whose policy
+// includes subdomains, has a policy that has not expired. Expired
+// policies found along the way are removed.
+func (c *HSTSCache) MustUpgrade(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := timeNow()
+	for superdomain := false; ; superdomain = true {
+		if p, ok := c.policies[host]; ok {
+			if !p.expires.After(now) {
+				delete(c.policies, host)
+			} else if !superdomain || p.includeSubdomains {
+				return true
+			}
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+	}
+}
+
+// hstsHost returns the host name in hostport as an HSTSStore expects
+// it, or "" if it is an IP address.
+func hstsHost(hostport string) string {
+	host := hostport
+	if hasPort(hostport) {
+		host, _, _ = net.SplitHostPort(hostport)
+	}
+	host = strings.TrimPrefix(strings.TrimSuffix(host, "]"), "[")
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
+
+// parseHSTS parses the value of a Strict-Transport-Security header,
+// reporting ok false if it is invalid.
+func parseHSTS(v string) (maxAge time.Duration, includeSubdomains, ok bool) {
+	seen := make(map[string]bool)
+	for _, d := range splitUnquoted(v, ';') {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name, value := d, ""
+		if i := strings.Index(d, "="); i >= 0 {
+			name, value = strings.TrimSpace(d[:i]), unquote(strings.TrimSpace(d[i+1:]))
+		}
+		name = strings.ToLower(name)
+		if seen[name] {
+			// Directives must not appear more than once.
+			return 0, false, false
+		}
+		seen[name] = true
+		switch name {
+		case "max-age":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return 0, false, false
+			}
+			if n > int64(maxInt64/int64(time.Second)) {
+				n = int64(maxInt64 / int64(time.Second))
+			}
+			maxAge = time.Duration(n) * time.Second
+		case "includesubdomains":
+			includeSubdomains = true
+		}
+	}
+	if !seen["max-age"] {
+		return 0, false, false
+	}
+	return maxAge, includeSubdomains, true
+}
+
+// hstsUpgrade returns a copy of req upgraded to HTTPS if the Client's
+// HSTS store requires it, and nil otherwise. An explicit port 80
+// becomes 443; other ports are kept.
+func (c *Client) hstsUpgrade(req *Request) *Request {
+	if c.HSTS == nil || req.URL == nil || req.URL.Scheme != "http" {
+		return nil
+	}
+	host := hstsHost(req.URL.Host)
+	if host == "" || !c.HSTS.MustUpgrade(host) {
+		return nil
+	}
+	nreq := req.Clone()
+	nreq.URL.Scheme = "https"
+	if h, port, err := net.SplitHostPort(req.URL.Host); err == nil && port == "80" {
+		nreq.URL.Host = net.JoinHostPort(h, "443")
+		if nreq.Host == req.URL.Host {
+			nreq.Host = nreq.URL.Host
+		}
+	}
+	return nreq
+}
+
+// recordHSTS records in the Client's HSTS store the policy sent in
+// resp, the response to req. Policies are only honored when received
+// over TLS.
+func (c *Client) recordHSTS(req *Request, resp *Response) {
+	if c.HSTS == nil || resp.TLS == nil {
+		return
+	}
+	v := resp.Header.Get("Strict-Transport-Security")
+	if v == "" {
+		return
+	}
+	host := hstsHost(req.URL.Host)
+	if host == "" {
+		return
+	}
+	maxAge, includeSubdomains, ok := parseHSTS(v)
+	if !ok {
+		return
+	}
+	c.HSTS.SetPolicy(host, timeNow().Add(maxAge), includeSubdomains)
+}
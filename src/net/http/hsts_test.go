@@ -0,0 +1,144 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseHSTS(t *testing.T) {
+	tests := []struct {
+		v                 string
+		maxAge            time.Duration
+		includeSubdomains bool
+		ok                bool
+	}{
+		{"max-age=31536000", 31536000 * time.Second, false, true},
+		{`Max-Age="60"; includeSubDomains`, time.Minute, true, true},
+		{"includeSubDomains; max-age=0; preload", 0, true, true},
+		{"max-age=60;; unknown=x", time.Minute, false, true},
+		{"", 0, false, false},
+		{"includeSubDomains", 0, false, false},
+		{"max-age=-1", 0, false, false},
+		{"max-age=x", 0, false, false},
+		{"max-age=1; max-age=2", 0, false, false},
+	}
+	for _, tt := range tests {
+		maxAge, sub, ok := ExportParseHSTS(tt.v)
+		if maxAge != tt.maxAge || sub != tt.includeSubdomains || ok != tt.ok {
+			t.Errorf("parseHSTS(%q) = %v, %v, %v; want %v, %v, %v", tt.v, maxAge, sub, ok, tt.maxAge, tt.includeSubdomains, tt.ok)
+		}
+	}
+}
+
+func TestHSTSCache(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+
+	var c HSTSCache
+	now := clock.Now()
+	c.SetPolicy("example.com", now.Add(time.Hour), false)
+	c.SetPolicy("sub.example.org", now.Add(2*time.Hour), true)
+	check := func(when string, want map[string]bool) {
+		for host, upgrade := range want {
+			if got := c.MustUpgrade(host); got != upgrade {
+				t.Errorf("%s: MustUpgrade(%q) = %v; want %v", when, host, got, upgrade)
+			}
+		}
+	}
+	check("initially", map[string]bool{
+		"example.com":          true,
+		"www.example.com":      false,
+		"sub.example.org":      true,
+		"a.b.sub.example.org":  true,
+		"example.org":          false,
+		"notsub.example.org":   false,
+		"other.com":            false,
+		"sub.example.org.evil": false,
+	})
+
+	clock.Advance(90 * time.Minute)
+	check("after expiry", map[string]bool{
+		"example.com":       false,
+		"x.sub.example.org": true,
+	})
+
+	c.SetPolicy("sub.example.org", clock.Now(), true)
+	check("after removal", map[string]bool{
+		"sub.example.org":   false,
+		"x.sub.example.org": false,
+	})
+}
+
+func TestClientHSTS(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/set" {
+			w.Header().Set("Strict-Transport-Security", "max-age=3600; includeSubDomains")
+		}
+	}))
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// Every host name reaches the test server.
+	tr := &Transport{
+		Dial: func(network, _ string) (net.Conn, error) {
+			return net.Dial(network, ts.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer tr.CloseIdleConnections()
+	hsts := new(HSTSCache)
+	c := &Client{Transport: tr, HSTS: hsts}
+
+	get := func(url string) *Response {
+		res, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("Get %s: %v", url, err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	get("https://example.com:" + port + "/set")
+	if !hsts.MustUpgrade("www.example.com") {
+		t.Fatal("policy not recorded")
+	}
+	res := get("http://www.example.com:" + port + "/")
+	if res.TLS == nil || res.Request.URL.Scheme != "https" {
+		t.Errorf("request for http URL made to %v; want upgrade to https", res.Request.URL)
+	}
+
+	// Policies sent over plain HTTP are ignored.
+	plain := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=3600")
+	}))
+	defer plain.Close()
+	tr.Dial = func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, plain.Listener.Addr().String())
+	}
+	get("http://plain.example/")
+	if hsts.MustUpgrade("plain.example") {
+		t.Error("policy recorded from a plain HTTP response")
+	}
+	tr.Dial = func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, ts.Listener.Addr().String())
+	}
+
+	// IP addresses never get a policy.
+	get("https://127.0.0.1:" + port + "/set")
+	if hsts.MustUpgrade("127.0.0.1") {
+		t.Error("policy recorded for an IP address")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Fault injection for connections
+
+package httptest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Fault describes how a connection misbehaves. The zero Fault
+// passes all data through unchanged.
+type Fault struct {
+	// Latency is the delay added before each Read and Write.
+	Latency time.Duration
+
+	// BytesPerSecond, if positive, caps the rate at which data is
+	// written to the connection.
+	BytesPerSecond int64
+
+	// MaxWrite, if positive, splits each Write into writes of at
+	// most MaxWrite bytes, so the peer sees the data arrive in
+	// pieces.
+	MaxWrite int
+
+	// ShortWrite, if positive, makes each Write of more than
+	// ShortWrite bytes write only the first ShortWrite of them and
+	// return io.ErrShortWrite, as a connection that stops taking
+	// data partway through a Write would.
+	ShortWrite int
+
+	// CloseAfterWrites, if positive, closes the connection after
+	// that many Writes, as a server closing a kept-alive
+	// connection between responses would. Later Writes fail.
+	CloseAfterWrites int
+
+	// ResetAfter, if positive, resets the connection once that
+	// many bytes have been written to it in total. The Write that
+	// crosses the limit is truncated and returns ErrFaultReset.
+	ResetAfter int64
+}
+
+// ErrFaultReset is returned by Write on a connection that was reset
+// by its Fault's ResetAfter limit.
+var ErrFaultReset = errors.New("httptest: connection reset by fault")
+
+// faultScript hands out Faults to connections in the order they are
+// made.
+type faultScript struct {
+	mu     sync.Mutex
+	faults []*Fault
+	n      int
+}
+
+func (s *faultScript) wrap(c net.Conn) net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.n
+	s.n++
+	if n >= len(s.faults) || s.faults[n] == nil {
+		return c
+	}
+	return &faultConn{Conn: c, f: *s.faults[n]}
+}
+
+// NewFaultListener returns a Listener that accepts connections from
+// l and makes the nth connection misbehave as described by
+// script[n]. Connections with a nil entry or beyond the end of the
+// script are passed through unchanged.
+//
+// To inject faults into a Server's connections, wrap its Listener
+// before starting it:
+//
+//	ts := httptest.NewUnstartedServer(h)
+//	ts.Listener = httptest.NewFaultListener(ts.Listener, script)
+//	ts.Start()
+func NewFaultListener(l net.Listener, script []*Fault) net.Listener {
+	return &faultListener{Listener: l, s: &faultScript{faults: script}}
+}
+
+type faultListener struct {
+	net.Listener
+	s *faultScript
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.s.wrap(c), nil
+}
+
+// FaultDial returns a dial function, suitable for http.Transport's
+// Dial field, that makes the nth connection created by dial
+// misbehave as described by script[n], as for NewFaultListener. If
+// dial is nil, net.Dial is used.
+func FaultDial(dial func(network, addr string) (net.Conn, error), script []*Fault) func(network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = net.Dial
+	}
+	s := &faultScript{faults: script}
+	return func(network, addr string) (net.Conn, error) {
+		c, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return s.wrap(c), nil
+	}
+}
+
+type faultConn struct {
+	net.Conn
+	f Fault
+
+	mu      sync.Mutex // serializes Writes
+	written int64
+	writes  int
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if c.f.Latency > 0 {
+		time.Sleep(c.f.Latency)
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *faultConn) Write(p []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f.Latency > 0 {
+		time.Sleep(c.f.Latency)
+	}
+	if c.f.CloseAfterWrites > 0 {
+		if c.writes++; c.writes == c.f.CloseAfterWrites {
+			defer c.Conn.Close()
+		}
+	}
+	if c.f.ShortWrite > 0 && len(p) > c.f.ShortWrite {
+		n, err = c.write(p[:c.f.ShortWrite])
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return n, err
+	}
+	return c.write(p)
+}
+
+// write writes p, applying the Fault's rate and size limits.
+func (c *faultConn) write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if c.f.MaxWrite > 0 && len(chunk) > c.f.MaxWrite {
+			chunk = chunk[:c.f.MaxWrite]
+		}
+		if c.f.ResetAfter > 0 {
+			left := c.f.ResetAfter - c.written
+			if left <= 0 {
+				c.reset()
+				return n, ErrFaultReset
+			}
+			if int64(len(chunk)) > left {
+				chunk = chunk[:left]
+			}
+		}
+		m, err := c.Conn.Write(chunk)
+		n += m
+		c.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		if c.f.BytesPerSecond > 0 {
+			time.Sleep(time.Duration(int64(m) * int64(time.Second) / c.f.BytesPerSecond))
+		}
+		p = p[m:]
+	}
+	if c.f.ResetAfter > 0 && c.written >= c.f.ResetAfter {
+		c.reset()
+	}
+	return n, nil
+}
+
+// reset closes the connection, abortively if it is a TCP
+// connection, so the peer sees a reset rather than a clean EOF.
+func (c *faultConn) reset() {
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Conn.Close()
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httptest
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFaultListenerReset(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	ts := NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	ts.Listener = NewFaultListener(ts.Listener, []*Fault{{ResetAfter: 200}})
+	ts.Start()
+	defer ts.Close()
+	c := &http.Client{Transport: &http.Transport{}}
+
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil {
+		t.Fatalf("read %d bytes from reset connection without error", len(got))
+	}
+
+	// The second connection is past the end of the script.
+	res, err = c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(got) != body {
+		t.Errorf("second request read %d bytes, %v; want %d bytes", len(got), err, len(body))
+	}
+}
+
+func TestFaultDial(t *testing.T) {
+	ts := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer ts.Close()
+	tr := &http.Transport{
+		Dial:              FaultDial(nil, []*Fault{{MaxWrite: 1}, {ResetAfter: 10}}),
+		DisableKeepAlives: true,
+	}
+	c := &http.Client{Transport: tr}
+
+	res, err := c.Post(ts.URL, "text/plain", strings.NewReader("split into single bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(got) != "split into single bytes" {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	if res, err := c.Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Error("request over reset connection succeeded")
+	}
+}
+
+func TestFaultShortWrite(t *testing.T) {
+	ts := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	c, err := FaultDial(nil, []*Fault{{ShortWrite: 4}})("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if n, err := c.Write([]byte("GET / HTTP/1.1\r\n")); n != 4 || err != io.ErrShortWrite {
+		t.Errorf("Write = %d, %v; want 4, io.ErrShortWrite", n, err)
+	}
+	if n, err := c.Write([]byte("abc")); n != 3 || err != nil {
+		t.Errorf("short enough Write = %d, %v; want 3, nil", n, err)
+	}
+}
+
+func TestFaultListenerCloseAfterWrites(t *testing.T) {
+	ts := NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ts.Listener = NewFaultListener(ts.Listener, []*Fault{{CloseAfterWrites: 1}})
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+		res, err := http.ReadResponse(br, nil)
+		if i == 1 {
+			if err == nil {
+				t.Errorf("second request on closed connection got %s", res.Status)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil || string(b) != "ok" {
+			t.Fatalf("first response = %q, %v", b, err)
+		}
+	}
+}
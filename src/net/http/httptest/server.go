@@ -8,11 +8,14 @@ package httptest
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 )
 
@@ -31,6 +34,17 @@ type Server struct {
 	// before Start or StartTLS.
 	Config *http.Server
 
+	// ReportError, if non-nil, is called with each error the HTTP
+	// server logs, such as handler panics (with their stack
+	// traces) and failed TLS handshakes, so that tests can assert
+	// on them instead of their only appearing on stderr:
+	//
+	//	ts.ReportError = func(err error) { t.Error(err) }
+	//
+	// It must be set before Start or StartTLS, and is only used if
+	// Config.ErrorLog is nil. It may be called concurrently.
+	ReportError func(error)
+
 	// wg counts the number of outstanding HTTP requests on this server.
 	// Close blocks until all requests are finished.
 	wg sync.WaitGroup
@@ -142,7 +156,20 @@ func (s *Server) StartTLS() {
 	go s.Config.Serve(s.Listener)
 }
 
+// errorReporter is an io.Writer that passes each log message
+// written to it to a Server's ReportError.
+type errorReporter func(error)
+
+func (r errorReporter) Write(p []byte) (int, error) {
+	r(errors.New(strings.TrimSuffix(string(p), "\n")))
+	return len(p), nil
+}
+
 func (s *Server) wrapHandler() {
+	if s.ReportError != nil && s.Config.ErrorLog == nil {
+		s.Config.ErrorLog = log.New(errorReporter(s.ReportError), "", 0)
+	}
+
 	h := s.Config.Handler
 	if h == nil {
 		h = http.DefaultServeMux
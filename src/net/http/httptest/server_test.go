@@ -7,6 +7,7 @@ package httptest
 import (
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +28,28 @@ func TestServer(t *testing.T) {
 		t.Errorf("got %q, want hello", string(got))
 	}
 }
+
+func TestServerReportError(t *testing.T) {
+	ts := NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	errc := make(chan error, 1)
+	ts.ReportError = func(err error) {
+		select {
+		case errc <- err:
+		default:
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err == nil {
+		res.Body.Close()
+		t.Fatal("expected error from Get of panicking handler")
+	}
+	err = <-errc
+	if msg := err.Error(); !strings.Contains(msg, "panic") || !strings.Contains(msg, "boom") {
+		t.Errorf("reported error %q; want a panic mentioning boom", msg)
+	}
+}
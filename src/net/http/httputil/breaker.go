@@ -0,0 +1,234 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker for requests to a
+// host it is not currently sending requests to.
+var ErrCircuitOpen = errors.New("httputil: circuit breaker open")
+
+// timeNow is the clock of CircuitBreakers. It is replaced by tests.
+var timeNow = time.Now
+
+// A CircuitBreaker is an http.RoundTripper that stops sending
+// requests to a host that keeps failing, so that clients of a dying
+// backend fail fast instead of piling more load onto it.
+//
+// The breaker for each host starts closed, passing requests through.
+// After Threshold consecutive failed requests, or when FailureRate is
+// set and reached, it opens, and requests to the host fail with
+// ErrCircuitOpen for the Cooldown period. It then lets a single probe
+// request through, still failing the others: if the probe succeeds
+// the breaker closes again, and if it fails the breaker opens for
+// another Cooldown.
+type CircuitBreaker struct {
+	// Transport is used to make the requests.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Threshold is the number of consecutive failures that open
+	// a host's breaker. If zero, 5 is used.
+	Threshold int
+
+	// FailureRate, if positive, also opens a host's breaker when
+	// at least that fraction of its requests fail, out of at
+	// least MinRequests made in one Interval. It catches a host
+	// failing often, but not often enough in a row to reach
+	// Threshold.
+	FailureRate float64
+
+	// MinRequests is the number of requests to a host in an
+	// Interval below which FailureRate is not applied.
+	// If zero, 20 is used.
+	MinRequests int
+
+	// Interval is the period over which FailureRate is measured:
+	// a host's counts of requests and failures start again from
+	// zero every Interval. If zero, 10 seconds is used.
+	Interval time.Duration
+
+	// Cooldown is how long an open breaker fails requests before
+	// letting a probe through. If zero, 30 seconds is used.
+	Cooldown time.Duration
+
+	// IsFailure reports whether a round trip failed. If nil, errors
+	// and responses with a 5xx status code are failures.
+	IsFailure func(*http.Response, error) bool
+
+	forwarder
+	mu       sync.Mutex
+	hosts    map[string]*breakerState
+	pruneLen int // len(hosts) at which to drop idle closed breakers
+}
+
+// breakerState is the state of a CircuitBreaker for one host.
+type breakerState struct {
+	failures  int       // consecutive failures
+	openUntil time.Time // if non-zero, the breaker is open until then
+	probing   bool      // a probe request is in flight
+
+	// For FailureRate, the requests made since start, and how
+	// many of them failed.
+	start            time.Time
+	requests, failed int
+}
+
+func (b *CircuitBreaker) transport() http.RoundTripper {
+	if b.Transport == nil {
+		return http.DefaultTransport
+	}
+	return b.Transport
+}
+
+// RoundTrip implements the http.RoundTripper interface. It fails
+// with ErrCircuitOpen if the breaker of req's host is open, and
+// otherwise passes req on to Transport and records whether it failed.
+func (b *CircuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	fw := b.start(req)
+	res, err := b.roundTrip(fw, req)
+	return b.finish(req, res, err)
+}
+
+// CancelRequest cancels req, which must be in flight, in Transport if
+// it has a CancelRequest method.
+func (b *CircuitBreaker) CancelRequest(req *http.Request) {
+	b.cancel(b.transport(), req)
+}
+
+func (b *CircuitBreaker) roundTrip(fw *forwarded, req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	probe, ok := b.allow(host)
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrCircuitOpen
+	}
+	res, err := fw.send(b.transport(), req)
+	b.record(host, probe, b.isFailure(res, err))
+	return res, err
+}
+
+// allow reports whether a request to host may be sent, and whether it
+// is the probe of a breaker whose cooldown is over.
+func (b *CircuitBreaker) allow(host string) (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.hosts[host]
+	if s == nil || s.openUntil.IsZero() {
+		return false, true
+	}
+	if s.probing || timeNow().Before(s.openUntil) {
+		return false, false
+	}
+	s.probing = true
+	return true, true
+}
+
+// record updates host's breaker with the outcome of a request.
+func (b *CircuitBreaker) record(host string, probe, failed bool) {
+	now := timeNow()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.hosts[host]
+	if s == nil {
+		if !failed && b.FailureRate <= 0 {
+			return
+		}
+		if b.hosts == nil {
+			b.hosts = make(map[string]*breakerState)
+		}
+		b.prune(now)
+		s = &breakerState{start: now}
+		b.hosts[host] = s
+	}
+	if now.Sub(s.start) >= b.interval() {
+		s.start, s.requests, s.failed = now, 0, 0
+	}
+	s.requests++
+	if !failed {
+		s.failures = 0
+		if probe {
+			s.openUntil, s.probing = time.Time{}, false
+			s.start, s.requests, s.failed = now, 0, 0
+		}
+		if b.FailureRate <= 0 && s.openUntil.IsZero() {
+			delete(b.hosts, host)
+		}
+		return
+	}
+	if probe {
+		s.probing = false
+	}
+	s.failures++
+	s.failed++
+	if probe || s.failures >= b.threshold() || b.rateReached(s) {
+		s.openUntil = now.Add(b.cooldown())
+		s.start, s.requests, s.failed = now, 0, 0
+	}
+}
+
+// rateReached reports whether s has seen enough failures for
+// FailureRate to open it.
+func (b *CircuitBreaker) rateReached(s *breakerState) bool {
+	return b.FailureRate > 0 && s.requests >= b.minRequests() &&
+		float64(s.failed) >= b.FailureRate*float64(s.requests)
+}
+
+// prune drops, once there are enough of them, the states that hold
+// nothing a new one wouldn't: those of closed breakers without
+// consecutive failures whose Interval is over. b.mu must be held.
+func (b *CircuitBreaker) prune(now time.Time) {
+	if len(b.hosts) < b.pruneLen {
+		return
+	}
+	for host, s := range b.hosts {
+		if s.openUntil.IsZero() && s.failures == 0 && now.Sub(s.start) >= b.interval() {
+			delete(b.hosts, host)
+		}
+	}
+	b.pruneLen = 2*len(b.hosts) + 16
+}
+
+func (b *CircuitBreaker) isFailure(res *http.Response, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(res, err)
+	}
+	return err != nil || res.StatusCode >= 500
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+	return 5
+}
+
+func (b *CircuitBreaker) minRequests() int {
+	if b.MinRequests > 0 {
+		return b.MinRequests
+	}
+	return 20
+}
+
+func (b *CircuitBreaker) interval() time.Duration {
+	if b.Interval > 0 {
+		return b.Interval
+	}
+	return 10 * time.Second
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return 30 * time.Second
+}
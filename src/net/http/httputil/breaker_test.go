@@ -0,0 +1,156 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// setFakeClock makes timeNow a clock that moves only when advanced.
+// The returned restore function puts back the real one.
+func setFakeClock() (advance func(time.Duration), restore func()) {
+	now := time.Unix(1e9, 0)
+	old := timeNow
+	timeNow = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }, func() { timeNow = old }
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	const cooldown = time.Minute
+	rt := &fakeRoundTripper{codes: []int{500, 0, 200, 500, 0, 500, 200, 503, 200, 200}}
+	b := &CircuitBreaker{Transport: rt, Threshold: 3, Cooldown: cooldown}
+	get := func(url string) error {
+		req, _ := http.NewRequest("GET", url, nil)
+		_, err := b.RoundTrip(req)
+		return err
+	}
+
+	steps := []struct {
+		url   string
+		wait  bool // wait out the cooldown first
+		want  error
+		sends int // requests sent so far
+	}{
+		// A success resets the count of failures.
+		{"http://a/", false, nil, 1},
+		{"http://a/", false, errFake, 2},
+		{"http://a/", false, nil, 3},
+		{"http://a/", false, nil, 4},
+		{"http://a/", false, errFake, 5},
+		// The third consecutive failure opens the breaker...
+		{"http://a/", false, nil, 6},
+		// ...for host a only.
+		{"http://a/", false, ErrCircuitOpen, 6},
+		{"http://b/", false, nil, 7},
+		// A failed probe opens it again.
+		{"http://a/", true, nil, 8},
+		{"http://a/", false, ErrCircuitOpen, 8},
+		// A successful one closes it.
+		{"http://a/", true, nil, 9},
+		{"http://a/", false, nil, 10},
+	}
+	for i, s := range steps {
+		if s.wait {
+			advance(cooldown)
+		}
+		if err := get(s.url); err != s.want {
+			t.Fatalf("%d. GET %s: err = %v; want %v", i, s.url, err, s.want)
+		}
+		if len(rt.reqs) != s.sends {
+			t.Fatalf("%d. GET %s: %d requests sent; want %d", i, s.url, len(rt.reqs), s.sends)
+		}
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	probing := make(chan bool)
+	release := make(chan bool)
+	b := &CircuitBreaker{
+		Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/probe" {
+				probing <- true
+				<-release
+			}
+			return nil, errFake
+		}),
+		Threshold: 1,
+		Cooldown:  time.Minute,
+	}
+	req, _ := http.NewRequest("GET", "http://a/", nil)
+	b.RoundTrip(req)
+	advance(time.Minute)
+
+	done := make(chan error)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://a/probe", nil)
+		_, err := b.RoundTrip(req)
+		done <- err
+	}()
+	<-probing
+	if _, err := b.RoundTrip(req); err != ErrCircuitOpen {
+		t.Errorf("request during probe: err = %v; want ErrCircuitOpen", err)
+	}
+	close(release)
+	if err := <-done; err != errFake {
+		t.Errorf("probe: err = %v; want %v", err, errFake)
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	rt := &fakeRoundTripper{codes: []int{200, 500, 200, 500, 200, 500, 200, 500}}
+	b := &CircuitBreaker{Transport: rt, FailureRate: 0.5, MinRequests: 4, Interval: 10 * time.Second}
+	steps := []struct {
+		advance time.Duration
+		want    error
+		sends   int
+	}{
+		{0, nil, 1},
+		{0, nil, 2},
+		{0, nil, 3},
+		// A new Interval: the failure rate of the one before
+		// doesn't count.
+		{10 * time.Second, nil, 4},
+		{0, nil, 5},
+		{0, nil, 6},
+		{0, nil, 7},
+		// 3 failures in 5 requests.
+		{0, nil, 8},
+		{0, ErrCircuitOpen, 8},
+	}
+	for i, s := range steps {
+		advance(s.advance)
+		req, _ := http.NewRequest("GET", "http://a/", nil)
+		if _, err := b.RoundTrip(req); err != s.want {
+			t.Fatalf("%d. err = %v; want %v", i, err, s.want)
+		}
+		if len(rt.reqs) != s.sends {
+			t.Fatalf("%d. %d requests sent; want %d", i, len(rt.reqs), s.sends)
+		}
+	}
+
+	// The hosts counted are forgotten once their Interval is over.
+	codes := make([]int, 100)
+	for i := range codes {
+		codes[i] = 200
+	}
+	b = &CircuitBreaker{Transport: &fakeRoundTripper{codes: codes}, FailureRate: 0.5}
+	for i := range codes {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://h%d/", i), nil)
+		b.RoundTrip(req)
+		advance(time.Second)
+	}
+	if n := len(b.hosts); n > 50 {
+		t.Errorf("%d hosts remembered after requests to %d; want idle ones dropped", n, len(codes))
+	}
+}
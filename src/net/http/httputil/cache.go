@@ -0,0 +1,420 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Cache stores the responses kept by a CachingTransport, each
+// serialized to bytes under a key derived from its request.
+//
+// Implementations of Cache must be safe for concurrent use by
+// multiple goroutines.
+type Cache interface {
+	// Get returns the data stored under key, if any.
+	Get(key string) (data []byte, ok bool)
+
+	// Set stores data under key, replacing any already there.
+	Set(key string, data []byte)
+
+	// Delete removes the data stored under key, if any.
+	Delete(key string)
+}
+
+// A MemoryCache is a Cache that keeps its entries in memory, up to a
+// total size: the least recently used entries are discarded to make
+// room for new ones. The zero value is an empty cache ready to use.
+type MemoryCache struct {
+	// MaxBytes is the most data the cache holds.
+	// If zero, 32 MB is used.
+	MaxBytes int64
+
+	mu   sync.Mutex
+	m    map[string]*list.Element // of *memoryEntry
+	lru  list.List                // most recently used first
+	size int64                    // total length of the entries' data
+}
+
+type memoryEntry struct {
+	key  string
+	data []byte
+}
+
+func (c *MemoryCache) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return 32 << 20
+}
+
+// Get returns the data stored under key, if any, making it the most
+// recently used entry.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*memoryEntry).data, true
+}
+
+// Set stores data under key, replacing any already there, and then
+// discards the least recently used entries until the cache holds
+// no more than MaxBytes. Data longer than MaxBytes is not stored.
+func (c *MemoryCache) Set(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteLocked(key)
+	max := c.maxBytes()
+	if int64(len(data)) > max {
+		return
+	}
+	if c.m == nil {
+		c.m = make(map[string]*list.Element)
+	}
+	c.m[key] = c.lru.PushFront(&memoryEntry{key, data})
+	c.size += int64(len(data))
+	for c.size > max {
+		c.deleteLocked(c.lru.Back().Value.(*memoryEntry).key)
+	}
+}
+
+// Delete removes the data stored under key, if any.
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteLocked(key)
+}
+
+func (c *MemoryCache) deleteLocked(key string) {
+	if e, ok := c.m[key]; ok {
+		c.size -= int64(len(e.Value.(*memoryEntry).data))
+		c.lru.Remove(e)
+		delete(c.m, key)
+	}
+}
+
+// A CachingTransport is an http.RoundTripper that acts as a private
+// HTTP cache, as described in RFC 7234. It keeps responses to GET
+// requests and answers later requests from them while they are fresh
+// according to their Cache-Control, Expires and Last-Modified
+// headers, matching the request header fields named in Vary. Stale
+// responses with an ETag or Last-Modified validator are revalidated
+// with a conditional request, and successful requests with other
+// methods invalidate what is kept for their URL.
+//
+// Responses are read in full before being kept, unless their bodies
+// are longer than MaxEntryBytes; those are passed on as they arrive,
+// without being kept. Requests with a Cache-Control of no-store
+// bypass the cache, and those with no-cache or max-age=0 are always
+// revalidated.
+type CachingTransport struct {
+	// Transport is used to make requests the cache cannot answer.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Cache holds the kept responses.
+	// If nil, a MemoryCache is used.
+	Cache Cache
+
+	// MaxEntryBytes is the longest response body kept.
+	// If zero, 1 MB is used.
+	MaxEntryBytes int64
+
+	forwarder
+	mu  sync.Mutex
+	mem *MemoryCache
+}
+
+func (t *CachingTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *CachingTransport) cache() Cache {
+	if t.Cache != nil {
+		return t.Cache
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mem == nil {
+		t.mem = new(MemoryCache)
+	}
+	return t.mem
+}
+
+func (t *CachingTransport) maxEntryBytes() int64 {
+	if t.MaxEntryBytes > 0 {
+		return t.MaxEntryBytes
+	}
+	return 1 << 20
+}
+
+// RoundTrip implements the http.RoundTripper interface. It answers
+// req from the cache if it can, and otherwise passes it on to
+// Transport, revalidating a stale kept response, and keeps what it
+// gets back if that may be cached.
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fw := t.start(req)
+	res, err := t.roundTrip(fw, req)
+	return t.finish(req, res, err)
+}
+
+// CancelRequest cancels req, which must be in flight, in Transport if
+// it has a CancelRequest method.
+func (t *CachingTransport) CancelRequest(req *http.Request) {
+	t.cancel(t.transport(), req)
+}
+
+func (t *CachingTransport) roundTrip(fw *forwarded, req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return fw.send(t.transport(), req)
+	}
+	if req.Method != "GET" {
+		res, err := fw.send(t.transport(), req)
+		if err == nil && req.Method != "HEAD" && res.StatusCode < 400 {
+			t.cache().Delete(key)
+		}
+		return res, err
+	}
+
+	cached, body := t.lookup(req, key)
+	if cached != nil {
+		_, noCache := reqCC["no-cache"]
+		if !noCache && reqCC["max-age"] != "0" && freshness(cached) > age(cached) {
+			cached.Request = req
+			return cached, nil
+		}
+		if cached.Header.Get("Etag") != "" || cached.Header.Get("Last-Modified") != "" {
+			creq := req.Clone()
+			creq.SetConditional(cached)
+			req = creq
+		}
+	}
+
+	res, err := fw.send(t.transport(), req)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if rres := http.Revalidate(res, cached, body); rres != res {
+			// The response is as fresh as the 304 that validated it.
+			if res.Header.Get("Date") == "" {
+				rres.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+			}
+			if res.Header.Get("Age") == "" {
+				rres.Header.Del("Age")
+			}
+			t.store(req, rres, key, body)
+			return rres, nil
+		}
+	}
+	if !cacheable(res) {
+		if cached != nil {
+			t.cache().Delete(key)
+		}
+		return res, nil
+	}
+	max := t.maxEntryBytes()
+	if res.ContentLength > max {
+		if cached != nil {
+			t.cache().Delete(key)
+		}
+		return res, nil
+	}
+	body, err = ioutil.ReadAll(io.LimitReader(res.Body, max+1))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		// Too long to keep; pass on the rest as it arrives.
+		if cached != nil {
+			t.cache().Delete(key)
+		}
+		res.Body = readCloser{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return res, nil
+	}
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if res.Header.Get("Date") == "" {
+		res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	t.store(req, res, key, body)
+	return res, nil
+}
+
+// lookup returns the response kept for req under key and its body, or
+// nil if there is none or its Vary fields don't match req.
+func (t *CachingTransport) lookup(req *http.Request, key string) (*http.Response, []byte) {
+	data, ok := t.cache().Get(key)
+	if !ok {
+		return nil, nil
+	}
+	br := bufio.NewReader(bytes.NewReader(data))
+	varied, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil
+	}
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	for _, f := range varyFields(res.Header) {
+		if strings.Join(varied[f], ", ") != strings.Join(req.Header[f], ", ") {
+			return nil, nil
+		}
+	}
+	return res, body
+}
+
+// store keeps res, the response to req with the given body, under key.
+// The entry holds the values of the request header fields named in
+// res's Vary header, followed by the response.
+func (t *CachingTransport) store(req *http.Request, res *http.Response, key string, body []byte) {
+	var buf bytes.Buffer
+	varied := make(http.Header)
+	for _, f := range varyFields(res.Header) {
+		varied[f] = req.Header[f]
+	}
+	varied.Write(&buf)
+	buf.WriteString("\r\n")
+	r := *res
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.TransferEncoding = nil
+	r.Close = false
+	if err := r.Write(&buf); err != nil {
+		return
+	}
+	t.cache().Set(key, buf.Bytes())
+}
+
+// cacheable reports whether res may be kept.
+func cacheable(res *http.Response) bool {
+	switch res.StatusCode {
+	case 200, 203, 300, 301, 404, 410:
+	default:
+		return false
+	}
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	for _, f := range varyFields(res.Header) {
+		if f == "*" {
+			return false
+		}
+	}
+	return freshness(res) > 0 || res.Header.Get("Etag") != "" || res.Header.Get("Last-Modified") != ""
+}
+
+// freshness returns how long res is fresh for after it was generated.
+func freshness(res *http.Response) time.Duration {
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(n) * time.Second
+	}
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	if v := res.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lm, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil && lm.Before(date) {
+		// The heuristic suggested by RFC 7234 section 4.2.2.
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+// age returns how long ago res was generated.
+func age(res *http.Response) time.Duration {
+	var d time.Duration
+	if date, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+		if d = time.Since(date); d < 0 {
+			d = 0
+		}
+	}
+	if n, err := strconv.ParseInt(res.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		d += time.Duration(n) * time.Second
+	}
+	return d
+}
+
+// parseCacheControl returns the directives of h's Cache-Control
+// header, mapping each lower-cased name to its unquoted value.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, value := d, ""
+			if i := strings.Index(d, "="); i >= 0 {
+				name, value = strings.TrimSpace(d[:i]), strings.Trim(strings.TrimSpace(d[i+1:]), `"`)
+			}
+			cc[strings.ToLower(name)] = value
+		}
+	}
+	return cc
+}
+
+// varyFields returns the canonical names of the request header fields
+// listed in h's Vary header.
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, http.CanonicalHeaderKey(f))
+			}
+		}
+	}
+	return fields
+}
+
+// readCloser is an io.ReadCloser reading from Reader and closing
+// Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
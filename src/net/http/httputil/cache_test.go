@@ -0,0 +1,194 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cacheTestServer answers GET requests with the response header
+// fields listed in the h query parameter, separated by "|", and a 304
+// if the request's If-None-Match matches the ETag among them. It
+// counts the requests it gets.
+type cacheTestServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	hits int
+}
+
+func newCacheTestServer() *cacheTestServer {
+	s := new(cacheTestServer)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits++
+		hits := s.hits
+		s.mu.Unlock()
+		if r.Method != "GET" {
+			return
+		}
+		w.Header().Set("X-Hits", fmt.Sprint(hits))
+		for _, h := range strings.Split(r.URL.Query().Get("h"), "|") {
+			if i := strings.Index(h, ":"); i > 0 {
+				w.Header().Add(h[:i], strings.TrimSpace(h[i+1:]))
+			}
+		}
+		if etag := w.Header().Get("Etag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, "body %s", r.Header.Get("Accept-Language"))
+	}))
+	return s
+}
+
+func (s *cacheTestServer) Hits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+func TestCachingTransport(t *testing.T) {
+	s := newCacheTestServer()
+	defer s.Close()
+	c := &http.Client{Transport: &CachingTransport{}}
+
+	tests := []struct {
+		method   string
+		headers  string // response headers the server sends, "|"-separated
+		reqCC    string // request Cache-Control
+		lang     string
+		wantBody string
+		wantHits int // the server's request count after the request
+	}{
+		// Fresh responses are reused.
+		{"GET", "Cache-Control: max-age=60", "", "", "body ", 1},
+		{"GET", "Cache-Control: max-age=60", "", "", "body ", 1},
+		// Unless the request insists, and then the lack of a
+		// validator means the response is fetched again.
+		{"GET", "Cache-Control: max-age=60", "no-cache", "", "body ", 2},
+		// Responses to be revalidated get 304s.
+		{"GET", "Cache-Control: no-cache|ETag: \"x\"", "", "", "body ", 3},
+		{"GET", "Cache-Control: no-cache|ETag: \"x\"", "", "", "body ", 4},
+		// no-store responses are never kept.
+		{"GET", "Cache-Control: no-store, max-age=60", "", "", "body ", 5},
+		{"GET", "Cache-Control: no-store, max-age=60", "", "", "body ", 6},
+		// Vary'd request fields must match.
+		{"GET", "Cache-Control: max-age=60|Vary: Accept-Language", "", "en", "body en", 7},
+		{"GET", "Cache-Control: max-age=60|Vary: Accept-Language", "", "en", "body en", 7},
+		{"GET", "Cache-Control: max-age=60|Vary: Accept-Language", "", "fr", "body fr", 8},
+		{"GET", "Cache-Control: max-age=60|Vary: Accept-Language", "", "fr", "body fr", 8},
+		// Other methods invalidate.
+		{"GET", "Expires: Thu, 01 Jan 2099 00:00:00 GMT", "", "", "body ", 9},
+		{"GET", "Expires: Thu, 01 Jan 2099 00:00:00 GMT", "", "", "body ", 9},
+		{"DELETE", "Expires: Thu, 01 Jan 2099 00:00:00 GMT", "", "", "", 10},
+		{"GET", "Expires: Thu, 01 Jan 2099 00:00:00 GMT", "", "", "body ", 11},
+		// Request no-store bypasses the cache.
+		{"GET", "Expires: Thu, 01 Jan 2099 00:00:00 GMT", "no-store", "", "body ", 12},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest(tt.method, s.URL+"/?h="+strings.Replace(tt.headers, " ", "+", -1), nil)
+		if tt.reqCC != "" {
+			req.Header.Set("Cache-Control", tt.reqCC)
+		}
+		if tt.lang != "" {
+			req.Header.Set("Accept-Language", tt.lang)
+		}
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if res.StatusCode != http.StatusOK || string(body) != tt.wantBody || s.Hits() != tt.wantHits {
+			t.Errorf("%d. %s %s: got %d %q after %d requests; want 200 %q after %d",
+				i, tt.method, tt.headers, res.StatusCode, body, s.Hits(), tt.wantBody, tt.wantHits)
+		}
+		if tt.wantHits == 4 && res.Header.Get("X-Hits") != "4" {
+			t.Errorf("%d. revalidated response X-Hits = %q; want the 304's %q", i, res.Header.Get("X-Hits"), "4")
+		}
+	}
+}
+
+func TestCachingTransportMaxEntryBytes(t *testing.T) {
+	s := newCacheTestServer()
+	defer s.Close()
+	long := strings.Repeat("x", 100)
+	var (
+		mu      sync.Mutex
+		chunked int
+	)
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		chunked++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, long[:50])
+		w.(http.Flusher).Flush() // so there is no Content-Length
+		io.WriteString(w, long[50:])
+	}))
+	defer cs.Close()
+	c := &http.Client{Transport: &CachingTransport{MaxEntryBytes: 50}}
+	get := func(url, lang, want string) {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept-Language", lang)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(body) != want {
+			t.Fatalf("Get %s: %q, %v; want %q", url, body, err, want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		get(s.URL+"/?h=Cache-Control:+max-age=60", "short", "body short")
+		get(s.URL+"/long?h=Cache-Control:+max-age=60", long, "body "+long)
+		get(cs.URL, "", long)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if s.Hits() != 3 || chunked != 2 {
+		t.Errorf("servers got %d and %d requests; want 3 (the long response twice) and 2", s.Hits(), chunked)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := &MemoryCache{MaxBytes: 10}
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	c.Get("a")
+	c.Set("c", []byte("cccc")) // evicts b, the least recently used
+	c.Set("d", []byte("ddddddddddd"))
+	for _, tt := range []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"a", "aaaa", true},
+		{"b", "", false},
+		{"c", "cccc", true},
+		{"d", "", false}, // longer than MaxBytes
+	} {
+		if data, ok := c.Get(tt.key); string(data) != tt.want || ok != tt.ok {
+			t.Errorf("Get(%q) = %q, %v; want %q, %v", tt.key, data, ok, tt.want, tt.ok)
+		}
+	}
+	c.Delete("a")
+	c.Set("e", []byte("eeeeee"))
+	if _, ok := c.Get("c"); !ok {
+		t.Error("entry evicted with room for it left by Delete")
+	}
+}
@@ -72,7 +72,7 @@ var dumpTests = []dumpTest{
 		WantDumpOut: "GET /foo HTTP/1.1\r\n" +
 			"Host: example.com\r\n" +
 			"User-Agent: Go 1.1 package http\r\n" +
-			"Accept-Encoding: gzip\r\n\r\n",
+			"Accept-Encoding: gzip, deflate\r\n\r\n",
 	},
 
 	// Test that an https URL doesn't try to do an SSL negotiation
@@ -84,7 +84,7 @@ var dumpTests = []dumpTest{
 		WantDumpOut: "GET /foo HTTP/1.1\r\n" +
 			"Host: example.com\r\n" +
 			"User-Agent: Go 1.1 package http\r\n" +
-			"Accept-Encoding: gzip\r\n\r\n",
+			"Accept-Encoding: gzip, deflate\r\n\r\n",
 	},
 
 	// Request with Body, but Dump requested without it.
@@ -107,7 +107,7 @@ var dumpTests = []dumpTest{
 			"Host: post.tld\r\n" +
 			"User-Agent: Go 1.1 package http\r\n" +
 			"Content-Length: 6\r\n" +
-			"Accept-Encoding: gzip\r\n\r\n",
+			"Accept-Encoding: gzip, deflate\r\n\r\n",
 
 		NoBody: true,
 	},
@@ -132,7 +132,7 @@ var dumpTests = []dumpTest{
 			"Host: post.tld\r\n" +
 			"User-Agent: Go 1.1 package http\r\n" +
 			"Content-Length: 8193\r\n" +
-			"Accept-Encoding: gzip\r\n\r\n" +
+			"Accept-Encoding: gzip, deflate\r\n\r\n" +
 			strings.Repeat("a", 8193),
 	},
 }
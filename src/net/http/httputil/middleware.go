@@ -0,0 +1,282 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The RoundTripperFunc type is an adapter to allow the use of
+// ordinary functions as HTTP round trippers. If f is a function
+// with the appropriate signature, RoundTripperFunc(f) is a
+// RoundTripper that calls f.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// A Middleware decorates a RoundTripper, returning a RoundTripper
+// that adds behavior around calls to the one it was given.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Chain returns rt decorated by each of middleware, the first
+// outermost: the first Middleware sees a request first and its
+// response last. If rt is nil, http.DefaultTransport is used.
+//
+// The RoundTrippers of this package's Middleware have a CancelRequest
+// method, as http.Client requires to enforce its Timeout, that
+// cancels the request in the RoundTripper they decorate. A chain
+// supports cancelation as long as its other Middleware do too.
+//
+//	client := &http.Client{Transport: httputil.Chain(nil,
+//		httputil.Logging(log.Printf),
+//		httputil.Retry(3, time.Second))}
+func Chain(rt http.RoundTripper, middleware ...Middleware) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		rt = middleware[i](rt)
+	}
+	return rt
+}
+
+// SetHeader returns a Middleware that sets the headers in h on each
+// request, replacing any values of the same name. The request
+// passed on is a copy; the caller's request is not modified.
+func SetHeader(h http.Header) Middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &middlewareTransport{next: rt, fn: func(fw *forwarded, req *http.Request) (*http.Response, error) {
+			req = req.Clone()
+			for k, vv := range h {
+				req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), vv...)
+			}
+			return fw.send(rt, req)
+		}}
+	}
+}
+
+// Logging returns a Middleware that logs each request's method and
+// URL along with its response status or error and how long it took,
+// using logf, such as log.Printf.
+func Logging(logf func(format string, args ...interface{})) Middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &middlewareTransport{next: rt, fn: func(fw *forwarded, req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := fw.send(rt, req)
+			d := time.Since(start)
+			if err != nil {
+				logf("%s %s: error after %v: %v", req.Method, req.URL, d, err)
+			} else {
+				logf("%s %s: %s in %v", req.Method, req.URL, res.Status, d)
+			}
+			return res, err
+		}}
+	}
+}
+
+// Retry returns a Middleware that makes up to attempts tries of each
+// request that can safely be sent again: those with an idempotent
+// method (see http.Request.IsIdempotent) and either no body or a
+// GetBody function to get it anew. A try is retried if it fails or
+// gets a 502, 503 or 504 response. The retries wait backoff, doubling
+// after each one, unless the request is canceled. The last try's
+// response or error is returned.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &middlewareTransport{next: rt, fn: func(fw *forwarded, req *http.Request) (*http.Response, error) {
+			if !retryable(req) {
+				return fw.send(rt, req)
+			}
+			wait := backoff
+			sent := req
+			for try := 1; ; try++ {
+				res, err := fw.send(rt, sent)
+				if try >= attempts || !shouldRetry(res, err) {
+					return res, err
+				}
+				if res != nil {
+					res.Body.Close()
+				}
+				if !fw.sleep(wait) {
+					return nil, http.ErrRequestCanceled
+				}
+				wait *= 2
+				if req.Body != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					sent = req.Clone()
+					sent.Body = body
+				}
+			}
+		}}
+	}
+}
+
+func retryable(req *http.Request) bool {
+	return req.IsIdempotent() && (req.Body == nil || req.GetBody != nil)
+}
+
+func shouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// A canceler is a RoundTripper that can cancel requests, as
+// *http.Transport can.
+type canceler interface {
+	CancelRequest(*http.Request)
+}
+
+// A forwarder is embedded in the RoundTrippers of this package that
+// pass requests on to another, to implement their CancelRequest
+// method. It tracks each request from RoundTrip until its response
+// body is read to the end or closed.
+type forwarder struct {
+	mu       sync.Mutex
+	inflight map[*http.Request]*forwarded // by request given to RoundTrip
+}
+
+// forwarded is the state of a request being forwarded.
+type forwarded struct {
+	f        *forwarder
+	sent     *http.Request // request last passed on, if any
+	canceled chan struct{} // closed by CancelRequest
+}
+
+// start begins tracking req. It must be followed by finish.
+func (f *forwarder) start(req *http.Request) *forwarded {
+	fw := &forwarded{f: f, canceled: make(chan struct{})}
+	f.mu.Lock()
+	if f.inflight == nil {
+		f.inflight = make(map[*http.Request]*forwarded)
+	}
+	f.inflight[req] = fw
+	f.mu.Unlock()
+	return fw
+}
+
+// finish returns the result of the RoundTrip of req, which stops
+// being tracked now or, if there is a response, once its body is
+// done.
+func (f *forwarder) finish(req *http.Request, res *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		f.forget(req)
+		return nil, err
+	}
+	res.Body = &forwardedBody{rc: res.Body, done: func() { f.forget(req) }}
+	return res, nil
+}
+
+func (f *forwarder) forget(req *http.Request) {
+	f.mu.Lock()
+	delete(f.inflight, req)
+	f.mu.Unlock()
+}
+
+// cancel cancels req: it interrupts the waits of its RoundTrip and,
+// if rt can cancel requests, cancels what was passed on to rt.
+func (f *forwarder) cancel(rt http.RoundTripper, req *http.Request) {
+	f.mu.Lock()
+	var sent *http.Request
+	if fw := f.inflight[req]; fw != nil {
+		select {
+		case <-fw.canceled:
+		default:
+			close(fw.canceled)
+		}
+		sent = fw.sent
+	}
+	f.mu.Unlock()
+	if c, ok := rt.(canceler); ok && sent != nil {
+		c.CancelRequest(sent)
+	}
+}
+
+// send passes req on to rt, unless the request has been canceled.
+func (fw *forwarded) send(rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+	fw.f.mu.Lock()
+	fw.sent = req
+	fw.f.mu.Unlock()
+	select {
+	case <-fw.canceled:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, http.ErrRequestCanceled
+	default:
+	}
+	return rt.RoundTrip(req)
+}
+
+// sleep waits for d to pass, and reports whether it did before the
+// request was canceled.
+func (fw *forwarded) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-fw.canceled:
+		return false
+	}
+}
+
+// forwardedBody is the body of a response from a forwarder. It
+// calls done when read to EOF, on a read error, or on Close.
+type forwardedBody struct {
+	rc   io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *forwardedBody) Read(p []byte) (n int, err error) {
+	n, err = b.rc.Read(p)
+	if err != nil {
+		b.once.Do(b.done)
+	}
+	return
+}
+
+func (b *forwardedBody) Close() error {
+	b.once.Do(b.done)
+	return b.rc.Close()
+}
+
+// middlewareTransport is the RoundTripper of the Middleware of this
+// package: fn handles each request, passing it on to next with
+// fw.send.
+type middlewareTransport struct {
+	forwarder
+	next http.RoundTripper
+	fn   func(fw *forwarded, req *http.Request) (*http.Response, error)
+}
+
+func (t *middlewareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.fn(t.start(req), req)
+	return t.finish(req, res, err)
+}
+
+// CancelRequest cancels req, which must be in flight, interrupting
+// any wait of the middleware and canceling the request it passed on.
+func (t *middlewareTransport) CancelRequest(req *http.Request) {
+	t.cancel(t.next, req)
+}
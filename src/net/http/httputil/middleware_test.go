@@ -0,0 +1,287 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRoundTripper answers requests with the statuses in codes, in
+// order, with 0 meaning an error, and records the requests.
+type fakeRoundTripper struct {
+	codes []int
+	reqs  []*http.Request
+}
+
+var errFake = errors.New("fake error")
+
+func (rt *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.reqs = append(rt.reqs, req)
+	code := rt.codes[0]
+	rt.codes = rt.codes[1:]
+	if code == 0 {
+		return nil, errFake
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(rt http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+" in")
+				res, err := rt.RoundTrip(req)
+				order = append(order, name+" out")
+				return res, err
+			})
+		}
+	}
+	rt := Chain(&fakeRoundTripper{codes: []int{200}}, mark("a"), mark("b"))
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(order, ", "), "a in, b in, b out, a out"; got != want {
+		t.Errorf("order = %s; want %s", got, want)
+	}
+}
+
+func TestSetHeader(t *testing.T) {
+	fake := &fakeRoundTripper{codes: []int{200}}
+	rt := Chain(fake, SetHeader(http.Header{"User-Agent": {"bot/1.0"}, "x-token": {"secret"}}))
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("User-Agent", "original")
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	sent := fake.reqs[0].Header
+	if sent.Get("User-Agent") != "bot/1.0" || sent.Get("X-Token") != "secret" {
+		t.Errorf("sent headers %v; want User-Agent and X-Token set", sent)
+	}
+	if req.Header.Get("User-Agent") != "original" || req.Header.Get("X-Token") != "" {
+		t.Errorf("caller's request modified: %v", req.Header)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var logs []string
+	logf := func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	rt := Chain(&fakeRoundTripper{codes: []int{404, 0}}, Logging(logf))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "http://example.com/x", nil)
+		rt.RoundTrip(req)
+	}
+	if len(logs) != 2 ||
+		!strings.HasPrefix(logs[0], "GET http://example.com/x: 404 Not Found in ") ||
+		!strings.HasPrefix(logs[1], "GET http://example.com/x: error after ") ||
+		!strings.HasSuffix(logs[1], errFake.Error()) {
+		t.Errorf("logged %q", logs)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		codes  []int
+		want   int // final status, or 0 for an error
+		tries  int
+	}{
+		{"GET", "", []int{200}, 200, 1},
+		{"GET", "", []int{503, 0, 200}, 200, 3},
+		{"GET", "", []int{0, 0, 0}, 0, 3},
+		{"GET", "", []int{502, 504, 503}, 503, 3},
+		{"GET", "", []int{500}, 500, 1},
+		{"POST", "", []int{503}, 503, 1},
+		{"DELETE", "", []int{503, 200}, 200, 2},
+		{"PUT", "body", []int{503, 0, 200}, 200, 3},
+		{"POST", "body", []int{503}, 503, 1},
+	}
+	for i, tt := range tests {
+		fake := &fakeRoundTripper{codes: tt.codes}
+		rt := Chain(fake, Retry(3, 0))
+		var req *http.Request
+		if tt.body != "" {
+			req, _ = http.NewRequest(tt.method, "http://example.com/", strings.NewReader(tt.body))
+		} else {
+			req, _ = http.NewRequest(tt.method, "http://example.com/", nil)
+		}
+		res, err := rt.RoundTrip(req)
+		got := 0
+		if err == nil {
+			got = res.StatusCode
+		}
+		if got != tt.want || len(fake.reqs) != tt.tries {
+			t.Errorf("%d. got %d (%v) after %d tries; want %d after %d", i, got, err, len(fake.reqs), tt.want, tt.tries)
+		}
+		for j, r := range fake.reqs {
+			if r.Body == nil {
+				continue
+			}
+			if body, _ := ioutil.ReadAll(r.Body); string(body) != tt.body {
+				t.Errorf("%d. try %d sent body %q; want %q", i, j+1, body, tt.body)
+			}
+		}
+	}
+
+	// Without GetBody, a body can't be sent again.
+	fake := &fakeRoundTripper{codes: []int{503}}
+	req, _ := http.NewRequest("PUT", "http://example.com/", ioutil.NopCloser(strings.NewReader("body")))
+	if _, err := Chain(fake, Retry(3, 0)).RoundTrip(req); err != nil || len(fake.reqs) != 1 {
+		t.Errorf("body without GetBody: %d tries, %v; want 1", len(fake.reqs), err)
+	}
+}
+
+// cancelingRoundTripper answers its first request with a 503 and
+// blocks on the others until they are canceled.
+type cancelingRoundTripper struct {
+	mu       sync.Mutex
+	n        int
+	sent     chan *http.Request
+	canceled map[*http.Request]chan struct{}
+}
+
+func newCancelingRoundTripper() *cancelingRoundTripper {
+	return &cancelingRoundTripper{sent: make(chan *http.Request, 10), canceled: make(map[*http.Request]chan struct{})}
+}
+
+func (rt *cancelingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.n++
+	first := rt.n == 1
+	c := make(chan struct{})
+	rt.canceled[req] = c
+	rt.mu.Unlock()
+	rt.sent <- req
+	if first {
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: 503,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	<-c
+	return nil, http.ErrRequestCanceled
+}
+
+func (rt *cancelingRoundTripper) CancelRequest(req *http.Request) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if c, ok := rt.canceled[req]; ok {
+		close(c)
+		delete(rt.canceled, req)
+	}
+}
+
+func TestMiddlewareCancelRequest(t *testing.T) {
+	inner := newCancelingRoundTripper()
+	rt := Chain(inner, Retry(3, time.Millisecond), SetHeader(http.Header{"X-Foo": {"bar"}}))
+	c, ok := rt.(canceler)
+	if !ok {
+		t.Fatalf("Chain returned %T, without CancelRequest", rt)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := rt.RoundTrip(req)
+		errc <- err
+	}()
+	<-inner.sent // the 503
+	sent := <-inner.sent
+	if sent == req {
+		t.Fatal("SetHeader passed on the caller's request")
+	}
+	c.CancelRequest(req)
+	if err := <-errc; err != http.ErrRequestCanceled {
+		t.Errorf("error %v; want %v", err, http.ErrRequestCanceled)
+	}
+
+	// Cancelation interrupts the wait between retries.
+	inner = newCancelingRoundTripper()
+	rt = Chain(inner, Retry(3, time.Hour))
+	go func() {
+		_, err := rt.RoundTrip(req)
+		errc <- err
+	}()
+	<-inner.sent
+	rt.(canceler).CancelRequest(req)
+	select {
+	case err := <-errc:
+		if err != http.ErrRequestCanceled {
+			t.Errorf("canceled during backoff: error %v; want %v", err, http.ErrRequestCanceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("backoff not interrupted by CancelRequest")
+	}
+}
+
+func TestTransportsCancelRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httputil-cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name string
+		new  func(http.RoundTripper) http.RoundTripper
+	}{
+		{"ThrottlingTransport", func(rt http.RoundTripper) http.RoundTripper {
+			return &ThrottlingTransport{Transport: rt, RequestsPerSecond: 1000}
+		}},
+		{"CachingTransport", func(rt http.RoundTripper) http.RoundTripper {
+			return &CachingTransport{Transport: rt}
+		}},
+		{"CircuitBreaker", func(rt http.RoundTripper) http.RoundTripper {
+			return &CircuitBreaker{Transport: rt}
+		}},
+		{"RecordingTransport", func(rt http.RoundTripper) http.RoundTripper {
+			return &RecordingTransport{Transport: rt, Dir: dir}
+		}},
+	}
+	for _, tt := range tests {
+		inner := newCancelingRoundTripper()
+		rt := tt.new(inner)
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		res, err := rt.RoundTrip(req) // the 503
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		res.Body.Close()
+		<-inner.sent
+		errc := make(chan error, 1)
+		go func() {
+			_, err := rt.RoundTrip(req)
+			errc <- err
+		}()
+		<-inner.sent
+		rt.(canceler).CancelRequest(req)
+		if err := <-errc; err != http.ErrRequestCanceled {
+			t.Errorf("%s: error %v; want %v", tt.name, err, http.ErrRequestCanceled)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A RecordingTransport is an http.RoundTripper that records the
+// exchanges it makes to files, and can later replay them without
+// using the network. It lets tests of code that talks to third-party
+// services be recorded once against the real service and then run
+// hermetically.
+//
+// Each exchange is stored in its own file in Dir, holding the request
+// as dumped by DumpRequestOut followed by the response as dumped by
+// DumpResponse, bodies included. Requests are matched by method, URL
+// and body; identical requests are replayed in the order they were
+// recorded.
+type RecordingTransport struct {
+	// Transport is used to make requests in record mode.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Dir is the directory the exchanges are recorded to and
+	// replayed from. It must already exist.
+	Dir string
+
+	// Replay, if true, causes responses to be read from Dir
+	// rather than fetched with Transport. A request with no
+	// matching recording fails.
+	Replay bool
+
+	forwarder
+	mu   sync.Mutex
+	seen map[string]int // times each request key has been seen
+}
+
+func (t *RecordingTransport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// RoundTrip implements the http.RoundTripper interface. In record
+// mode it sends req with t.Transport and saves the exchange before
+// returning the response; in replay mode it returns the recorded
+// response for req, or an error if there is none.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fw := t.start(req)
+	res, err := t.roundTrip(fw, req)
+	return t.finish(req, res, err)
+}
+
+// CancelRequest cancels req, which must be in flight, in Transport if
+// it has a CancelRequest method.
+func (t *RecordingTransport) CancelRequest(req *http.Request) {
+	t.cancel(t.transport(), req)
+}
+
+func (t *RecordingTransport) roundTrip(fw *forwarded, req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	name := t.fileName(req, body)
+	if t.Replay {
+		return t.replay(req, name)
+	}
+	return t.record(fw, req, name)
+}
+
+// fileName returns the path of the file recording the exchange for
+// req, whose body is body, accounting for the identical requests made
+// before it.
+func (t *RecordingTransport) fileName(req *http.Request, body []byte) string {
+	key := req.Method + " " + req.URL.String()
+	if len(body) > 0 {
+		key += "\n" + string(body)
+	}
+	t.mu.Lock()
+	if t.seen == nil {
+		t.seen = make(map[string]int)
+	}
+	n := t.seen[key]
+	t.seen[key]++
+	t.mu.Unlock()
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return filepath.Join(t.Dir, fmt.Sprintf("%s-%016x-%d.http", req.Method, h.Sum64(), n))
+}
+
+func (t *RecordingTransport) record(fw *forwarded, req *http.Request, name string) (*http.Response, error) {
+	reqDump, err := DumpRequestOut(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fw.send(t.transport(), req)
+	if err != nil {
+		return nil, err
+	}
+	respDump, err := DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if err := ioutil.WriteFile(name, append(reqDump, respDump...), 0666); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *RecordingTransport) replay(req *http.Request, name string) (*http.Response, error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("httputil: no recorded response for %s %s", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(bytes.NewReader(b))
+	recReq, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, fmt.Errorf("httputil: reading recorded request in %s: %v", name, err)
+	}
+	if _, err := ioutil.ReadAll(recReq.Body); err != nil {
+		return nil, fmt.Errorf("httputil: reading recorded request in %s: %v", name, err)
+	}
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("httputil: reading recorded response in %s: %v", name, err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("httputil: reading recorded response in %s: %v", name, err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return resp, nil
+}
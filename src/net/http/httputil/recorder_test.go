@@ -0,0 +1,84 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httputil-recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Count", fmt.Sprint(n))
+		fmt.Fprintf(w, "%s %s %d %s", r.Method, r.URL.Path, n, b)
+	}))
+	url := ts.URL
+
+	do := func(c *http.Client, method, path, body string) (string, error) {
+		req, _ := http.NewRequest(method, url+path, strings.NewReader(body))
+		res, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return res.Header.Get("X-Count") + ": " + string(b), err
+	}
+	exchanges := []struct{ method, path, body string }{
+		{"GET", "/a", ""},
+		{"POST", "/b", "payload"},
+		{"GET", "/a", ""},
+		{"POST", "/b", "other payload"},
+	}
+
+	rec := &http.Client{Transport: &RecordingTransport{Dir: dir}}
+	var want []string
+	for _, ex := range exchanges {
+		got, err := do(rec, ex.method, ex.path, ex.body)
+		if err != nil {
+			t.Fatalf("recording %s %s: %v", ex.method, ex.path, err)
+		}
+		want = append(want, got)
+	}
+	ts.Close()
+
+	replay := &http.Client{Transport: &RecordingTransport{Dir: dir, Replay: true}}
+	for i, ex := range exchanges {
+		got, err := do(replay, ex.method, ex.path, ex.body)
+		if err != nil {
+			t.Fatalf("replaying %s %s: %v", ex.method, ex.path, err)
+		}
+		if got != want[i] {
+			t.Errorf("replay of %s %s = %q; want %q", ex.method, ex.path, got, want[i])
+		}
+	}
+	if _, err := do(replay, "GET", "/a", ""); err == nil {
+		t.Error("third GET /a replayed; only two were recorded")
+	}
+
+	// Requests with different bodies are told apart, whatever
+	// their order.
+	replay = &http.Client{Transport: &RecordingTransport{Dir: dir, Replay: true}}
+	if got, err := do(replay, "POST", "/b", "other payload"); err != nil || got != want[3] {
+		t.Errorf("replay of second POST /b first = %q, %v; want %q", got, err, want[3])
+	}
+	if _, err := do(replay, "POST", "/b", "unseen payload"); err == nil {
+		t.Error("POST /b with an unrecorded body replayed")
+	}
+}
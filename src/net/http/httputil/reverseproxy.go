@@ -28,11 +28,25 @@ type ReverseProxy struct {
 	// Director must be a function which modifies
 	// the request into a new request to be sent
 	// using Transport. Its response is then copied
-	// back to the original client unmodified.
+	// back to the original client, after being passed
+	// to ModifyResponse if that is set.
+	//
+	// The request given to Director is a clone of the
+	// incoming request, so its URL and Header may be
+	// modified freely.
 	Director func(*http.Request)
 
+	// ModifyResponse is an optional function that
+	// modifies the Response from the backend before it
+	// is copied back to the client. If it returns an
+	// error, the proxy responds to the client with a
+	// 500 Internal Server Error instead.
+	ModifyResponse func(*http.Response) error
+
 	// The transport used to perform proxy requests.
-	// If nil, http.DefaultTransport is used.
+	// If nil, http.DefaultTransport is used. Setting it to a
+	// dedicated *http.Transport allows proxied traffic to use its
+	// own connection pool, Dial function or TLS configuration.
 	Transport http.RoundTripper
 
 	// FlushInterval specifies the flush interval
@@ -41,6 +55,22 @@ type ReverseProxy struct {
 	// If zero, no periodic flushing is done.
 	FlushInterval time.Duration
 
+	// IgnoreForwardedHeaders, if true, causes the proxy to
+	// discard any X-Forwarded-For, X-Forwarded-Host and
+	// X-Forwarded-Proto values in the incoming request rather
+	// than extending or keeping them. It should be set when
+	// clients connect to the proxy directly, so they cannot
+	// spoof the values seen by the backend.
+	IgnoreForwardedHeaders bool
+
+	// ErrorHandler is an optional function that handles errors
+	// reaching the backend or returned by ModifyResponse. It must
+	// write a response to w.
+	//
+	// If nil, the error is logged to ErrorLog and the client
+	// receives a 500 Internal Server Error with an empty body.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
 	// ErrorLog specifies an optional logger for errors
 	// that occur when attempting to proxy the request.
 	// If nil, logging goes to os.Stderr via the log package's
@@ -65,8 +95,25 @@ func singleJoiningSlash(a, b string) string {
 // target's path is "/base" and the incoming request was for "/dir",
 // the target request will be for /base/dir.
 func NewSingleHostReverseProxy(target *url.URL) *ReverseProxy {
+	return &ReverseProxy{Director: SingleHostDirector(target)}
+}
+
+// SingleHostDirector returns a Director function that rewrites
+// request URLs to the scheme, host, and base path provided in target,
+// as described for NewSingleHostReverseProxy. The target's query, if
+// any, is prepended to the request's query.
+//
+// It can be called from a custom Director that also makes other
+// changes to the outgoing request:
+//
+//	rewrite := httputil.SingleHostDirector(target)
+//	proxy := &httputil.ReverseProxy{Director: func(req *http.Request) {
+//		rewrite(req)
+//		req.Header.Set("X-Api-Key", key)
+//	}}
+func SingleHostDirector(target *url.URL) func(*http.Request) {
 	targetQuery := target.RawQuery
-	director := func(req *http.Request) {
+	return func(req *http.Request) {
 		req.URL.Scheme = target.Scheme
 		req.URL.Host = target.Host
 		req.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
@@ -76,7 +123,6 @@ func NewSingleHostReverseProxy(target *url.URL) *ReverseProxy {
 			req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
 		}
 	}
-	return &ReverseProxy{Director: director}
 }
 
 func copyHeader(dst, src http.Header) {
@@ -106,8 +152,7 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		transport = http.DefaultTransport
 	}
 
-	outreq := new(http.Request)
-	*outreq = *req // includes shallow copies of maps, but okay
+	outreq := req.Clone()
 
 	p.Director(outreq)
 	outreq.Proto = "HTTP/1.1"
@@ -117,35 +162,16 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	// Remove hop-by-hop headers to the backend.  Especially
 	// important is "Connection" because we want a persistent
-	// connection, regardless of what the client sent to us.  This
-	// is modifying the same underlying map from req (shallow
-	// copied above) so we only copy it if necessary.
-	copiedHeaders := false
+	// connection, regardless of what the client sent to us.
 	for _, h := range hopHeaders {
-		if outreq.Header.Get(h) != "" {
-			if !copiedHeaders {
-				outreq.Header = make(http.Header)
-				copyHeader(outreq.Header, req.Header)
-				copiedHeaders = true
-			}
-			outreq.Header.Del(h)
-		}
+		outreq.Header.Del(h)
 	}
 
-	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-		// If we aren't the first proxy retain prior
-		// X-Forwarded-For information as a comma+space
-		// separated list and fold multiple headers into one.
-		if prior, ok := outreq.Header["X-Forwarded-For"]; ok {
-			clientIP = strings.Join(prior, ", ") + ", " + clientIP
-		}
-		outreq.Header.Set("X-Forwarded-For", clientIP)
-	}
+	p.setForwardedHeaders(req, outreq.Header)
 
 	res, err := transport.RoundTrip(outreq)
 	if err != nil {
-		p.logf("http: proxy error: %v", err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		p.handleError(rw, req, err)
 		return
 	}
 	defer res.Body.Close()
@@ -154,12 +180,60 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		res.Header.Del(h)
 	}
 
+	if p.ModifyResponse != nil {
+		if err := p.ModifyResponse(res); err != nil {
+			p.handleError(rw, req, err)
+			return
+		}
+	}
+
 	copyHeader(rw.Header(), res.Header)
 
 	rw.WriteHeader(res.StatusCode)
 	p.copyResponse(rw, res.Body)
 }
 
+func (p *ReverseProxy) handleError(rw http.ResponseWriter, req *http.Request, err error) {
+	if p.ErrorHandler != nil {
+		p.ErrorHandler(rw, req, err)
+		return
+	}
+	p.logf("http: proxy error: %v", err)
+	rw.WriteHeader(http.StatusInternalServerError)
+}
+
+// setForwardedHeaders records the client's address, the Host it
+// requested and the protocol it used in the X-Forwarded-* headers of
+// h, the outgoing request's header.
+func (p *ReverseProxy) setForwardedHeaders(req *http.Request, h http.Header) {
+	if p.IgnoreForwardedHeaders {
+		h.Del("X-Forwarded-For")
+		h.Del("X-Forwarded-Host")
+		h.Del("X-Forwarded-Proto")
+	}
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		// If we aren't the first proxy retain prior
+		// X-Forwarded-For information as a comma+space
+		// separated list and fold multiple headers into one.
+		if prior, ok := h["X-Forwarded-For"]; ok {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	// An earlier proxy's view of the original host and protocol
+	// is more accurate than ours, so only fill them in if unset.
+	if h.Get("X-Forwarded-Host") == "" && req.Host != "" {
+		h.Set("X-Forwarded-Host", req.Host)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		h.Set("X-Forwarded-Proto", proto)
+	}
+}
+
 func (p *ReverseProxy) copyResponse(dst io.Writer, src io.Reader) {
 	if p.FlushInterval != 0 {
 		if wf, ok := dst.(writeFlusher); ok {
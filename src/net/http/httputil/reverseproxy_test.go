@@ -7,6 +7,8 @@
 package httputil
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -133,6 +135,59 @@ func TestXForwardedFor(t *testing.T) {
 	}
 }
 
+func TestXForwardedHostProto(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Got-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Got-Host", r.Header.Get("X-Forwarded-Host"))
+		w.Header().Set("X-Got-Proto", r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ignore           bool
+		spoof            bool
+		wantFor          string
+		wantHost, wantTo string
+	}{
+		{false, false, "127.0.0.1", "some-name", "http"},
+		{false, true, "1.2.3.4, 127.0.0.1", "spoofed", "https"},
+		{true, true, "127.0.0.1", "some-name", "http"},
+	}
+	for i, tt := range tests {
+		proxyHandler := NewSingleHostReverseProxy(backendURL)
+		proxyHandler.IgnoreForwardedHeaders = tt.ignore
+		frontend := httptest.NewServer(proxyHandler)
+
+		req, _ := http.NewRequest("GET", frontend.URL, nil)
+		req.Host = "some-name"
+		req.Close = true
+		if tt.spoof {
+			req.Header.Set("X-Forwarded-For", "1.2.3.4")
+			req.Header.Set("X-Forwarded-Host", "spoofed")
+			req.Header.Set("X-Forwarded-Proto", "https")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d. Get: %v", i, err)
+		}
+		res.Body.Close()
+		if g := res.Header.Get("X-Got-For"); g != tt.wantFor {
+			t.Errorf("%d. X-Forwarded-For = %q; want %q", i, g, tt.wantFor)
+		}
+		if g := res.Header.Get("X-Got-Host"); g != tt.wantHost {
+			t.Errorf("%d. X-Forwarded-Host = %q; want %q", i, g, tt.wantHost)
+		}
+		if g := res.Header.Get("X-Got-Proto"); g != tt.wantTo {
+			t.Errorf("%d. X-Forwarded-Proto = %q; want %q", i, g, tt.wantTo)
+		}
+		frontend.Close()
+	}
+}
+
 var proxyQueryTests = []struct {
 	baseSuffix string // suffix to add to backend URL
 	reqSuffix  string // suffix to add to frontend's request URL
@@ -211,3 +266,94 @@ func TestReverseProxyFlushInterval(t *testing.T) {
 		t.Error("maxLatencyWriter flushLoop() never exited")
 	}
 }
+
+func TestReverseProxyModifyResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Hit-Backend", r.Header.Get("X-Api-Key"))
+		w.Write([]byte("hi"))
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrite := SingleHostDirector(backendURL)
+	proxyHandler := &ReverseProxy{
+		Director: func(req *http.Request) {
+			rewrite(req)
+			req.Header.Set("X-Api-Key", "secret")
+		},
+		ModifyResponse: func(res *http.Response) error {
+			if res.Request.URL.Path == "/fail" {
+				return errors.New("rejected")
+			}
+			res.Header.Set("X-Modified", "yes")
+			return nil
+		},
+	}
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	res, err := http.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	res.Body.Close()
+	if g, e := res.Header.Get("X-Hit-Backend"), "secret"; g != e {
+		t.Errorf("backend saw X-Api-Key %q; want %q", g, e)
+	}
+	if g, e := res.Header.Get("X-Modified"), "yes"; g != e {
+		t.Errorf("X-Modified = %q; want %q", g, e)
+	}
+
+	res, err = http.Get(frontend.URL + "/fail")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	res.Body.Close()
+	if g, e := res.StatusCode, http.StatusInternalServerError; g != e {
+		t.Errorf("got res.StatusCode %d; expected %d", g, e)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestReverseProxyErrorHandler(t *testing.T) {
+	backendURL, _ := url.Parse("http://backend.invalid/")
+	proxyHandler := NewSingleHostReverseProxy(backendURL)
+	proxyHandler.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "backend.invalid" {
+			t.Errorf("transport got host %q; want backend.invalid", req.URL.Host)
+		}
+		return nil, errors.New("backend down")
+	})
+	var gotErr error
+	proxyHandler.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		gotErr = err
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "upstream unavailable")
+	}
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	res, err := http.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if g, e := res.StatusCode, http.StatusBadGateway; g != e {
+		t.Errorf("got res.StatusCode %d; expected %d", g, e)
+	}
+	if g, e := string(body), "upstream unavailable"; g != e {
+		t.Errorf("got body %q; expected %q", g, e)
+	}
+	if gotErr == nil || gotErr.Error() != "backend down" {
+		t.Errorf("ErrorHandler got error %v; want backend down", gotErr)
+	}
+}
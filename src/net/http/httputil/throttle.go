@@ -0,0 +1,150 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A ThrottlingTransport is an http.RoundTripper that limits how
+// hard it uses the servers it talks to: how often it starts
+// requests to each host and how fast it sends request bodies and
+// reads response bodies. It lets batch clients be polite to servers
+// without pausing in application code.
+type ThrottlingTransport struct {
+	// Transport is used to make the requests.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// RequestsPerSecond, if positive, limits the rate at which
+	// requests to each host are started. Requests over the limit
+	// wait their turn, in the order they arrived.
+	RequestsPerSecond float64
+
+	// WriteBytesPerSecond, if positive, limits the average rate
+	// at which each request body is sent.
+	WriteBytesPerSecond int64
+
+	// ReadBytesPerSecond, if positive, limits the average rate at
+	// which each response body is read.
+	ReadBytesPerSecond int64
+
+	forwarder
+	mu       sync.Mutex
+	next     map[string]time.Time // host -> earliest start of its next request
+	pruneLen int                  // len(next) at which to drop slots past
+}
+
+func (t *ThrottlingTransport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// RoundTrip implements the http.RoundTripper interface. It waits for
+// the request's turn to start, if RequestsPerSecond is set, and
+// passes it on with its request and response bodies rate limited.
+func (t *ThrottlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fw := t.start(req)
+	res, err := t.roundTrip(fw, req)
+	return t.finish(req, res, err)
+}
+
+func (t *ThrottlingTransport) roundTrip(fw *forwarded, req *http.Request) (*http.Response, error) {
+	if t.RequestsPerSecond > 0 {
+		if !fw.sleep(t.reserve(req.URL.Host).Sub(time.Now())) {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, http.ErrRequestCanceled
+		}
+	}
+	if t.WriteBytesPerSecond > 0 && req.Body != nil {
+		req = req.Clone()
+		req.Body = &throttledBody{rc: req.Body, rate: t.WriteBytesPerSecond}
+	}
+	res, err := fw.send(t.transport(), req)
+	if err != nil {
+		return nil, err
+	}
+	if t.ReadBytesPerSecond > 0 {
+		res.Body = &throttledBody{rc: res.Body, rate: t.ReadBytesPerSecond}
+	}
+	return res, nil
+}
+
+// CancelRequest cancels req, which must be in flight: a request
+// waiting for its turn fails, and one passed on is canceled in
+// Transport if it has a CancelRequest method.
+func (t *ThrottlingTransport) CancelRequest(req *http.Request) {
+	t.cancel(t.transport(), req)
+}
+
+// reserve returns the time at which the next request to host may
+// start, and books the slot after it for the request following.
+func (t *ThrottlingTransport) reserve(host string) time.Time {
+	interval := time.Duration(float64(time.Second) / t.RequestsPerSecond)
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.next == nil {
+		t.next = make(map[string]time.Time)
+	}
+	if len(t.next) >= t.pruneLen {
+		// Hosts whose next slot is past are free to start a
+		// request now, as they would be with no entry.
+		for h, slot := range t.next {
+			if slot.Before(now) {
+				delete(t.next, h)
+			}
+		}
+		t.pruneLen = 2*len(t.next) + 16
+	}
+	start := t.next[host]
+	if start.Before(now) {
+		start = now
+	}
+	t.next[host] = start.Add(interval)
+	return start
+}
+
+// throttledBody is an io.ReadCloser that reads from rc no faster on
+// average than rate bytes per second.
+type throttledBody struct {
+	rc    io.ReadCloser
+	rate  int64
+	start time.Time // first Read
+	n     int64     // bytes read since start
+}
+
+func (b *throttledBody) Read(p []byte) (n int, err error) {
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+	// Read a tenth of a second's worth at a time, so that the
+	// transfer is smooth rather than bursty.
+	step := int(b.rate / 10)
+	if step < 1 {
+		step = 1
+	}
+	if len(p) > step {
+		p = p[:step]
+	}
+	n, err = b.rc.Read(p)
+	b.n += int64(n)
+	due := b.start.Add(time.Duration(float64(b.n) / float64(b.rate) * float64(time.Second)))
+	if d := due.Sub(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.rc.Close()
+}
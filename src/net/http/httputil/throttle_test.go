@@ -0,0 +1,130 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottlingTransportRequestRate(t *testing.T) {
+	fake := &fakeRoundTripper{codes: []int{200, 200, 200, 200}}
+	tr := &ThrottlingTransport{Transport: fake, RequestsPerSecond: 20}
+	get := func(url string) time.Duration {
+		start := time.Now()
+		req, _ := http.NewRequest("GET", url, nil)
+		if _, err := tr.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+	get("http://a.example/1")
+	if d := get("http://b.example/1"); d > 40*time.Millisecond {
+		t.Errorf("first request to another host took %v; want no wait", d)
+	}
+	if d := get("http://a.example/2"); d < 30*time.Millisecond {
+		t.Errorf("second request to a host took %v; want about 50ms", d)
+	}
+	if d := get("http://a.example/3"); d < 30*time.Millisecond {
+		t.Errorf("third request to a host took %v; want about 50ms", d)
+	}
+}
+
+func TestThrottlingTransportBandwidth(t *testing.T) {
+	body := strings.Repeat("x", 400)
+	var sent string
+	rt := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		sent = string(b)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+	tr := &ThrottlingTransport{Transport: rt, WriteBytesPerSecond: 2000, ReadBytesPerSecond: 2000}
+
+	start := time.Now()
+	req, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader(body))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("sending 400 bytes at 2000 bytes/s took %v; want about 200ms", d)
+	}
+	if sent != body {
+		t.Errorf("sent %d bytes; want %d", len(sent), len(body))
+	}
+
+	start = time.Now()
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("reading 400 bytes at 2000 bytes/s took %v; want about 200ms", d)
+	}
+	if string(got) != body {
+		t.Errorf("read %d bytes; want %d", len(got), len(body))
+	}
+}
+
+func TestThrottlingTransportForgetsPastSlots(t *testing.T) {
+	codes := make([]int, 1000)
+	for i := range codes {
+		codes[i] = 200
+	}
+	tr := &ThrottlingTransport{Transport: &fakeRoundTripper{codes: codes}, RequestsPerSecond: 1e9}
+	for i := range codes {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://h%d.example/", i), nil)
+		if _, err := tr.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(tr.next); n > 100 {
+		t.Errorf("%d hosts remembered after requests to %d; want past slots dropped", n, len(codes))
+	}
+}
+
+func TestThrottlingTransportCancelRequest(t *testing.T) {
+	fake := &fakeRoundTripper{codes: []int{200}}
+	tr := &ThrottlingTransport{Transport: fake, RequestsPerSecond: 0.001}
+	req, _ := http.NewRequest("GET", "http://a.example/1", nil)
+	if _, err := tr.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", "http://a.example/2", nil)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tr.RoundTrip(req)
+		errc <- err
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		// Until its RoundTrip has begun, req can't be canceled.
+		tr.CancelRequest(req)
+		select {
+		case err := <-errc:
+			if err != http.ErrRequestCanceled {
+				t.Errorf("error %v; want %v", err, http.ErrRequestCanceled)
+			}
+			if len(fake.reqs) != 1 {
+				t.Errorf("%d requests passed on; want 1", len(fake.reqs))
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("wait for the request's turn not interrupted by CancelRequest")
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -107,3 +108,96 @@ func afterTest(t *testing.T) {
 	}
 	t.Errorf("Test appears to have leaked %s:\n%s", bad, stacks)
 }
+
+// fakeClock is a time source for the timeout machinery of package
+// http that only moves when Advance is called. Install it with
+// http.SetTimeHooks via install.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	when time.Time
+	f    func()
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) install() (restore func()) {
+	return http.SetTimeHooks(c.Now, c.After, c.AfterFunc)
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) http.Stopper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, t2 := range t.c.timers {
+		if t2 == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d and runs the functions of the
+// timers that are then due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// waitTimers waits until at least n timers are pending on the clock.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		got := len(c.timers)
+		c.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending after 5s; want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+// Common HTTP methods.
+//
+// Unless otherwise noted, these are defined in RFC 7231 section 4.3.
+const (
+	MethodGet     = "GET"
+	MethodHead    = "HEAD"
+	MethodPost    = "POST"
+	MethodPut     = "PUT"
+	MethodPatch   = "PATCH" // RFC 5789
+	MethodDelete  = "DELETE"
+	MethodConnect = "CONNECT"
+	MethodOptions = "OPTIONS"
+	MethodTrace   = "TRACE"
+)
+
+// IsIdempotent reports whether r's method is idempotent, as defined
+// in RFC 7231 section 4.2.2: sending the request several times has
+// the same intended effect on the server as sending it once, so a
+// request that may have failed can safely be retried. An empty Method
+// means GET.
+func (r *Request) IsIdempotent() bool {
+	switch valueOrDefault(r.Method, MethodGet) {
+	case MethodGet, MethodHead, MethodPut, MethodDelete, MethodOptions, MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Proxy auto-config
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPACScriptBytes bounds the size of a PAC script fetched from
+// ScriptURL.
+const maxPACScriptBytes = 1 << 20
+
+// defaultPACCacheEntries is the default for
+// PACResolver.MaxCacheEntries.
+const defaultPACCacheEntries = 1000
+
+// A PACResolver picks the proxy for each request by consulting a
+// proxy auto-config (PAC) script, caching the answers. Its Proxy
+// method is suitable for Transport's Proxy field:
+//
+//	pac := &http.PACResolver{ScriptURL: "http://wpad.example.com/wpad.dat"}
+//	tr := &http.Transport{Proxy: pac.Proxy}
+//
+// PAC scripts are written in JavaScript. PACResolver interprets the
+// subset of it that PAC scripts commonly use, along with the PAC
+// helper functions such as shExpMatch, dnsDomainIs and isInNet,
+// except dateRange. Scripts needing more of the language can be run
+// by an interpreter of the caller's choosing through FindProxyForURL.
+//
+// As browsers do, PACResolver passes the script only the scheme and
+// host of each request's URL, as in "https://example.com/", never
+// its path or query. Rules based on paths therefore don't apply,
+// and answers are cached per scheme and host.
+type PACResolver struct {
+	// Script is the contents of the PAC script.
+	Script string
+
+	// ScriptURL is the URL of the PAC script, used if Script is
+	// empty. It may be an http, https or file URL. The script is
+	// fetched when first needed, and again after FlushCache.
+	ScriptURL string
+
+	// Client fetches ScriptURL. If nil, a Client that doesn't use
+	// a proxy is used. Client must not itself use the
+	// PACResolver to find its proxy.
+	Client *Client
+
+	// FindProxyForURL, if non-nil, is used instead of Script and
+	// ScriptURL. It calls the script's function of the same name
+	// and returns its result. Like a script, it is passed only
+	// the scheme and host of the URL.
+	FindProxyForURL func(url, host string) (string, error)
+
+	// CacheTTL is how long an answer is reused for later requests
+	// with the same scheme and host. If zero, answers are kept
+	// until FlushCache is called or MaxCacheEntries is reached.
+	// If negative, nothing is cached.
+	CacheTTL time.Duration
+
+	// MaxCacheEntries bounds the number of cached answers. When
+	// the cache is full, expired answers are discarded, and then
+	// others if need be. If zero, 1000 is used.
+	MaxCacheEntries int
+
+	mu    sync.Mutex
+	cache map[string]pacEntry // keyed by scheme://host/
+
+	scriptMu sync.Mutex
+	script   *pacScript // compiled Script or ScriptURL; nil until loaded
+}
+
+type pacEntry struct {
+	proxy   *url.URL
+	expires time.Time // or zero, for never
+}
+
+// Proxy returns the proxy to use for req, as decided by the PAC
+// script. The first supported entry of the script's result is used:
+// DIRECT for no proxy, PROXY or HTTP host:port for an HTTP proxy,
+// HTTPS host:port for an HTTP proxy reached over TLS, SOCKS or
+// SOCKS4 host:port for a SOCKS4a proxy, or SOCKS5 host:port for a
+// SOCKS5 proxy.
+//
+// Proxy returns an error if the script can't be loaded or fails,
+// or if its result has no supported entry.
+func (p *PACResolver) Proxy(req *Request) (*url.URL, error) {
+	key := req.URL.Scheme + "://" + req.URL.Host + "/"
+	now := timeNow()
+	p.mu.Lock()
+	e, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e.proxy, nil
+	}
+
+	host := req.URL.Host
+	if hasPort(host) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	find := p.FindProxyForURL
+	if find == nil {
+		s, err := p.loadScript()
+		if err != nil {
+			return nil, err
+		}
+		find = s.findProxyForURL
+	}
+	result, err := find(key, host)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := parsePACResult(result)
+	if err != nil || p.CacheTTL < 0 {
+		return proxy, err
+	}
+
+	e = pacEntry{proxy: proxy}
+	if p.CacheTTL > 0 {
+		e.expires = now.Add(p.CacheTTL)
+	}
+	p.mu.Lock()
+	if p.cache == nil {
+		p.cache = make(map[string]pacEntry)
+	}
+	if _, ok := p.cache[key]; !ok {
+		p.makeRoomLocked(now)
+	}
+	p.cache[key] = e
+	p.mu.Unlock()
+	return proxy, nil
+}
+
+// makeRoomLocked discards cached answers, expired ones first, so
+// that one more can be added without exceeding MaxCacheEntries.
+func (p *PACResolver) makeRoomLocked(now time.Time) {
+	max := p.MaxCacheEntries
+	if max <= 0 {
+		max = defaultPACCacheEntries
+	}
+	if len(p.cache) < max {
+		return
+	}
+	for k, e := range p.cache {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(p.cache, k)
+		}
+	}
+	for k := range p.cache {
+		if len(p.cache) < max {
+			break
+		}
+		delete(p.cache, k)
+	}
+}
+
+// FlushCache discards all cached answers, and the script loaded
+// from ScriptURL, which is fetched again when next needed.
+func (p *PACResolver) FlushCache() {
+	p.mu.Lock()
+	p.cache = nil
+	p.mu.Unlock()
+	p.scriptMu.Lock()
+	p.script = nil
+	p.scriptMu.Unlock()
+}
+
+// loadScript returns the compiled script, compiling Script or
+// fetching ScriptURL if it hasn't been loaded yet.
+func (p *PACResolver) loadScript() (*pacScript, error) {
+	p.scriptMu.Lock()
+	defer p.scriptMu.Unlock()
+	if p.script != nil {
+		return p.script, nil
+	}
+	src := p.Script
+	if src == "" {
+		if p.ScriptURL == "" {
+			return nil, errors.New("http: PACResolver has no Script, ScriptURL or FindProxyForURL")
+		}
+		var err error
+		src, err = p.fetchScript()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s, err := compilePAC(src)
+	if err != nil {
+		return nil, err
+	}
+	p.script = s
+	return s, nil
+}
+
+func (p *PACResolver) fetchScript() (string, error) {
+	u, err := url.Parse(p.ScriptURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "file" {
+		b, err := ioutil.ReadFile(u.Path)
+		return string(b), err
+	}
+	c := p.Client
+	if c == nil {
+		c = &Client{Transport: &Transport{DisableKeepAlives: true}}
+	}
+	res, err := c.Get(p.ScriptURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != StatusOK {
+		return "", fmt.Errorf("http: fetching PAC script %s: %s", p.ScriptURL, res.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxPACScriptBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxPACScriptBytes {
+		return "", errors.New("http: PAC script " + p.ScriptURL + " is too large")
+	}
+	return string(b), nil
+}
+
+// parsePACResult returns the proxy named by the first supported
+// entry of a FindProxyForURL result, or nil for DIRECT.
+func parsePACResult(result string) (*url.URL, error) {
+	for _, entry := range strings.Split(result, ";") {
+		f := strings.Fields(entry)
+		if len(f) == 0 {
+			continue
+		}
+		var scheme string
+		switch strings.ToUpper(f[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS4":
+			scheme = "socks4"
+		case "SOCKS5":
+			scheme = "socks5"
+		default:
+			continue
+		}
+		if len(f) != 2 {
+			return nil, errors.New("http: malformed PAC result entry " + strings.TrimSpace(entry))
+		}
+		return &url.URL{Scheme: scheme, Host: f[1]}, nil
+	}
+	return nil, errors.New("http: no supported proxy in PAC result " + result)
+}
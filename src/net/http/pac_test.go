@@ -0,0 +1,376 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPACResolver(t *testing.T) {
+	tests := []struct {
+		url, result string
+		want        string // proxy URL, "<nil>" for none, or "error"
+	}{
+		{"http://example.com/", "DIRECT", "<nil>"},
+		{"http://example.com/", "PROXY proxy.example.com:8080; DIRECT", "http://proxy.example.com:8080"},
+		{"https://example.com/", "  proxy 10.0.0.1:3128 ", "http://10.0.0.1:3128"},
+		{"http://example.com/", "HTTP proxy:80", "http://proxy:80"},
+		{"http://example.com/", "HTTPS secure:443; SOCKS5 socks:1080", "https://secure:443"},
+		{"http://example.com/", "SOCKS5 socks:1080", "socks5://socks:1080"},
+		{"http://example.com/", "SOCKS socks:1080; DIRECT", "socks4://socks:1080"},
+		{"http://example.com/", "socks4 socks:1080", "socks4://socks:1080"},
+		{"http://example.com/", "QUIC quic:443; DIRECT", "<nil>"},
+		{"http://example.com/", "QUIC quic:443", "error"},
+		{"http://example.com/", "PROXY", "error"},
+		{"http://example.com/", "", "error"},
+	}
+	for _, tt := range tests {
+		p := &PACResolver{FindProxyForURL: func(url, host string) (string, error) {
+			return tt.result, nil
+		}}
+		req, _ := NewRequest("GET", tt.url, nil)
+		u, err := p.Proxy(req)
+		got := "error"
+		if err == nil {
+			got = "<nil>"
+			if u != nil {
+				got = u.String()
+			}
+		}
+		if got != tt.want {
+			t.Errorf("result %q: proxy = %s (%v); want %s", tt.result, got, err, tt.want)
+		}
+	}
+}
+
+func TestPACResolverCache(t *testing.T) {
+	clock := newFakeClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer clock.install()()
+
+	var calls []string
+	fail := false
+	p := &PACResolver{
+		FindProxyForURL: func(url, host string) (string, error) {
+			calls = append(calls, url+" "+host)
+			if fail {
+				return "", errors.New("script error")
+			}
+			return "PROXY proxy:8080", nil
+		},
+		CacheTTL: time.Minute,
+	}
+	proxy := func(url string) {
+		req, _ := NewRequest("GET", url, nil)
+		if _, err := p.Proxy(req); err != nil && !fail {
+			t.Errorf("Proxy(%s): %v", url, err)
+		}
+	}
+
+	proxy("http://a.example.com:81/x?q")
+	proxy("http://a.example.com:81/y")  // cached
+	proxy("https://a.example.com:81/x") // different scheme
+	proxy("http://[::1]/")
+	clock.Advance(2 * time.Minute)
+	proxy("http://a.example.com:81/z") // expired
+	p.FlushCache()
+	fail = true
+	proxy("http://[::1]/") // flushed; errors aren't cached
+	proxy("http://[::1]/")
+
+	// The script sees only the scheme and host.
+	want := []string{
+		"http://a.example.com:81/ a.example.com",
+		"https://a.example.com:81/ a.example.com",
+		"http://[::1]/ ::1",
+		"http://a.example.com:81/ a.example.com",
+		"http://[::1]/ ::1",
+		"http://[::1]/ ::1",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("FindProxyForURL calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPACResolverCacheLimit(t *testing.T) {
+	clock := newFakeClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer clock.install()()
+
+	var calls []string
+	p := &PACResolver{
+		FindProxyForURL: func(url, host string) (string, error) {
+			calls = append(calls, host)
+			return "DIRECT", nil
+		},
+		CacheTTL:        time.Minute,
+		MaxCacheEntries: 2,
+	}
+	proxy := func(host string) {
+		req, _ := NewRequest("GET", "http://"+host+"/", nil)
+		if _, err := p.Proxy(req); err != nil {
+			t.Errorf("Proxy(%s): %v", host, err)
+		}
+		if n := p.CacheSizeForTesting(); n > 2 {
+			t.Fatalf("after %s, %d cached answers; want at most 2", host, n)
+		}
+	}
+
+	proxy("a")
+	clock.Advance(2 * time.Minute)
+	proxy("b")
+	proxy("c") // a has expired and makes room
+	proxy("b") // still cached
+	proxy("c")
+	proxy("d") // b or c is discarded
+	proxy("d")
+
+	if want := "a b c d"; strings.Join(calls, " ") != want {
+		t.Errorf("FindProxyForURL called for %s; want %s", strings.Join(calls, " "), want)
+	}
+}
+
+const testPACScript = `
+// Proxy everything but local and internal hosts.
+var internal = ["corp.example.com", ".intranet"];
+
+function isInternal(host) {
+	for (var i = 0; i < internal.length; i++) {
+		if (dnsDomainIs(host, internal[i]))
+			return true;
+	}
+	return false;
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (isPlainHostName(host) || isInternal(host))
+		return "DIRECT";
+	if (isInNet(host, "10.0.0.0", "255.0.0.0"))
+		return "SOCKS socks.example.com:1080";
+	if (shExpMatch(url, "https://*.secure.example.com/*"))
+		return "HTTPS tls-proxy.example.com:443";
+	else if (url.substring(0, 5) == "http:" && host.indexOf("video") >= 0)
+		return "PROXY video-proxy.example.com:3128; DIRECT";
+	return "PROXY proxy.example.com:8080";
+}
+`
+
+// noLookup makes host names unresolvable, so that tests don't
+// depend on DNS.
+func noLookup(host string) ([]net.IP, error) {
+	return nil, errors.New("no DNS in tests: " + host)
+}
+
+func TestPACResolverScript(t *testing.T) {
+	defer SetLookupHook(noLookup)()
+	p := &PACResolver{Script: testPACScript}
+	tests := []struct {
+		url, want string
+	}{
+		{"http://localhost:8080/", "<nil>"},
+		{"http://www.corp.example.com/", "<nil>"},
+		{"https://WIKI.INTRANET/", "<nil>"},
+		{"http://10.1.2.3/", "socks4://socks.example.com:1080"},
+		{"http://11.1.2.3/", "http://proxy.example.com:8080"},
+		{"https://www.secure.example.com/login", "https://tls-proxy.example.com:443"},
+		{"http://www.secure.example.com/login", "http://proxy.example.com:8080"},
+		{"http://video.example.com/", "http://video-proxy.example.com:3128"},
+		{"https://video.example.com/", "http://proxy.example.com:8080"},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", tt.url, nil)
+		u, err := p.Proxy(req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		got := "<nil>"
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: proxy = %s; want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPACResolverScriptURL(t *testing.T) {
+	defer afterTest(t)
+	defer SetLookupHook(noLookup)()
+	var fetches int
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path != "/proxy.pac" {
+			NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		io.WriteString(w, testPACScript)
+	}))
+	defer ts.Close()
+
+	req, _ := NewRequest("GET", "http://www.example.com/", nil)
+	p := &PACResolver{ScriptURL: ts.URL + "/proxy.pac", CacheTTL: -1}
+	for i := 0; i < 2; i++ {
+		u, err := p.Proxy(req)
+		if err != nil || u == nil || u.Host != "proxy.example.com:8080" {
+			t.Fatalf("Proxy = %v, %v; want proxy.example.com:8080", u, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("script fetched %d times; want 1", fetches)
+	}
+	p.FlushCache()
+	p.Proxy(req)
+	if fetches != 2 {
+		t.Errorf("script fetched %d times after FlushCache; want 2", fetches)
+	}
+
+	p = &PACResolver{ScriptURL: ts.URL + "/missing.pac"}
+	if _, err := p.Proxy(req); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Proxy with a missing script: %v; want a 404 error", err)
+	}
+
+	f, err := ioutil.TempFile("", "pac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, testPACScript)
+	f.Close()
+	p = &PACResolver{ScriptURL: (&url.URL{Scheme: "file", Path: f.Name()}).String()}
+	if u, err := p.Proxy(req); err != nil || u == nil || u.Host != "proxy.example.com:8080" {
+		t.Errorf("Proxy with a file script = %v, %v; want proxy.example.com:8080", u, err)
+	}
+}
+
+func TestPACScript(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`1 + 2 * 3 - 4 / 2`, "5"},
+		{`7 % 4 + -1`, "2"},
+		{`"a" + 1 + 2`, "a12"},
+		{`1 + 2 + "a"`, "3a"},
+		{`0.5 + 1e2 + 0x10`, "116.5"},
+		{`"ab\"c\n".length`, "5"},
+		{`'it\'s'`, "it's"},
+		{`1 == "1"`, "true"},
+		{`1 === "1"`, "false"},
+		{`null == undefined`, "true"},
+		{`"b" > "a" && 2 < 10 && "2" < "10" == false`, "true"},
+		{`!"" || 0`, "true"},
+		{`"" || "x"`, "x"},
+		{`0 && "x"`, "0"},
+		{`1 ? "yes" : "no"`, "yes"},
+		{`typeof host + typeof 1 + typeof nothing + typeof isInNet`, "stringnumberundefinedfunction"},
+		{`"Hello".toUpperCase() + "Hello".toLowerCase()`, "HELLOhello"},
+		{`"a.b.c".split(".").join("-")`, "a-b-c"},
+		{`"a.b.c".lastIndexOf(".") + "a.b.c".indexOf(".", 2)`, "6"},
+		{`"abcdef".substring(4, 1) + "abcdef".substr(-2) + "abcdef".charAt(2)`, "bcdefc"},
+		{`"  x ".trim() + "aXbX".replace("X", "-")`, "xa-bX"},
+		{`[1, "two", [3]].length + [5, 6].indexOf(6)`, "4"},
+		{`["x", "y"][1] + "xyz"[2]`, "yz"},
+		{`(function (a, b) { return a + b; })(2, 3)`, "5"},
+		{`fact(10)`, "3628800"},
+		{`count()`, "10"},
+		{`dnsDomainLevels("www.example.com")`, "2"},
+		{`isPlainHostName("www") && !isPlainHostName("www.example.com")`, "true"},
+		{`localHostOrDomainIs("www", "www.example.com")`, "true"},
+		{`localHostOrDomainIs("www.example.com", "www.example.com")`, "true"},
+		{`localHostOrDomainIs("home.example.com", "www.example.com")`, "false"},
+		{`dnsDomainIs("www.example.com", ".example.com")`, "true"},
+		{`shExpMatch("www.example.com", "*.example.*")`, "true"},
+		{`shExpMatch("www.example.com", "w?w.*")`, "true"},
+		{`shExpMatch("www.example.com", "*.org")`, "false"},
+		{`shExpMatch("http://a/b/c", "*/b/*")`, "true"},
+		{`isInNet("192.168.1.5", "192.168.0.0", "255.255.0.0")`, "true"},
+		{`isInNet("192.169.1.5", "192.168.0.0", "255.255.0.0")`, "false"},
+		{`dnsResolve("10.1.2.3")`, "10.1.2.3"},
+		{`dnsResolve("proxy.test") + " " + isResolvable("proxy.test")`, "10.0.0.53 true"},
+		{`(dnsResolve("nowhere.test") == null) + " " + isResolvable("nowhere.test")`, "true false"},
+		{`isInNet("proxy.test", "10.0.0.0", "255.0.0.0")`, "true"},
+		{`weekdayRange("WED", "GMT") + " " + weekdayRange("MON", "FRI", "GMT") + " " + weekdayRange("SAT", "MON", "GMT")`, "true true false"},
+		{`timeRange(12, "GMT") + " " + timeRange(22, 13, "GMT") + " " + timeRange(12, 30, 0, 12, 31, 0, "GMT")`, "true true true"},
+		{`timeRange(8, 12, "GMT") + " " + timeRange(12, 0, 12, 30, "GMT")`, "false false"},
+	}
+	clock := newFakeClock(time.Date(2014, 1, 1, 12, 30, 15, 0, time.UTC)) // a Wednesday
+	defer clock.install()()
+	defer SetLookupHook(func(host string) ([]net.IP, error) {
+		if host == "proxy.test" {
+			return []net.IP{net.ParseIP("::1"), net.ParseIP("10.0.0.53")}, nil
+		}
+		return noLookup(host)
+	})()
+	for _, tt := range tests {
+		script := `
+function fact(n) { return n <= 1 ? 1 : n * fact(n - 1); }
+function count() {
+	var n = 0;
+	for (var i = 0; ; i++) {
+		if (i % 2 == 0) continue;
+		if (i > 20) break;
+		n += 1;
+	}
+	while (n < 10) n++;
+	return n;
+}
+function FindProxyForURL(url, host) { return "" + (` + tt.expr + `); }`
+		got, err := RunPACScript(script, "http://example.com/", "example.com")
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q; want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestPACScriptErrors(t *testing.T) {
+	tests := []struct {
+		script, err string
+	}{
+		{`function FindProxyForURL(url, host) { return "DIRECT"`, "line 1: expected }, found end of script"},
+		{`function FindProxyForURL(url, host) {
+			if (/\.example\.com$/.test(host)) return "DIRECT";
+		}`, `line 2: unexpected character '\\'`},
+		{`var x = "unterminated;`, "line 1: unterminated string"},
+		{`function findProxyForURL(url, host) { return "DIRECT"; }`, "no FindProxyForURL function"},
+		{`function FindProxyForURL(url, host) { return proxy; }`, "proxy is not defined"},
+		{`function FindProxyForURL(url, host) { return 1; }`, "FindProxyForURL returned number, not a string"},
+		{`function FindProxyForURL(url, host) { while (true) {} }`, "script ran too long"},
+		{`function f() { return f(); } function FindProxyForURL(url, host) { return f(); }`, "too much recursion"},
+		{`function FindProxyForURL(url, host) { return host(); }`, "calling string, not a function"},
+		{`function FindProxyForURL(url, host) { return undefined.x; }`, "reading property x of undefined"},
+		{`function FindProxyForURL(url, host) { if (dateRange("JAN", "MAR")) return "DIRECT"; }`, "dateRange is not supported"},
+		{`function FindProxyForURL(url, host) {
+			var s = "x";
+			for (var i = 0; i < 40; i++) s = s + s;
+			return s;
+		}`, "string too long"},
+		{`function FindProxyForURL(url, host) {
+			var s = "x", a = [];
+			while (s.length < 500000) s += s;
+			while (true) a.push(s + "y");
+		}`, "script used too much memory"},
+		{`function FindProxyForURL(url, host) { var a = []; a.push(a); return a + ""; }`, "arrays nested too deeply"},
+	}
+	for _, tt := range tests {
+		_, err := RunPACScript(tt.script, "http://example.com/", "example.com")
+		if want := "http: PAC script: " + tt.err; err == nil || err.Error() != want {
+			t.Errorf("script %q: error %v; want %s", tt.script, err, want)
+		}
+	}
+}
@@ -0,0 +1,1453 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Interpreter for proxy auto-config scripts.
+//
+// PAC scripts are JavaScript, but in practice they use a small part
+// of it: function and variable declarations, if/else, for and while
+// loops, string and array values, and the helper functions defined
+// by the PAC format, such as shExpMatch and isInNet. This file
+// interprets that subset. Regular expressions, objects, exceptions
+// and the Date type are not supported; a script that uses them
+// fails to compile or returns an error when run.
+
+package http
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A pacScript is a compiled PAC script.
+type pacScript struct {
+	mu      sync.Mutex // serializes runs, which may change globals
+	globals *pacScope
+}
+
+// pacError is the panic value for errors in compiling or running a
+// script; compilePAC and findProxyForURL recover it.
+type pacError string
+
+func (e pacError) Error() string { return "http: PAC script: " + string(e) }
+
+// Limits on a single run of a script, so that a buggy or hostile
+// script can't hang or crash the client: the statements and calls it
+// runs, how deeply calls and arrays nest, the length of any string,
+// and the bytes its new strings and array elements take up in all.
+const (
+	pacMaxSteps     = 1000000
+	pacMaxDepth     = 200
+	pacMaxStringLen = 1 << 20
+	pacMaxAlloc     = 32 << 20
+
+	pacElemSize = 16 // bytes charged for an array element
+)
+
+// compilePAC parses src and runs its top-level statements.
+func compilePAC(src string) (s *pacScript, err error) {
+	defer catchPACError(&err)
+	p := &pacParser{toks: pacTokenize(src)}
+	var prog []pacStmt
+	for p.peek().kind != pacTokEOF {
+		prog = append(prog, p.stmt())
+	}
+	s = &pacScript{globals: &pacScope{vars: make(map[string]pacValue)}}
+	for name, fn := range pacBuiltins {
+		s.globals.vars[name] = fn
+	}
+	r := &pacRun{}
+	r.execBody(prog, s.globals)
+	if _, ok := s.globals.vars["FindProxyForURL"].(*pacClosure); !ok {
+		return nil, pacError("no FindProxyForURL function")
+	}
+	return s, nil
+}
+
+// findProxyForURL calls the script's FindProxyForURL function.
+func (s *pacScript) findProxyForURL(url, host string) (result string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer catchPACError(&err)
+	r := &pacRun{}
+	v := r.call(s.globals.vars["FindProxyForURL"], []pacValue{url, host})
+	result, ok := v.(string)
+	if !ok {
+		return "", pacError("FindProxyForURL returned " + pacTypeof(v) + ", not a string")
+	}
+	return result, nil
+}
+
+func catchPACError(err *error) {
+	if e := recover(); e != nil {
+		pe, ok := e.(pacError)
+		if !ok {
+			panic(e)
+		}
+		*err = pe
+	}
+}
+
+// Lexer.
+
+const (
+	pacTokEOF = iota
+	pacTokIdent
+	pacTokNumber
+	pacTokString
+	pacTokPunct
+)
+
+type pacToken struct {
+	kind int
+	text string // identifier, punctuation, or decoded string
+	num  float64
+	line int
+}
+
+// pacPuncts lists the punctuation tokens, longest first.
+var pacPuncts = []string{
+	"===", "!==",
+	"==", "!=", "<=", ">=", "&&", "||", "+=", "-=", "++", "--",
+	"(", ")", "{", "}", "[", "]", ",", ";", ".", "?", ":",
+	"!", "=", "<", ">", "+", "-", "*", "/", "%",
+}
+
+func pacTokenize(src string) []pacToken {
+	var toks []pacToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				panic(pacError("line " + strconv.Itoa(line) + ": unterminated comment"))
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case isPACIdentByte(c) && !('0' <= c && c <= '9'):
+			j := i + 1
+			for j < len(src) && isPACIdentByte(src[j]) {
+				j++
+			}
+			toks = append(toks, pacToken{kind: pacTokIdent, text: src[i:j], line: line})
+			i = j
+		case '0' <= c && c <= '9' || c == '.' && i+1 < len(src) && '0' <= src[i+1] && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (isPACIdentByte(src[j]) || src[j] == '.' ||
+				(src[j] == '+' || src[j] == '-') && (src[j-1] == 'e' || src[j-1] == 'E') && src[i+1] != 'x' && src[i+1] != 'X') {
+				j++
+			}
+			var n float64
+			if strings.HasPrefix(src[i:j], "0x") || strings.HasPrefix(src[i:j], "0X") {
+				u, err := strconv.ParseUint(src[i+2:j], 16, 64)
+				if err != nil {
+					panic(pacError("line " + strconv.Itoa(line) + ": bad number " + src[i:j]))
+				}
+				n = float64(u)
+			} else {
+				var err error
+				n, err = strconv.ParseFloat(src[i:j], 64)
+				if err != nil {
+					panic(pacError("line " + strconv.Itoa(line) + ": bad number " + src[i:j]))
+				}
+			}
+			toks = append(toks, pacToken{kind: pacTokNumber, num: n, line: line})
+			i = j
+		case c == '"' || c == '\'':
+			var s []byte
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					break
+				}
+				if src[j] != '\\' || j+1 == len(src) {
+					s = append(s, src[j])
+					continue
+				}
+				j++
+				switch src[j] {
+				case 'n':
+					s = append(s, '\n')
+				case 't':
+					s = append(s, '\t')
+				case 'r':
+					s = append(s, '\r')
+				case '0':
+					s = append(s, 0)
+				default:
+					s = append(s, src[j])
+				}
+			}
+			if j == len(src) || src[j] != c {
+				panic(pacError("line " + strconv.Itoa(line) + ": unterminated string"))
+			}
+			toks = append(toks, pacToken{kind: pacTokString, text: string(s), line: line})
+			i = j + 1
+		default:
+			var punct string
+			for _, p := range pacPuncts {
+				if strings.HasPrefix(src[i:], p) {
+					punct = p
+					break
+				}
+			}
+			if punct == "" {
+				panic(pacError("line " + strconv.Itoa(line) + ": unexpected character " + strconv.QuoteRune(rune(c))))
+			}
+			toks = append(toks, pacToken{kind: pacTokPunct, text: punct, line: line})
+			i += len(punct)
+		}
+	}
+	return append(toks, pacToken{kind: pacTokEOF, line: line})
+}
+
+func isPACIdentByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '$'
+}
+
+// Syntax tree.
+
+type pacStmt interface{}
+
+type (
+	pacFuncStmt struct{ fn *pacFunc }
+	pacVarStmt  struct {
+		names []string
+		inits []pacExpr // nil for no initializer
+	}
+	pacIfStmt struct {
+		cond      pacExpr
+		then, els pacStmt // els may be nil
+	}
+	pacForStmt struct {
+		init pacStmt // may be nil
+		cond pacExpr // may be nil
+		post pacExpr // may be nil
+		body pacStmt
+	}
+	pacReturnStmt struct{ x pacExpr }  // x may be nil
+	pacBranchStmt struct{ tok string } // "break" or "continue"
+	pacBlockStmt  struct{ list []pacStmt }
+	pacExprStmt   struct{ x pacExpr }
+)
+
+type pacFunc struct {
+	name   string
+	params []string
+	body   []pacStmt
+}
+
+type pacExpr interface{}
+
+type (
+	pacLit      struct{ v pacValue }
+	pacIdent    struct{ name string }
+	pacFuncLit  struct{ fn *pacFunc }
+	pacArrayLit struct{ elems []pacExpr }
+	pacCall     struct {
+		fn   pacExpr
+		args []pacExpr
+	}
+	pacMember struct {
+		x    pacExpr
+		name string
+	}
+	pacIndex struct{ x, i pacExpr }
+	pacUnary struct {
+		op string
+		x  pacExpr
+	}
+	pacBinary struct {
+		op   string
+		x, y pacExpr
+	}
+	pacCond   struct{ cond, x, y pacExpr }
+	pacAssign struct {
+		op     string // "=", "+=" or "-="
+		target pacExpr
+		x      pacExpr
+	}
+	pacIncDec struct {
+		target pacExpr
+		delta  float64
+		prefix bool
+	}
+)
+
+// Parser.
+
+type pacParser struct {
+	toks []pacToken
+	pos  int
+}
+
+func (p *pacParser) peek() pacToken { return p.toks[p.pos] }
+
+func (p *pacParser) next() pacToken {
+	t := p.toks[p.pos]
+	if t.kind != pacTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuation or keyword s.
+func (p *pacParser) is(s string) bool {
+	t := p.peek()
+	return (t.kind == pacTokPunct || t.kind == pacTokIdent) && t.text == s
+}
+
+// got consumes the next token if it is s.
+func (p *pacParser) got(s string) bool {
+	if p.is(s) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *pacParser) want(s string) {
+	if !p.got(s) {
+		p.fail("expected " + s)
+	}
+}
+
+func (p *pacParser) ident() string {
+	if t := p.peek(); t.kind != pacTokIdent || pacKeywords[t.text] {
+		p.fail("expected name")
+	}
+	return p.next().text
+}
+
+func (p *pacParser) fail(msg string) {
+	t := p.peek()
+	found := t.text
+	switch t.kind {
+	case pacTokEOF:
+		found = "end of script"
+	case pacTokNumber:
+		found = "number"
+	case pacTokString:
+		found = "string"
+	}
+	panic(pacError("line " + strconv.Itoa(t.line) + ": " + msg + ", found " + found))
+}
+
+var pacKeywords = map[string]bool{
+	"break": true, "continue": true, "else": true, "false": true,
+	"for": true, "function": true, "if": true, "null": true,
+	"return": true, "true": true, "typeof": true, "undefined": true,
+	"var": true, "while": true,
+}
+
+func (p *pacParser) stmt() pacStmt {
+	switch {
+	case p.got(";"):
+		return &pacBlockStmt{}
+	case p.got("{"):
+		b := &pacBlockStmt{}
+		for !p.got("}") {
+			if p.peek().kind == pacTokEOF {
+				p.fail("expected }")
+			}
+			b.list = append(b.list, p.stmt())
+		}
+		return b
+	case p.got("function"):
+		return &pacFuncStmt{p.function(p.ident())}
+	case p.is("var"):
+		s := p.varStmt()
+		p.got(";")
+		return s
+	case p.got("if"):
+		s := &pacIfStmt{}
+		p.want("(")
+		s.cond = p.expr()
+		p.want(")")
+		s.then = p.stmt()
+		if p.got("else") {
+			s.els = p.stmt()
+		}
+		return s
+	case p.got("while"):
+		s := &pacForStmt{}
+		p.want("(")
+		s.cond = p.expr()
+		p.want(")")
+		s.body = p.stmt()
+		return s
+	case p.got("for"):
+		s := &pacForStmt{}
+		p.want("(")
+		if p.is("var") {
+			s.init = p.varStmt()
+		} else if !p.is(";") {
+			s.init = &pacExprStmt{p.expr()}
+		}
+		p.want(";")
+		if !p.is(";") {
+			s.cond = p.expr()
+		}
+		p.want(";")
+		if !p.is(")") {
+			s.post = p.expr()
+		}
+		p.want(")")
+		s.body = p.stmt()
+		return s
+	case p.got("return"):
+		s := &pacReturnStmt{}
+		if !p.is(";") && !p.is("}") && p.peek().kind != pacTokEOF {
+			s.x = p.expr()
+		}
+		p.got(";")
+		return s
+	case p.is("break") || p.is("continue"):
+		s := &pacBranchStmt{p.next().text}
+		p.got(";")
+		return s
+	}
+	s := &pacExprStmt{p.expr()}
+	p.got(";")
+	return s
+}
+
+func (p *pacParser) varStmt() *pacVarStmt {
+	p.want("var")
+	s := &pacVarStmt{}
+	for {
+		s.names = append(s.names, p.ident())
+		var init pacExpr
+		if p.got("=") {
+			init = p.assign()
+		}
+		s.inits = append(s.inits, init)
+		if !p.got(",") {
+			return s
+		}
+	}
+}
+
+func (p *pacParser) function(name string) *pacFunc {
+	fn := &pacFunc{name: name}
+	p.want("(")
+	for !p.got(")") {
+		if len(fn.params) > 0 {
+			p.want(",")
+		}
+		fn.params = append(fn.params, p.ident())
+	}
+	body, ok := p.stmt().(*pacBlockStmt)
+	if !ok {
+		p.fail("expected function body")
+	}
+	fn.body = body.list
+	return fn
+}
+
+func (p *pacParser) expr() pacExpr {
+	x := p.assign()
+	for p.got(",") {
+		// The comma operator evaluates both and yields the second.
+		x = &pacBinary{",", x, p.assign()}
+	}
+	return x
+}
+
+func (p *pacParser) assign() pacExpr {
+	x := p.cond()
+	for _, op := range []string{"=", "+=", "-="} {
+		if p.got(op) {
+			switch x.(type) {
+			case *pacIdent, *pacIndex:
+			default:
+				p.fail("invalid assignment target")
+			}
+			return &pacAssign{op, x, p.assign()}
+		}
+	}
+	return x
+}
+
+func (p *pacParser) cond() pacExpr {
+	x := p.binary(0)
+	if p.got("?") {
+		c := &pacCond{cond: x, x: p.assign()}
+		p.want(":")
+		c.y = p.assign()
+		return c
+	}
+	return x
+}
+
+// pacBinaryOps lists the binary operators by increasing precedence.
+var pacBinaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"===", "!==", "==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *pacParser) binary(prec int) pacExpr {
+	if prec == len(pacBinaryOps) {
+		return p.unary()
+	}
+	x := p.binary(prec + 1)
+loop:
+	for {
+		for _, op := range pacBinaryOps[prec] {
+			if p.peek().kind == pacTokPunct && p.got(op) {
+				x = &pacBinary{op, x, p.binary(prec + 1)}
+				continue loop
+			}
+		}
+		return x
+	}
+}
+
+func (p *pacParser) unary() pacExpr {
+	for _, op := range []string{"!", "-", "+", "typeof"} {
+		if p.got(op) {
+			return &pacUnary{op, p.unary()}
+		}
+	}
+	if p.got("++") {
+		return &pacIncDec{p.incDecTarget(p.unary()), 1, true}
+	}
+	if p.got("--") {
+		return &pacIncDec{p.incDecTarget(p.unary()), -1, true}
+	}
+	return p.postfix()
+}
+
+func (p *pacParser) incDecTarget(x pacExpr) pacExpr {
+	switch x.(type) {
+	case *pacIdent, *pacIndex:
+		return x
+	}
+	p.fail("invalid increment target")
+	panic("unreachable")
+}
+
+func (p *pacParser) postfix() pacExpr {
+	x := p.primary()
+	for {
+		switch {
+		case p.got("("):
+			c := &pacCall{fn: x}
+			for !p.got(")") {
+				if len(c.args) > 0 {
+					p.want(",")
+				}
+				c.args = append(c.args, p.assign())
+			}
+			x = c
+		case p.got("."):
+			if p.peek().kind != pacTokIdent {
+				p.fail("expected property name")
+			}
+			x = &pacMember{x, p.next().text}
+		case p.got("["):
+			x = &pacIndex{x, p.expr()}
+			p.want("]")
+		case p.got("++"):
+			x = &pacIncDec{p.incDecTarget(x), 1, false}
+		case p.got("--"):
+			x = &pacIncDec{p.incDecTarget(x), -1, false}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *pacParser) primary() pacExpr {
+	t := p.peek()
+	switch t.kind {
+	case pacTokNumber:
+		p.next()
+		return &pacLit{t.num}
+	case pacTokString:
+		p.next()
+		return &pacLit{t.text}
+	case pacTokIdent:
+		switch t.text {
+		case "true", "false":
+			p.next()
+			return &pacLit{t.text == "true"}
+		case "null", "undefined":
+			p.next()
+			return &pacLit{nil}
+		case "function":
+			p.next()
+			name := ""
+			if !p.is("(") {
+				name = p.ident()
+			}
+			return &pacFuncLit{p.function(name)}
+		}
+		return &pacIdent{p.ident()}
+	}
+	switch {
+	case p.got("("):
+		x := p.expr()
+		p.want(")")
+		return x
+	case p.got("["):
+		a := &pacArrayLit{}
+		for !p.got("]") {
+			if len(a.elems) > 0 {
+				p.want(",")
+				if p.got("]") {
+					break
+				}
+			}
+			a.elems = append(a.elems, p.assign())
+		}
+		return a
+	}
+	p.fail("unexpected token")
+	panic("unreachable")
+}
+
+// Values.
+
+// A pacValue is nil (for undefined and null), a string, a float64,
+// a bool, a *pacArray, a *pacClosure or a pacBuiltin.
+type pacValue interface{}
+
+type pacArray struct{ elems []pacValue }
+
+type pacClosure struct {
+	fn    *pacFunc
+	scope *pacScope
+}
+
+type pacBuiltin func(r *pacRun, args []pacValue) pacValue
+
+type pacScope struct {
+	vars   map[string]pacValue
+	parent *pacScope
+}
+
+// lookup returns the innermost scope defining name, or nil.
+func (s *pacScope) lookup(name string) *pacScope {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+func pacTypeof(v pacValue) string {
+	switch v.(type) {
+	case nil:
+		return "undefined"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case *pacClosure, pacBuiltin:
+		return "function"
+	}
+	return "object"
+}
+
+func pacToBool(v pacValue) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func pacToNumber(v pacValue) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return 0
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	}
+	return math.NaN()
+}
+
+func pacToString(v pacValue) string {
+	switch v := v.(type) {
+	case nil:
+		return "undefined"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == math.Trunc(v) && math.Abs(v) < 1e21:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case *pacArray:
+		return pacJoin(v, ",", 0)
+	}
+	return "function"
+}
+
+// pacJoin joins the elements of a, which is nested depth arrays
+// deep, as strings separated by sep. It fails if a nests too deeply,
+// as an array containing itself does, or if the result would be too
+// long.
+func pacJoin(a *pacArray, sep string, depth int) string {
+	if depth > pacMaxDepth {
+		panic(pacError("arrays nested too deeply"))
+	}
+	s := make([]string, len(a.elems))
+	n := 0
+	for i, e := range a.elems {
+		if b, ok := e.(*pacArray); ok {
+			s[i] = pacJoin(b, ",", depth+1)
+		} else if e != nil {
+			s[i] = pacToString(e)
+		}
+		if n += len(s[i]) + len(sep); n > pacMaxStringLen+len(sep) {
+			panic(pacError("string too long"))
+		}
+	}
+	return strings.Join(s, sep)
+}
+
+func pacStrictEqual(x, y pacValue) bool {
+	switch x := x.(type) {
+	case nil:
+		return y == nil
+	case string:
+		y, ok := y.(string)
+		return ok && x == y
+	case float64:
+		y, ok := y.(float64)
+		return ok && x == y
+	case bool:
+		y, ok := y.(bool)
+		return ok && x == y
+	case *pacArray:
+		y, ok := y.(*pacArray)
+		return ok && x == y
+	case *pacClosure:
+		y, ok := y.(*pacClosure)
+		return ok && x == y
+	}
+	return false // builtins can't be compared
+}
+
+func pacLooseEqual(x, y pacValue) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if pacTypeof(x) == pacTypeof(y) {
+		return pacStrictEqual(x, y)
+	}
+	_, xs := x.(string)
+	_, ys := y.(string)
+	_, xb := x.(bool)
+	_, yb := y.(bool)
+	_, xn := x.(float64)
+	_, yn := y.(float64)
+	if (xs || xb || xn) && (ys || yb || yn) {
+		return pacToNumber(x) == pacToNumber(y)
+	}
+	return pacToString(x) == pacToString(y)
+}
+
+// Evaluator.
+
+// A pacRun is one evaluation, counting steps and call depth.
+type pacRun struct {
+	steps, depth int
+	allocated    int // bytes of new strings and array elements
+}
+
+// Statement outcomes, other than running to completion.
+const (
+	pacNormal = iota
+	pacReturn
+	pacBreak
+	pacContinue
+)
+
+func (r *pacRun) fail(msg string) {
+	panic(pacError(msg))
+}
+
+// alloc accounts for n bytes of new strings or array elements.
+func (r *pacRun) alloc(n int) {
+	r.allocated += n
+	if r.allocated > pacMaxAlloc {
+		r.fail("script used too much memory")
+	}
+}
+
+// newString accounts for a new string of n bytes.
+func (r *pacRun) newString(n int) {
+	if n > pacMaxStringLen {
+		r.fail("string too long")
+	}
+	r.alloc(n)
+}
+
+func (r *pacRun) step() {
+	r.steps++
+	if r.steps > pacMaxSteps {
+		r.fail("script ran too long")
+	}
+}
+
+// execBody hoists the function declarations in body into scope and
+// runs body's statements.
+func (r *pacRun) execBody(body []pacStmt, scope *pacScope) (ctl int, v pacValue) {
+	for _, s := range body {
+		if f, ok := s.(*pacFuncStmt); ok {
+			scope.vars[f.fn.name] = &pacClosure{f.fn, scope}
+		}
+	}
+	return r.execList(body, scope)
+}
+
+func (r *pacRun) execList(list []pacStmt, scope *pacScope) (ctl int, v pacValue) {
+	for _, s := range list {
+		if ctl, v = r.exec(s, scope); ctl != pacNormal {
+			return ctl, v
+		}
+	}
+	return pacNormal, nil
+}
+
+func (r *pacRun) exec(s pacStmt, scope *pacScope) (ctl int, v pacValue) {
+	r.step()
+	switch s := s.(type) {
+	case *pacFuncStmt:
+		// Hoisted by execBody; nested declarations are defined
+		// when reached.
+		if _, ok := scope.vars[s.fn.name]; !ok {
+			scope.vars[s.fn.name] = &pacClosure{s.fn, scope}
+		}
+	case *pacVarStmt:
+		for i, name := range s.names {
+			if s.inits[i] != nil {
+				scope.vars[name] = r.eval(s.inits[i], scope)
+			} else if _, ok := scope.vars[name]; !ok {
+				scope.vars[name] = nil
+			}
+		}
+	case *pacIfStmt:
+		if pacToBool(r.eval(s.cond, scope)) {
+			return r.exec(s.then, scope)
+		} else if s.els != nil {
+			return r.exec(s.els, scope)
+		}
+	case *pacForStmt:
+		if s.init != nil {
+			r.exec(s.init, scope)
+		}
+		for s.cond == nil || pacToBool(r.eval(s.cond, scope)) {
+			ctl, v = r.exec(s.body, scope)
+			if ctl == pacReturn {
+				return ctl, v
+			}
+			if ctl == pacBreak {
+				break
+			}
+			if s.post != nil {
+				r.eval(s.post, scope)
+			}
+			r.step()
+		}
+	case *pacReturnStmt:
+		if s.x != nil {
+			v = r.eval(s.x, scope)
+		}
+		return pacReturn, v
+	case *pacBranchStmt:
+		if s.tok == "break" {
+			return pacBreak, nil
+		}
+		return pacContinue, nil
+	case *pacBlockStmt:
+		return r.execList(s.list, scope)
+	case *pacExprStmt:
+		r.eval(s.x, scope)
+	}
+	return pacNormal, nil
+}
+
+func (r *pacRun) eval(x pacExpr, scope *pacScope) pacValue {
+	switch x := x.(type) {
+	case *pacLit:
+		return x.v
+	case *pacIdent:
+		s := scope.lookup(x.name)
+		if s == nil {
+			r.fail(x.name + " is not defined")
+		}
+		return s.vars[x.name]
+	case *pacFuncLit:
+		return &pacClosure{x.fn, scope}
+	case *pacArrayLit:
+		r.alloc(len(x.elems) * pacElemSize)
+		a := &pacArray{make([]pacValue, len(x.elems))}
+		for i, e := range x.elems {
+			a.elems[i] = r.eval(e, scope)
+		}
+		return a
+	case *pacCall:
+		fn := r.eval(x.fn, scope)
+		args := make([]pacValue, len(x.args))
+		for i, a := range x.args {
+			args[i] = r.eval(a, scope)
+		}
+		return r.call(fn, args)
+	case *pacMember:
+		return r.member(r.eval(x.x, scope), x.name)
+	case *pacIndex:
+		v, i := r.eval(x.x, scope), r.eval(x.i, scope)
+		switch v := v.(type) {
+		case *pacArray:
+			n := pacToNumber(i)
+			if n >= 0 && n < float64(len(v.elems)) && n == math.Trunc(n) {
+				return v.elems[int(n)]
+			}
+			return nil
+		case string:
+			n := pacToNumber(i)
+			if n >= 0 && n < float64(len(v)) && n == math.Trunc(n) {
+				return v[int(n) : int(n)+1]
+			}
+			return nil
+		}
+		return r.member(v, pacToString(i))
+	case *pacUnary:
+		if id, ok := x.x.(*pacIdent); ok && x.op == "typeof" && scope.lookup(id.name) == nil {
+			return "undefined"
+		}
+		v := r.eval(x.x, scope)
+		switch x.op {
+		case "!":
+			return !pacToBool(v)
+		case "-":
+			return -pacToNumber(v)
+		case "+":
+			return pacToNumber(v)
+		}
+		return pacTypeof(v)
+	case *pacBinary:
+		return r.binary(x, scope)
+	case *pacCond:
+		if pacToBool(r.eval(x.cond, scope)) {
+			return r.eval(x.x, scope)
+		}
+		return r.eval(x.y, scope)
+	case *pacAssign:
+		v := r.eval(x.x, scope)
+		if x.op != "=" {
+			old := r.eval(x.target, scope)
+			if x.op == "+=" {
+				v = r.add(old, v)
+			} else {
+				v = pacToNumber(old) - pacToNumber(v)
+			}
+		}
+		r.store(x.target, v, scope)
+		return v
+	case *pacIncDec:
+		old := pacToNumber(r.eval(x.target, scope))
+		r.store(x.target, old+x.delta, scope)
+		if x.prefix {
+			return old + x.delta
+		}
+		return old
+	}
+	panic("unreachable")
+}
+
+// store assigns v to target, an identifier or an array element.
+// Assigning to an undeclared identifier defines a global, as in
+// JavaScript.
+func (r *pacRun) store(target pacExpr, v pacValue, scope *pacScope) {
+	switch t := target.(type) {
+	case *pacIdent:
+		s := scope.lookup(t.name)
+		if s == nil {
+			for s = scope; s.parent != nil; s = s.parent {
+			}
+		}
+		s.vars[t.name] = v
+	case *pacIndex:
+		a, ok := r.eval(t.x, scope).(*pacArray)
+		if !ok {
+			r.fail("can only assign to elements of arrays")
+		}
+		n := pacToNumber(r.eval(t.i, scope))
+		if n < 0 || n != math.Trunc(n) || n > 1<<20 {
+			r.fail("bad array index " + pacToString(n))
+		}
+		if grow := int(n) + 1 - len(a.elems); grow > 0 {
+			r.alloc(grow * pacElemSize)
+			a.elems = append(a.elems, make([]pacValue, grow)...)
+		}
+		a.elems[int(n)] = v
+	}
+}
+
+func (r *pacRun) add(x, y pacValue) pacValue {
+	_, xs := x.(string)
+	_, ys := y.(string)
+	_, xa := x.(*pacArray)
+	_, ya := y.(*pacArray)
+	if xs || ys || xa || ya {
+		xstr, ystr := pacToString(x), pacToString(y)
+		r.newString(len(xstr) + len(ystr))
+		return xstr + ystr
+	}
+	return pacToNumber(x) + pacToNumber(y)
+}
+
+func (r *pacRun) binary(x *pacBinary, scope *pacScope) pacValue {
+	v := r.eval(x.x, scope)
+	switch x.op {
+	case "&&":
+		if !pacToBool(v) {
+			return v
+		}
+		return r.eval(x.y, scope)
+	case "||":
+		if pacToBool(v) {
+			return v
+		}
+		return r.eval(x.y, scope)
+	case ",":
+		return r.eval(x.y, scope)
+	}
+	w := r.eval(x.y, scope)
+	switch x.op {
+	case "+":
+		return r.add(v, w)
+	case "-":
+		return pacToNumber(v) - pacToNumber(w)
+	case "*":
+		return pacToNumber(v) * pacToNumber(w)
+	case "/":
+		return pacToNumber(v) / pacToNumber(w)
+	case "%":
+		return math.Mod(pacToNumber(v), pacToNumber(w))
+	case "===":
+		return pacStrictEqual(v, w)
+	case "!==":
+		return !pacStrictEqual(v, w)
+	case "==":
+		return pacLooseEqual(v, w)
+	case "!=":
+		return !pacLooseEqual(v, w)
+	}
+	// Relational operators compare strings as strings, and
+	// anything else as numbers.
+	vs, ok1 := v.(string)
+	ws, ok2 := w.(string)
+	if ok1 && ok2 {
+		switch x.op {
+		case "<":
+			return vs < ws
+		case ">":
+			return vs > ws
+		case "<=":
+			return vs <= ws
+		}
+		return vs >= ws
+	}
+	vn, wn := pacToNumber(v), pacToNumber(w)
+	switch x.op {
+	case "<":
+		return vn < wn
+	case ">":
+		return vn > wn
+	case "<=":
+		return vn <= wn
+	}
+	return vn >= wn
+}
+
+func (r *pacRun) call(fn pacValue, args []pacValue) pacValue {
+	r.step()
+	switch fn := fn.(type) {
+	case pacBuiltin:
+		return fn(r, args)
+	case *pacClosure:
+		if r.depth >= pacMaxDepth {
+			r.fail("too much recursion")
+		}
+		r.depth++
+		defer func() { r.depth-- }()
+		scope := &pacScope{vars: make(map[string]pacValue), parent: fn.scope}
+		for i, name := range fn.fn.params {
+			var v pacValue
+			if i < len(args) {
+				v = args[i]
+			}
+			scope.vars[name] = v
+		}
+		_, v := r.execBody(fn.fn.body, scope)
+		return v
+	}
+	r.fail("calling " + pacTypeof(fn) + ", not a function")
+	panic("unreachable")
+}
+
+// member returns the named property of v. Methods are returned bound
+// to v.
+func (r *pacRun) member(v pacValue, name string) pacValue {
+	switch v := v.(type) {
+	case string:
+		if name == "length" {
+			return float64(len(v))
+		}
+		if m, ok := pacStringMethods[name]; ok {
+			return pacBuiltin(func(r *pacRun, args []pacValue) pacValue {
+				return m(r, v, args)
+			})
+		}
+	case *pacArray:
+		switch name {
+		case "length":
+			return float64(len(v.elems))
+		case "indexOf":
+			return pacBuiltin(func(r *pacRun, args []pacValue) pacValue {
+				for i, e := range v.elems {
+					if pacStrictEqual(e, pacArg(args, 0)) {
+						return float64(i)
+					}
+				}
+				return float64(-1)
+			})
+		case "join":
+			return pacBuiltin(func(r *pacRun, args []pacValue) pacValue {
+				sep := ","
+				if len(args) > 0 && args[0] != nil {
+					sep = pacToString(args[0])
+				}
+				joined := pacJoin(v, sep, 0)
+				r.newString(len(joined))
+				return joined
+			})
+		case "push":
+			return pacBuiltin(func(r *pacRun, args []pacValue) pacValue {
+				r.alloc(len(args) * pacElemSize)
+				v.elems = append(v.elems, args...)
+				return float64(len(v.elems))
+			})
+		}
+	case nil:
+		r.fail("reading property " + name + " of undefined")
+	}
+	return nil
+}
+
+func pacArg(args []pacValue, i int) pacValue {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// pacIndexArg converts args[i] to an index in [0, n], defaulting to
+// def.
+func pacIndexArg(args []pacValue, i, n, def int) int {
+	if i >= len(args) || args[i] == nil {
+		return def
+	}
+	f := pacToNumber(args[i])
+	switch {
+	case math.IsNaN(f) || f < 0:
+		return 0
+	case f > float64(n):
+		return n
+	}
+	return int(f)
+}
+
+// pacStringMethods are the methods of strings. Those returning
+// substrings share the string's memory; the others account for
+// the strings they make with r.
+var pacStringMethods = map[string]func(r *pacRun, s string, args []pacValue) pacValue{
+	"toLowerCase": func(r *pacRun, s string, args []pacValue) pacValue {
+		r.newString(len(s))
+		return strings.ToLower(s)
+	},
+	"toUpperCase": func(r *pacRun, s string, args []pacValue) pacValue {
+		r.newString(len(s))
+		return strings.ToUpper(s)
+	},
+	"trim": func(r *pacRun, s string, args []pacValue) pacValue { return strings.TrimSpace(s) },
+	"charAt": func(r *pacRun, s string, args []pacValue) pacValue {
+		i := pacIndexArg(args, 0, len(s), 0)
+		if i >= len(s) {
+			return ""
+		}
+		return s[i : i+1]
+	},
+	"indexOf": func(r *pacRun, s string, args []pacValue) pacValue {
+		from := pacIndexArg(args, 1, len(s), 0)
+		i := strings.Index(s[from:], pacToString(pacArg(args, 0)))
+		if i < 0 {
+			return float64(-1)
+		}
+		return float64(from + i)
+	},
+	"lastIndexOf": func(r *pacRun, s string, args []pacValue) pacValue {
+		return float64(strings.LastIndex(s, pacToString(pacArg(args, 0))))
+	},
+	"substring": func(r *pacRun, s string, args []pacValue) pacValue {
+		i, j := pacIndexArg(args, 0, len(s), 0), pacIndexArg(args, 1, len(s), len(s))
+		if i > j {
+			i, j = j, i
+		}
+		return s[i:j]
+	},
+	"substr": func(r *pacRun, s string, args []pacValue) pacValue {
+		i := 0
+		if len(args) > 0 {
+			f := pacToNumber(args[0])
+			if f < 0 {
+				f += float64(len(s))
+			}
+			i = pacIndexArg([]pacValue{f}, 0, len(s), 0)
+		}
+		n := pacIndexArg(args, 1, len(s)-i, len(s)-i)
+		return s[i : i+n]
+	},
+	"split": func(r *pacRun, s string, args []pacValue) pacValue {
+		a := &pacArray{}
+		if len(args) == 0 || args[0] == nil {
+			a.elems = []pacValue{s}
+			return a
+		}
+		parts := strings.Split(s, pacToString(args[0]))
+		r.alloc(len(parts) * pacElemSize)
+		for _, e := range parts {
+			a.elems = append(a.elems, e)
+		}
+		return a
+	},
+	"replace": func(r *pacRun, s string, args []pacValue) pacValue {
+		old, new := pacToString(pacArg(args, 0)), pacToString(pacArg(args, 1))
+		if i := strings.Index(s, old); i >= 0 {
+			r.newString(len(s) - len(old) + len(new))
+			return s[:i] + new + s[i+len(old):]
+		}
+		return s
+	},
+}
+
+// The PAC helper functions.
+
+var pacBuiltins = map[string]pacBuiltin{
+	"isPlainHostName": func(r *pacRun, args []pacValue) pacValue {
+		return !strings.Contains(pacToString(pacArg(args, 0)), ".")
+	},
+	"dnsDomainIs": func(r *pacRun, args []pacValue) pacValue {
+		host, domain := pacToString(pacArg(args, 0)), pacToString(pacArg(args, 1))
+		return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+	},
+	"localHostOrDomainIs": func(r *pacRun, args []pacValue) pacValue {
+		host, hostdom := pacToString(pacArg(args, 0)), pacToString(pacArg(args, 1))
+		if strings.EqualFold(host, hostdom) {
+			return true
+		}
+		return !strings.Contains(host, ".") && strings.HasPrefix(strings.ToLower(hostdom), strings.ToLower(host)+".")
+	},
+	"dnsDomainLevels": func(r *pacRun, args []pacValue) pacValue {
+		return float64(strings.Count(pacToString(pacArg(args, 0)), "."))
+	},
+	"shExpMatch": func(r *pacRun, args []pacValue) pacValue {
+		return pacShExpMatch(pacToString(pacArg(args, 0)), pacToString(pacArg(args, 1)))
+	},
+	"isResolvable": func(r *pacRun, args []pacValue) pacValue {
+		return pacResolve(pacToString(pacArg(args, 0))) != nil
+	},
+	"dnsResolve": func(r *pacRun, args []pacValue) pacValue {
+		if ip := pacResolve(pacToString(pacArg(args, 0))); ip != nil {
+			return ip.String()
+		}
+		return nil
+	},
+	"isInNet": func(r *pacRun, args []pacValue) pacValue {
+		ip := pacResolve(pacToString(pacArg(args, 0))).To4()
+		pattern := net.ParseIP(pacToString(pacArg(args, 1))).To4()
+		mask := net.ParseIP(pacToString(pacArg(args, 2))).To4()
+		if ip == nil || pattern == nil || mask == nil {
+			return false
+		}
+		return ip.Mask(net.IPMask(mask)).Equal(pattern.Mask(net.IPMask(mask)))
+	},
+	"myIpAddress": func(r *pacRun, args []pacValue) pacValue {
+		addrs, _ := net.InterfaceAddrs()
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+				return n.IP.String()
+			}
+		}
+		return "127.0.0.1"
+	},
+	"weekdayRange": func(r *pacRun, args []pacValue) pacValue {
+		now, args := pacNow(args)
+		if len(args) == 0 {
+			return false
+		}
+		d1 := pacWeekday(args[0])
+		d2 := d1
+		if len(args) > 1 {
+			d2 = pacWeekday(args[1])
+		}
+		if d1 < 0 || d2 < 0 {
+			return false
+		}
+		return pacInRange(int(now.Weekday()), d1, d2+1)
+	},
+	"timeRange": func(r *pacRun, args []pacValue) pacValue {
+		now, args := pacNow(args)
+		n := make([]int, len(args))
+		for i, a := range args {
+			n[i] = int(pacToNumber(a))
+		}
+		h, m, s := now.Clock()
+		switch len(n) {
+		case 1:
+			return h == n[0]
+		case 2:
+			return pacInRange(h, n[0], n[1])
+		case 4:
+			return pacInRange(h*60+m, n[0]*60+n[1], n[2]*60+n[3])
+		case 6:
+			return pacInRange(h*3600+m*60+s, n[0]*3600+n[1]*60+n[2], n[3]*3600+n[4]*60+n[5])
+		}
+		return false
+	},
+	"dateRange": func(r *pacRun, args []pacValue) pacValue {
+		r.fail("dateRange is not supported")
+		return nil
+	},
+	"alert": func(r *pacRun, args []pacValue) pacValue {
+		return nil
+	},
+}
+
+// pacResolve returns host's address, preferring IPv4, or nil if it
+// can't be resolved.
+func pacResolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	ips, err := lookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip
+		}
+	}
+	return ips[0]
+}
+
+// pacShExpMatch reports whether s matches the shell expression
+// pattern, in which * matches any string and ? any one character.
+func pacShExpMatch(s, pattern string) bool {
+	star, mark := -1, 0 // pattern index after the last *, and where its match ends in s
+	i, j := 0, 0
+	for i < len(s) {
+		switch {
+		case j < len(pattern) && (pattern[j] == '?' || pattern[j] == s[i]):
+			i++
+			j++
+		case j < len(pattern) && pattern[j] == '*':
+			j++
+			star, mark = j, i
+		case star >= 0:
+			mark++
+			i, j = mark, star
+		default:
+			return false
+		}
+	}
+	for j < len(pattern) && pattern[j] == '*' {
+		j++
+	}
+	return j == len(pattern)
+}
+
+// pacNow returns the current time, in UTC if the last argument is
+// "GMT", and the arguments without it.
+func pacNow(args []pacValue) (time.Time, []pacValue) {
+	now := timeNow()
+	if n := len(args); n > 0 && args[n-1] == "GMT" {
+		return now.UTC(), args[:n-1]
+	}
+	return now.Local(), args
+}
+
+var pacWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+func pacWeekday(v pacValue) int {
+	for i, d := range pacWeekdays {
+		if v == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// pacInRange reports whether n is in [lo, hi), a range that wraps
+// around if lo > hi, as in timeRange(22, 6) for the night.
+func pacInRange(n, lo, hi int) bool {
+	if lo <= hi {
+		return lo <= n && n < hi
+	}
+	return n >= lo || n < hi
+}
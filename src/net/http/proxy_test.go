@@ -44,6 +44,37 @@ func TestUseProxy(t *testing.T) {
 	}
 }
 
+func TestUseProxyPatterns(t *testing.T) {
+	ResetProxyEnv()
+	defer ResetProxyEnv()
+	os.Setenv("NO_PROXY", "10.0.0.0/8, fd00::/8, *.example.com, 192.168.1.1, bogus/cidr")
+	tests := []struct {
+		addr  string
+		match bool
+	}{
+		{"10.1.2.3:80", false},
+		{"11.1.2.3:80", true},
+		{"[fd00::1]:443", false},
+		{"[fe00::1]:443", true},
+		{"www.example.com:80", false},
+		{"example.com:80", false},
+		{"badexample.com:80", true},
+		{"192.168.1.1:8080", false},
+		{"192.168.1.2:8080", true},
+	}
+	for _, tt := range tests {
+		if got := useProxy(tt.addr); got != tt.match {
+			t.Errorf("useProxy(%q) = %v; want %v", tt.addr, got, tt.match)
+		}
+	}
+
+	os.Setenv("NO_PROXY", "foo.com, *")
+	ResetCachedEnvironment()
+	if useProxy("bar.com:80") {
+		t.Error(`useProxy("bar.com:80") = true with NO_PROXY entry "*"`)
+	}
+}
+
 var cacheKeysTests = []struct {
 	proxy  string
 	scheme string
@@ -66,7 +97,7 @@ func TestCacheKeys(t *testing.T) {
 			}
 			proxy = u
 		}
-		cm := connectMethod{proxy, tt.scheme, tt.addr}
+		cm := connectMethod{proxyURL: proxy, targetScheme: tt.scheme, targetAddr: tt.addr}
 		if got := cm.key().String(); got != tt.key {
 			t.Fatalf("{%q, %q, %q} cache key = %q; want %q", tt.proxy, tt.scheme, tt.addr, got, tt.key)
 		}
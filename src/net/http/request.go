@@ -137,6 +137,14 @@ type Request struct {
 	// Handler does not need to.
 	Body io.ReadCloser
 
+	// GetBody defines an optional func to return a new copy of
+	// Body. It is used by code that needs to send the same request
+	// body more than once, such as retries of a cloned Request.
+	// NewRequest sets GetBody for the body types whose length it
+	// can infer.
+	// For server requests it is unused.
+	GetBody func() (io.ReadCloser, error)
+
 	// ContentLength records the length of the associated content.
 	// The value -1 indicates that the length is unknown.
 	// Values >= 0 indicate that the given number of bytes may
@@ -226,6 +234,48 @@ type Request struct {
 	// otherwise it leaves the field nil.
 	// This field is ignored by the HTTP client.
 	TLS *tls.ConnectionState
+
+	// Trace, if non-nil, is notified of events during a client
+	// request that are not otherwise visible in its Response. The
+	// Client sets it on the requests it makes when following
+	// redirects, too.
+	// This field is ignored by the HTTP server.
+	Trace *ClientTrace
+
+	// GzipBody, if true, makes a client request's Body be
+	// compressed with gzip as it is written, with a
+	// "Content-Encoding: gzip" header, for servers that accept
+	// compressed uploads. The compressed body is streamed with
+	// chunked transfer encoding, since its length isn't known in
+	// advance; ContentLength still gives the uncompressed length,
+	// if known. Header must not contain Content-Encoding.
+	// This field is ignored by the HTTP server.
+	GzipBody bool
+}
+
+// A ClientTrace is a set of hooks called by the Transport while it
+// sends a Request. Any hook may be nil.
+//
+// A ClientTrace may be shared by several requests, and its hooks may
+// be called from goroutines other than the one that sent the request.
+type ClientTrace struct {
+	// Got1xxResponse is called for each informational (1xx)
+	// response, other than 101 Switching Protocols, received
+	// before the final response. If it returns an error, the
+	// request fails with that error.
+	Got1xxResponse func(code int, header Header) error
+
+	// RequestBodyProgress is called as the request body is sent,
+	// with the number of body bytes sent so far and the total
+	// expected, or -1 if the length is unknown.
+	RequestBodyProgress func(sent, total int64)
+
+	// ResponseBodyProgress is called as the response body is
+	// read, with the number of body bytes read so far and the
+	// total expected, or -1 if the length is unknown. The counts
+	// are of the Response.Body as returned to the caller, so
+	// after any transparent gzip decoding.
+	ResponseBodyProgress func(read, total int64)
 }
 
 // ProtoAtLeast reports whether the HTTP protocol used
@@ -235,6 +285,57 @@ func (r *Request) ProtoAtLeast(major, minor int) bool {
 		r.ProtoMajor == major && r.ProtoMinor >= minor
 }
 
+// Clone returns a deep copy of r. The URL, Header, Trailer,
+// TransferEncoding, Form, PostForm and MultipartForm of the copy
+// may be modified without affecting r.
+//
+// The Body is not copied: the clone shares r's Body, which can only
+// be read once between the two. If r.GetBody is non-nil, callers
+// that need an independent body should set the clone's Body to a
+// fresh one obtained from GetBody.
+func (r *Request) Clone() *Request {
+	r2 := new(Request)
+	*r2 = *r
+	if r.URL != nil {
+		u2 := new(url.URL)
+		*u2 = *r.URL
+		r2.URL = u2
+	}
+	if r.Header != nil {
+		r2.Header = r.Header.clone()
+	}
+	if r.Trailer != nil {
+		r2.Trailer = r.Trailer.clone()
+	}
+	if r.TransferEncoding != nil {
+		r2.TransferEncoding = append([]string(nil), r.TransferEncoding...)
+	}
+	r2.Form = cloneValues(r.Form)
+	r2.PostForm = cloneValues(r.PostForm)
+	if f := r.MultipartForm; f != nil && f != multipartByReader {
+		f2 := &multipart.Form{
+			Value: map[string][]string(cloneValues(f.Value)),
+			File:  make(map[string][]*multipart.FileHeader, len(f.File)),
+		}
+		for k, fhs := range f.File {
+			f2.File[k] = append([]*multipart.FileHeader(nil), fhs...)
+		}
+		r2.MultipartForm = f2
+	}
+	return r2
+}
+
+func cloneValues(v url.Values) url.Values {
+	if v == nil {
+		return nil
+	}
+	v2 := make(url.Values, len(v))
+	for k, vv := range v {
+		v2[k] = append([]string(nil), vv...)
+	}
+	return v2
+}
+
 // UserAgent returns the client's User-Agent, if sent in the request.
 func (r *Request) UserAgent() string {
 	return r.Header.Get("User-Agent")
@@ -349,7 +450,7 @@ const defaultUserAgent = "Go 1.1 package http"
 // hasn't been set to "identity", Write adds "Transfer-Encoding:
 // chunked" to the header. Body is closed after it is sent.
 func (r *Request) Write(w io.Writer) error {
-	return r.write(w, false, nil)
+	return r.write(w, false, nil, nil)
 }
 
 // WriteProxy is like Write but writes the request in the form
@@ -359,11 +460,14 @@ func (r *Request) Write(w io.Writer) error {
 // In either case, WriteProxy also writes a Host header, using
 // either r.Host or r.URL.Host.
 func (r *Request) WriteProxy(w io.Writer) error {
-	return r.write(w, true, nil)
+	return r.write(w, true, nil, nil)
 }
 
-// extraHeaders may be nil
-func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header) error {
+// extraHeaders may be nil.
+// waitForContinue may be nil. If non-nil, it is called after the
+// header is written and flushed, and the body is only written if it
+// returns true.
+func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header, waitForContinue func() bool) error {
 	host := req.Host
 	if host == "" {
 		if req.URL == nil {
@@ -444,6 +548,18 @@ func (req *Request) write(w io.Writer, usingProxy bool, extraHeaders Header) err
 		return err
 	}
 
+	if waitForContinue != nil {
+		if bw, ok := w.(*bufio.Writer); ok {
+			if err = bw.Flush(); err != nil {
+				return err
+			}
+		}
+		if !waitForContinue() {
+			req.closeBody()
+			return nil
+		}
+	}
+
 	// Write body and trailer
 	err = tw.WriteBody(w)
 	if err != nil {
@@ -512,10 +628,24 @@ func NewRequest(method, urlStr string, body io.Reader) (*Request, error) {
 		switch v := body.(type) {
 		case *bytes.Buffer:
 			req.ContentLength = int64(v.Len())
+			buf := v.Bytes()
+			req.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(buf)), nil
+			}
 		case *bytes.Reader:
 			req.ContentLength = int64(v.Len())
+			snapshot := *v
+			req.GetBody = func() (io.ReadCloser, error) {
+				r := snapshot
+				return ioutil.NopCloser(&r), nil
+			}
 		case *strings.Reader:
 			req.ContentLength = int64(v.Len())
+			snapshot := *v
+			req.GetBody = func() (io.ReadCloser, error) {
+				r := snapshot
+				return ioutil.NopCloser(&r), nil
+			}
 		}
 	}
 
@@ -587,15 +717,32 @@ func putTextprotoReader(r *textproto.Reader) {
 	textprotoReaderPool.Put(r)
 }
 
+// errURITooLong is returned by readRequest for a request line or
+// request URI longer than permitted.
+var errURITooLong = errors.New("http: request URI too long")
+
 // ReadRequest reads and parses a request from b.
 func ReadRequest(b *bufio.Reader) (req *Request, err error) {
+	return readRequest(b, 0, 0)
+}
+
+// readRequest is like ReadRequest but, if maxLine or maxURI are
+// positive, returns errURITooLong for a request line or request URI
+// longer than that many bytes. An overlong request line is not read
+// past the limit.
+func readRequest(b *bufio.Reader, maxLine, maxURI int) (req *Request, err error) {
 
 	tp := newTextprotoReader(b)
 	req = new(Request)
 
 	// First line: GET /index.html HTTP/1.0
 	var s string
-	if s, err = tp.ReadLine(); err != nil {
+	if maxLine > 0 {
+		s, err = readLineLimit(b, maxLine)
+	} else {
+		s, err = tp.ReadLine()
+	}
+	if err != nil {
 		return nil, err
 	}
 	defer func() {
@@ -610,6 +757,9 @@ func ReadRequest(b *bufio.Reader) (req *Request, err error) {
 	if !ok {
 		return nil, &badStringError{"malformed HTTP request", s}
 	}
+	if maxURI > 0 && len(req.RequestURI) > maxURI {
+		return nil, errURITooLong
+	}
 	rawurl := req.RequestURI
 	if req.ProtoMajor, req.ProtoMinor, ok = ParseHTTPVersion(req.Proto); !ok {
 		return nil, &badStringError{"malformed HTTP version", req.Proto}
@@ -669,6 +819,38 @@ func ReadRequest(b *bufio.Reader) (req *Request, err error) {
 	return req, nil
 }
 
+// readLineLimit reads a line from b, without its trailing CRLF or
+// LF, and returns errURITooLong once it has read more than max bytes
+// without finding the end of the line.
+func readLineLimit(b *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		frag, err := b.ReadSlice('\n')
+		line = append(line, frag...)
+		n := len(line)
+		if err == nil {
+			n-- // the LF
+		}
+		if n > 0 && line[n-1] == '\r' {
+			n-- // the CR of a CRLF, which may end the previous fragment
+		}
+		if n > max {
+			return "", errURITooLong
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
+
 // MaxBytesReader is similar to io.LimitReader but is intended for
 // limiting the size of incoming request bodies. In contrast to
 // io.LimitReader, MaxBytesReader's result is a ReadCloser, returns a
@@ -678,13 +860,15 @@ func ReadRequest(b *bufio.Reader) (req *Request, err error) {
 // MaxBytesReader prevents clients from accidentally or maliciously
 // sending a large request and wasting server resources.
 func MaxBytesReader(w ResponseWriter, r io.ReadCloser, n int64) io.ReadCloser {
-	return &maxBytesReader{w: w, r: r, n: n}
+	return &maxBytesReader{w: w, r: r, n: n, max: n, setting: LimitMaxBytesReader}
 }
 
 type maxBytesReader struct {
 	w       ResponseWriter
 	r       io.ReadCloser // underlying reader
 	n       int64         // max bytes remaining
+	max     int64         // n as passed to MaxBytesReader
+	setting Limit         // where max came from, for BodyTooLargeError
 	stopped bool
 }
 
@@ -696,7 +880,7 @@ func (l *maxBytesReader) Read(p []byte) (n int, err error) {
 				res.requestTooLarge()
 			}
 		}
-		return 0, errors.New("http: request body too large")
+		return 0, &BodyTooLargeError{Limit: l.setting, Max: l.max}
 	}
 	if int64(len(p)) > l.n {
 		p = p[:l.n]
@@ -710,6 +894,26 @@ func (l *maxBytesReader) Close() error {
 	return l.r.Close()
 }
 
+// A BodyTooLargeError is returned by reads from a body past the size
+// limit set on it: a request body limited by MaxBytesReader or
+// Server.MaxRequestBodyBytes, or a response body limited by
+// Transport.MaxResponseBodyBytes.
+type BodyTooLargeError struct {
+	// Limit identifies the setting that was exceeded:
+	// LimitMaxBytesReader, LimitMaxRequestBodyBytes or
+	// LimitMaxResponseBodyBytes.
+	Limit Limit
+
+	Max int64 // the setting's value
+}
+
+func (e *BodyTooLargeError) Error() string {
+	if e.Limit == LimitMaxResponseBodyBytes {
+		return fmt.Sprintf("net/http: response body larger than %d bytes", e.Max)
+	}
+	return "http: request body too large"
+}
+
 func copyValues(dst, src url.Values) {
 	for k, vs := range src {
 		for _, value := range vs {
@@ -779,7 +983,7 @@ func parsePostForm(r *Request) (vs url.Values, err error) {
 func (r *Request) ParseForm() error {
 	var err error
 	if r.PostForm == nil {
-		if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
+		if r.Method == MethodPost || r.Method == MethodPut || r.Method == MethodPatch {
 			r.PostForm, err = parsePostForm(r)
 		}
 		if r.PostForm == nil {
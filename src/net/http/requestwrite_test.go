@@ -5,7 +5,9 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -621,3 +623,37 @@ func TestRequestWriteError(t *testing.T) {
 		t.Fatalf("writeCalls constant is outdated in test")
 	}
 }
+
+func TestRequestWriteGzipBody(t *testing.T) {
+	const body = "compress me compress me compress me"
+	req, _ := NewRequest("POST", "http://example.com/", strings.NewReader(body))
+	req.GzipBody = true
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadRequest(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ce := got.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Content-Encoding = %q; want gzip", ce)
+	}
+	if got.ContentLength != -1 || len(got.TransferEncoding) != 1 || got.TransferEncoding[0] != "chunked" {
+		t.Errorf("ContentLength, TransferEncoding = %d, %q; want -1, [chunked]", got.ContentLength, got.TransferEncoding)
+	}
+	zr, err := gzip.NewReader(got.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(zr); err != nil || string(b) != body {
+		t.Errorf("body = %q, %v; want %q", b, err, body)
+	}
+
+	req, _ = NewRequest("POST", "http://example.com/", strings.NewReader(body))
+	req.GzipBody = true
+	req.Header.Set("Content-Encoding", "deflate")
+	if err := req.Write(ioutil.Discard); err == nil {
+		t.Error("Write with GzipBody and Content-Encoding succeeded; want error")
+	}
+}
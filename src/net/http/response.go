@@ -12,10 +12,12 @@ import (
 	"crypto/tls"
 	"errors"
 	"io"
+	"net"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var respExcludeHeader = map[string]bool{
@@ -52,6 +54,13 @@ type Response struct {
 	//
 	// The Body is automatically dechunked if the server replied
 	// with a "chunked" Transfer-Encoding.
+	//
+	// For a successful (2xx) response to a CONNECT request sent
+	// with Transport, Body is an io.ReadWriteCloser: the tunnel to
+	// the host named by the request's Host, over a connection the
+	// Transport no longer uses. Likewise, for a "101 Switching
+	// Protocols" response, as used by WebSocket, Body is an
+	// io.ReadWriteCloser speaking the new protocol.
 	Body io.ReadCloser
 
 	// ContentLength records the length of the associated content.  The
@@ -69,6 +78,24 @@ type Response struct {
 	// ReadResponse nor Response.Write ever closes a connection.
 	Close bool
 
+	// Uncompressed reports whether the response was sent
+	// compressed and was transparently decompressed by the
+	// Transport. In that case the Content-Encoding and
+	// Content-Length headers are deleted from Header,
+	// ContentLength is -1, and EncodedContentLength and
+	// ContentEncoding record what was sent on the wire.
+	Uncompressed bool
+
+	// EncodedContentLength is the length of the compressed body
+	// sent by the server, or -1 if unknown. It is only set if
+	// Uncompressed is true.
+	EncodedContentLength int64
+
+	// ContentEncoding is the Content-Encoding header the server
+	// sent, such as "gzip". It is only set if Uncompressed is
+	// true.
+	ContentEncoding string
+
 	// Trailer maps trailer keys to values, in the same
 	// format as the header.
 	Trailer Header
@@ -78,11 +105,49 @@ type Response struct {
 	// This is only populated for Client requests.
 	Request *Request
 
+	// Redirects holds the redirect responses that the Client
+	// followed to obtain this Response, oldest first. The Request
+	// of each is the request that received it, including any
+	// cookies the Client's Jar added, and its Body has already
+	// been closed. It is nil if no redirect was followed.
+	// This is only populated for Client requests.
+	Redirects []*Response
+
 	// TLS contains information about the TLS connection on which the
 	// response was received. It is nil for unencrypted responses.
 	// The pointer is shared between responses and should not be
 	// modified.
 	TLS *tls.ConnectionState
+
+	// ConnInfo describes the connection that carried the response.
+	// It is set by Transport and nil for responses obtained
+	// otherwise.
+	ConnInfo *ConnInfo
+}
+
+// ConnInfo holds diagnostics about the connection a Transport used
+// for a request.
+type ConnInfo struct {
+	// Reused reports whether the connection had carried an
+	// earlier request, rather than being dialed for this one.
+	Reused bool
+
+	// RemoteAddr is the address of the server or proxy the
+	// connection is to.
+	RemoteAddr net.Addr
+
+	// DialDuration is how long it took to establish the
+	// connection, including any proxy CONNECT, and
+	// TLSHandshakeDuration how long its TLS handshake took. If
+	// Transport.DialTLS was used, the handshake is included in
+	// DialDuration. Both durations describe the connection's
+	// setup, so they are also reported when Reused is true.
+	DialDuration         time.Duration
+	TLSHandshakeDuration time.Duration
+
+	// FirstByteDelay is the time from starting to write the
+	// request until the first byte of the response arrived.
+	FirstByteDelay time.Duration
 }
 
 // Cookies parses and returns the cookies set in the Set-Cookie headers.
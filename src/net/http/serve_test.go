@@ -25,6 +25,7 @@ import (
 	"os/exec"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -361,6 +362,41 @@ func TestServeMuxHandler(t *testing.T) {
 	}
 }
 
+func TestServeMuxPatterns(t *testing.T) {
+	mux := NewServeMux()
+	for _, e := range serveMuxRegister {
+		mux.Handle(e.pattern, e.h)
+	}
+	var want []string
+	for _, e := range serveMuxRegister {
+		want = append(want, e.pattern)
+	}
+	sort.Strings(want)
+	if got := mux.Patterns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Patterns = %q; want %q", got, want)
+	}
+
+	for _, e := range serveMuxRegister {
+		h := mux.PatternHandler(e.pattern)
+		if h == nil {
+			t.Errorf("PatternHandler(%q) = nil", e.pattern)
+			continue
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, &Request{URL: &url.URL{Path: "/"}})
+		want := httptest.NewRecorder()
+		e.h.ServeHTTP(want, &Request{URL: &url.URL{Path: "/"}})
+		if rr.Code != want.Code {
+			t.Errorf("PatternHandler(%q) served %d; want %d", e.pattern, rr.Code, want.Code)
+		}
+	}
+	for _, pattern := range []string{"/dir", "/nope", ""} {
+		if h := mux.PatternHandler(pattern); h != nil {
+			t.Errorf("PatternHandler(%q) = %v; want nil", pattern, h)
+		}
+	}
+}
+
 var serveMuxTests2 = []struct {
 	method  string
 	host    string
@@ -517,6 +553,31 @@ func TestServerTimeouts(t *testing.T) {
 	}
 }
 
+func TestServerReadTimeoutFakeClock(t *testing.T) {
+	defer afterTest(t)
+	// Deadlines are computed from the clock, so one an hour behind
+	// real time makes an hour's ReadTimeout expire immediately.
+	clock := newFakeClock(time.Now().Add(-time.Hour))
+	defer clock.install()()
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		t.Error("handler ran for a client that never sent a request")
+	}))
+	ts.Config.ReadTimeout = time.Hour
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Errorf("Read = %v, %v; want 0, EOF", n, err)
+	}
+}
+
 // golang.org/issue/4741 -- setting only a write timeout that triggers
 // shouldn't cause a handler to block forever on reads (next HTTP
 // request) that will never happen.
@@ -1377,6 +1438,60 @@ func TestHandlerPanicNil(t *testing.T) {
 	testHandlerPanic(t, false, nil)
 }
 
+func TestWriteHeaderInvalidCode(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		code, _ := strconv.Atoi(r.FormValue("code"))
+		panicked := func() (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			w.WriteHeader(code)
+			return
+		}()
+		if panicked {
+			w.WriteHeader(StatusTeapot)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		code, want int
+	}{
+		{0, StatusTeapot},
+		{99, StatusTeapot},
+		{1000, StatusTeapot},
+		{StatusOK, StatusOK},
+		{StatusPermanentRedirect, StatusPermanentRedirect},
+		{StatusTooManyRequests, StatusTooManyRequests},
+		{599, 599},
+	}
+	for _, tt := range tests {
+		// Use the Transport directly so the Client doesn't try to
+		// follow the 308.
+		req, _ := NewRequest("GET", ts.URL+"/?code="+strconv.Itoa(tt.code), nil)
+		res, err := DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("WriteHeader(%d): %v", tt.code, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("WriteHeader(%d): got status %d; want %d", tt.code, res.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	for _, code := range []int{StatusTemporaryRedirect, StatusPermanentRedirect, StatusTeapot,
+		StatusUpgradeRequired, StatusPreconditionRequired, StatusTooManyRequests,
+		StatusRequestHeaderFieldsTooLarge, StatusNetworkAuthenticationRequired} {
+		if StatusText(code) == "" {
+			t.Errorf("StatusText(%d) is empty", code)
+		}
+	}
+	if s := StatusText(599); s != "" {
+		t.Errorf("StatusText(599) = %q; want empty", s)
+	}
+}
+
 func TestHandlerPanic(t *testing.T) {
 	testHandlerPanic(t, false, "intentional death for testing")
 }
@@ -1546,6 +1661,8 @@ func TestRequestBodyLimit(t *testing.T) {
 		n, err := io.Copy(ioutil.Discard, r.Body)
 		if err == nil {
 			t.Errorf("expected error from io.Copy")
+		} else if e, ok := err.(*BodyTooLargeError); !ok || e.Limit != LimitMaxBytesReader || e.Max != limit {
+			t.Errorf("io.Copy error = %#v; want *BodyTooLargeError for MaxBytesReader %d", err, limit)
 		}
 		if n != limit {
 			t.Errorf("io.Copy = %d, want %d", n, limit)
@@ -1573,6 +1690,242 @@ func TestRequestBodyLimit(t *testing.T) {
 	}
 }
 
+func TestServerMaxRequestBodyBytes(t *testing.T) {
+	defer afterTest(t)
+	var handlerCalls int32
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt32(&handlerCalls, 1)
+		slurp, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			if e, ok := err.(*BodyTooLargeError); !ok || e.Limit != LimitMaxRequestBodyBytes || e.Max != 5 {
+				t.Errorf("read error = %#v; want *BodyTooLargeError for MaxRequestBodyBytes 5", err)
+			}
+			Error(w, err.Error(), StatusBadRequest)
+			return
+		}
+		w.Write(slurp)
+	}))
+	ts.Config.MaxRequestBodyBytes = 5
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		body      io.Reader
+		wantCode  int
+		wantCalls int32
+	}{
+		{strings.NewReader("hello"), StatusOK, 1},
+		{strings.NewReader("hello, world"), StatusRequestEntityTooLarge, 1},
+		// Unknown length, so the handler sees the error.
+		{struct{ io.Reader }{strings.NewReader("hello, world")}, StatusBadRequest, 2},
+	}
+	for i, tt := range tests {
+		req, _ := NewRequest("POST", ts.URL, tt.body)
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d. Do: %v", i, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("%d. status = %d; want %d", i, res.StatusCode, tt.wantCode)
+		}
+		if n := atomic.LoadInt32(&handlerCalls); n != tt.wantCalls {
+			t.Errorf("%d. handler called %d times; want %d", i, n, tt.wantCalls)
+		}
+	}
+}
+
+func TestServerRequestLineLimits(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.MaxRequestLineBytes = 100
+	ts.Config.MaxRequestURIBytes = 20
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"/short", "HTTP/1.1 200 OK"},
+		{"/" + strings.Repeat("a", 19), "HTTP/1.1 200 OK"},
+		{"/" + strings.Repeat("a", 20), "HTTP/1.1 414 Request-URI Too Long"},
+		{"/" + strings.Repeat("a", 10000), "HTTP/1.1 414 Request-URI Too Long"},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: foo\r\nConnection: close\r\n\r\n", tt.uri)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil {
+			t.Errorf("URI of length %d: reading response: %v", len(tt.uri), err)
+			continue
+		}
+		if got := strings.TrimSpace(line); got != tt.want {
+			t.Errorf("URI of length %d: got %q; want %q", len(tt.uri), got, tt.want)
+		}
+	}
+}
+
+func TestServerMaxConcurrentRequests(t *testing.T) {
+	defer afterTest(t)
+	for _, wait := range []time.Duration{0, time.Minute} {
+		entered := make(chan bool)
+		release := make(chan bool)
+		ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+			if r.URL.Path == "/block" {
+				entered <- true
+				<-release
+			}
+		}))
+		ts.Config.MaxConcurrentRequests = 1
+		ts.Config.ConcurrencyWaitTimeout = wait
+		ts.Start()
+
+		get := func(path string) int {
+			res, err := Get(ts.URL + path)
+			if err != nil {
+				t.Errorf("Get %s: %v", path, err)
+				return 0
+			}
+			res.Body.Close()
+			return res.StatusCode
+		}
+		blocked := make(chan int)
+		go func() { blocked <- get("/block") }()
+		<-entered
+		if wait == 0 {
+			if code := get("/"); code != StatusServiceUnavailable {
+				t.Errorf("at the limit, got status %d; want %d", code, StatusServiceUnavailable)
+			}
+			release <- true
+		} else {
+			// The request is served once the blocked one finishes.
+			waiting := make(chan bool)
+			restore := SetHandlerSlotWaitHook(func() { waiting <- true })
+			waited := make(chan int)
+			go func() { waited <- get("/") }()
+			<-waiting
+			restore()
+			release <- true
+			if code := <-waited; code != StatusOK {
+				t.Errorf("waiting request got status %d; want %d", code, StatusOK)
+			}
+		}
+		if code := <-blocked; code != StatusOK {
+			t.Errorf("blocked request got status %d; want %d", code, StatusOK)
+		}
+		if code := get("/"); code != StatusOK {
+			t.Errorf("after release, got status %d; want %d", code, StatusOK)
+		}
+		ts.Close()
+	}
+}
+
+func TestServerMaxConcurrentRequestsPerClient(t *testing.T) {
+	defer afterTest(t)
+	entered := make(chan bool)
+	release := make(chan bool)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/block" {
+			entered <- true
+			<-release
+		}
+	}))
+	ts.Config.MaxConcurrentRequests = 1
+	ts.Start()
+	defer ts.Close()
+
+	get := func(local string, path string) int {
+		tr := &Transport{LocalAddr: &net.TCPAddr{IP: net.ParseIP(local)}}
+		defer tr.CloseIdleConnections()
+		res, err := (&Client{Transport: tr}).Get(ts.URL + path)
+		if err != nil {
+			t.Errorf("Get %s from %s: %v", path, local, err)
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if ln, err := net.Listen("tcp", "127.0.0.2:0"); err != nil {
+		t.Skipf("can't use a second loopback address: %v", err)
+	} else {
+		ln.Close()
+	}
+
+	blocked := make(chan int)
+	go func() { blocked <- get("127.0.0.1", "/block") }()
+	<-entered
+	if code := get("127.0.0.2", "/"); code != StatusOK {
+		t.Errorf("other client got status %d; want %d", code, StatusOK)
+	}
+	if code := get("127.0.0.1", "/"); code != StatusServiceUnavailable {
+		t.Errorf("client at its limit got status %d; want %d", code, StatusServiceUnavailable)
+	}
+	release <- true
+	if code := <-blocked; code != StatusOK {
+		t.Errorf("blocked request got status %d; want %d", code, StatusOK)
+	}
+}
+
+func TestServerMaxResponseBytesPerSecond(t *testing.T) {
+	defer afterTest(t)
+	const size = 30 << 10
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write(bytes.Repeat([]byte("x"), size))
+	}))
+	ts.Config.MaxResponseBytesPerSecond = 100 << 10
+	ts.Start()
+	defer ts.Close()
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+
+	for i := 0; i < 2; i++ {
+		done := make(chan error, 1)
+		go func() {
+			res, err := Get(ts.URL)
+			if err != nil {
+				done <- err
+				return
+			}
+			n, err := io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			if err == nil && n != size {
+				err = fmt.Errorf("read %d bytes; want %d", n, size)
+			}
+			done <- err
+		}()
+		// Move the clock on whenever the server waits. 30KB at
+		// 100KB/s, written in 10KB steps, takes at least 0.2
+		// seconds.
+		var elapsed time.Duration
+	wait:
+		for {
+			clock.mu.Lock()
+			waiting := len(clock.timers) > 0
+			clock.mu.Unlock()
+			if waiting {
+				clock.Advance(100 * time.Millisecond)
+				elapsed += 100 * time.Millisecond
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+				break wait
+			case <-time.After(time.Millisecond):
+			}
+		}
+		if elapsed < 200*time.Millisecond {
+			t.Errorf("request %d took %v; want at least 200ms", i, elapsed)
+		}
+	}
+}
+
 // TestClientWriteShutdown tests that if the client shuts down the write
 // side of their TCP connection, the server doesn't send a 400 Bad Request.
 func TestClientWriteShutdown(t *testing.T) {
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -72,6 +73,9 @@ type ResponseWriter interface {
 	// will trigger an implicit WriteHeader(http.StatusOK).
 	// Thus explicit calls to WriteHeader are mainly used to
 	// send error codes.
+	//
+	// The status code must be a valid three-digit HTTP status
+	// code; the server's ResponseWriter panics otherwise.
 	WriteHeader(int)
 }
 
@@ -121,6 +125,9 @@ type conn struct {
 	buf        *bufio.ReadWriter    // buffered(lr,rwc), reading from bufio->limitReader->sr->rwc
 	tlsState   *tls.ConnectionState // or nil when not using TLS
 
+	throttleStart time.Time // when the current response began, for MaxResponseBytesPerSecond
+	throttleN     int64     // bytes of the current response written since throttleStart
+
 	mu           sync.Mutex // guards the following
 	clientGone   bool       // if client has disconnected mid-request
 	closeNotifyc chan bool  // made lazily
@@ -573,17 +580,17 @@ func (c *conn) readRequest() (w *response, err error) {
 	}
 
 	if d := c.server.ReadTimeout; d != 0 {
-		c.rwc.SetReadDeadline(time.Now().Add(d))
+		c.rwc.SetReadDeadline(timeNow().Add(d))
 	}
 	if d := c.server.WriteTimeout; d != 0 {
 		defer func() {
-			c.rwc.SetWriteDeadline(time.Now().Add(d))
+			c.rwc.SetWriteDeadline(timeNow().Add(d))
 		}()
 	}
 
 	c.lr.N = c.server.initialLimitedReaderSize()
 	var req *Request
-	if req, err = ReadRequest(c.buf.Reader); err != nil {
+	if req, err = readRequest(c.buf.Reader, c.server.MaxRequestLineBytes, c.server.MaxRequestURIBytes); err != nil {
 		if c.lr.N == 0 {
 			return nil, errTooLarge
 		}
@@ -593,6 +600,7 @@ func (c *conn) readRequest() (w *response, err error) {
 
 	req.RemoteAddr = c.remoteAddr
 	req.TLS = c.tlsState
+	c.throttleStart, c.throttleN = time.Time{}, 0
 
 	w = &response{
 		conn:          c,
@@ -628,6 +636,7 @@ func (w *response) Header() Header {
 const maxPostHandlerReadBytes = 256 << 10
 
 func (w *response) WriteHeader(code int) {
+	checkWriteHeaderCode(code)
 	if w.conn.hijacked() {
 		w.conn.server.logf("http: response.WriteHeader on hijacked connection")
 		return
@@ -654,6 +663,14 @@ func (w *response) WriteHeader(code int) {
 	}
 }
 
+func checkWriteHeaderCode(code int) {
+	// The status line has room for exactly three digits, and
+	// RFC 7231 defines codes only from 100 up.
+	if code < 100 || code > 999 {
+		panic(fmt.Sprintf("http: invalid WriteHeader code %v", code))
+	}
+}
+
 // extraHeader is the set of headers sometimes added by chunkWriter.writeHeader.
 // This type is used to avoid extra allocations from cloning and/or populating
 // the response Header map and all its 1-element slices.
@@ -821,7 +838,7 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 	}
 
 	if _, ok := header["Date"]; !ok {
-		setHeader.date = appendTime(cw.res.dateBuf[:0], time.Now())
+		setHeader.date = appendTime(cw.res.dateBuf[:0], timeNow())
 	}
 
 	te := header.get("Transfer-Encoding")
@@ -1020,6 +1037,12 @@ func (w *response) finishRequest() {
 	w.cw.close()
 	w.conn.buf.Flush()
 
+	if w.requestBodyLimitHit {
+		// The connection is closed after this reply, so don't
+		// read any more of an oversized body than is buffered.
+		w.conn.lr.N = 0
+	}
+
 	// Close the body (regardless of w.closeAfterReply) so we can
 	// re-use its bufio.Reader later safely.
 	w.req.Body.Close()
@@ -1138,10 +1161,10 @@ func (c *conn) serve() {
 
 	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
 		if d := c.server.ReadTimeout; d != 0 {
-			c.rwc.SetReadDeadline(time.Now().Add(d))
+			c.rwc.SetReadDeadline(timeNow().Add(d))
 		}
 		if d := c.server.WriteTimeout; d != 0 {
-			c.rwc.SetWriteDeadline(time.Now().Add(d))
+			c.rwc.SetWriteDeadline(timeNow().Add(d))
 		}
 		if err := tlsConn.Handshake(); err != nil {
 			c.server.logf("http: TLS handshake error from %s: %v", c.rwc.RemoteAddr(), err)
@@ -1174,6 +1197,10 @@ func (c *conn) serve() {
 				io.WriteString(c.rwc, "HTTP/1.1 413 Request Entity Too Large\r\n\r\n")
 				c.closeWriteAndWait()
 				break
+			} else if err == errURITooLong {
+				io.WriteString(c.rwc, "HTTP/1.1 414 Request-URI Too Long\r\nConnection: close\r\n\r\n")
+				c.closeWriteAndWait()
+				break
 			} else if err == io.EOF {
 				break // Don't reply
 			} else if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
@@ -1183,8 +1210,19 @@ func (c *conn) serve() {
 			break
 		}
 
-		// Expect 100 Continue support
 		req := w.req
+		if max := c.server.MaxRequestBodyBytes; max > 0 {
+			if req.ContentLength > max {
+				// Reply before reading any of the body, or
+				// sending a 100 Continue for it.
+				io.WriteString(c.rwc, "HTTP/1.1 413 Request Entity Too Large\r\nConnection: close\r\n\r\n")
+				c.closeWriteAndWait()
+				break
+			}
+			req.Body = &maxBytesReader{w: w, r: req.Body, n: max, max: max, setting: LimitMaxRequestBodyBytes}
+		}
+
+		// Expect 100 Continue support
 		if req.expectsContinue() {
 			if req.ProtoAtLeast(1, 1) && req.ContentLength != 0 {
 				// Wrap the Body reader with one that replies on the connection
@@ -1541,6 +1579,34 @@ func (mux *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
 	h.ServeHTTP(w, r)
 }
 
+// Patterns returns the patterns registered with mux, in sorted
+// order. It does not include the implicit redirects added for
+// subtree patterns.
+func (mux *ServeMux) Patterns() []string {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	patterns := make([]string, 0, len(mux.m))
+	for k, e := range mux.m {
+		if e.explicit {
+			patterns = append(patterns, k)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// PatternHandler returns the handler registered for exactly the
+// given pattern, or nil if there is none. To find the handler and
+// pattern that a request would be dispatched to, use Handler.
+func (mux *ServeMux) PatternHandler(pattern string) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	if e := mux.m[pattern]; e.explicit {
+		return e.h
+	}
+	return nil
+}
+
 // Handle registers the handler for the given pattern.
 // If a handler already exists for pattern, Handle panics.
 func (mux *ServeMux) Handle(pattern string, handler Handler) {
@@ -1636,7 +1702,47 @@ type Server struct {
 	// standard logger.
 	ErrorLog *log.Logger
 
+	// MaxRequestBodyBytes, if positive, limits the size of all
+	// request bodies, independent of any MaxBytesReader used by
+	// handlers. A request whose Content-Length exceeds it is
+	// answered with 413 Request Entity Too Large without calling
+	// the Handler. Otherwise reads from the Request.Body past the
+	// limit return an error, and the connection is closed after
+	// the response without reading the rest of the body.
+	MaxRequestBodyBytes int64
+
+	// MaxRequestLineBytes and MaxRequestURIBytes, if positive,
+	// limit the length of the request line (such as
+	// "GET /index.html HTTP/1.1") and of the request URI within
+	// it. Requests exceeding either are answered with 414
+	// Request-URI Too Long. The request line is not read past
+	// MaxRequestLineBytes. Both are also bounded by MaxHeaderBytes.
+	MaxRequestLineBytes int
+	MaxRequestURIBytes  int
+
+	// MaxConcurrentRequests, if positive, limits the number of
+	// requests from each client, identified by its IP address,
+	// that the Handler serves at once, so that one greedy client
+	// cannot take the Handler from the others. A request arriving
+	// at its client's limit waits up to ConcurrencyWaitTimeout for
+	// another of the client's requests to finish, and is otherwise
+	// answered with 503 Service Unavailable without calling the
+	// Handler. The limit applies to each client IP address
+	// separately; it does not bound the server's total.
+	MaxConcurrentRequests  int
+	ConcurrencyWaitTimeout time.Duration
+
+	// MaxResponseBytesPerSecond, if positive, limits the rate at
+	// which each response, including its header, is written to
+	// the client. Slowed responses still count against
+	// WriteTimeout.
+	MaxResponseBytesPerSecond int64
+
 	disableKeepAlives int32 // accessed atomically.
+
+	inFlightMu   sync.Mutex
+	inFlight     map[string]int // client IP -> requests being handled
+	inFlightDone chan struct{}  // closed when a request finishes, if non-nil
 }
 
 // A ConnState represents the state of a client connection to a server.
@@ -1693,6 +1799,16 @@ type serverHandler struct {
 }
 
 func (sh serverHandler) ServeHTTP(rw ResponseWriter, req *Request) {
+	client := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	release, ok := sh.srv.acquireHandler(client)
+	if !ok {
+		Error(rw, "503 Service Unavailable: too many concurrent requests", StatusServiceUnavailable)
+		return
+	}
+	defer release()
 	handler := sh.srv.Handler
 	if handler == nil {
 		handler = DefaultServeMux
@@ -1703,6 +1819,67 @@ func (sh serverHandler) ServeHTTP(rw ResponseWriter, req *Request) {
 	handler.ServeHTTP(rw, req)
 }
 
+// handlerSlotWait, if non-nil, is called when a request starts
+// waiting for a MaxConcurrentRequests slot. Tests set it.
+var handlerSlotWait func()
+
+// acquireHandler reserves one of client's MaxConcurrentRequests slots
+// for a request, waiting up to ConcurrencyWaitTimeout for one to
+// become free. It reports whether it got one, in which case release
+// must be called when the request is done.
+func (srv *Server) acquireHandler(client string) (release func(), ok bool) {
+	if srv.MaxConcurrentRequests <= 0 {
+		return func() {}, true
+	}
+	var timeout <-chan time.Time
+	for {
+		srv.inFlightMu.Lock()
+		if srv.inFlight[client] < srv.MaxConcurrentRequests {
+			if srv.inFlight == nil {
+				srv.inFlight = make(map[string]int)
+			}
+			srv.inFlight[client]++
+			srv.inFlightMu.Unlock()
+			return func() { srv.releaseHandler(client) }, true
+		}
+		if srv.ConcurrencyWaitTimeout <= 0 {
+			srv.inFlightMu.Unlock()
+			return nil, false
+		}
+		if srv.inFlightDone == nil {
+			srv.inFlightDone = make(chan struct{})
+		}
+		done := srv.inFlightDone
+		srv.inFlightMu.Unlock()
+		if timeout == nil {
+			t := time.NewTimer(srv.ConcurrencyWaitTimeout)
+			defer t.Stop()
+			timeout = t.C
+			if handlerSlotWait != nil {
+				handlerSlotWait()
+			}
+		}
+		select {
+		case <-done:
+		case <-timeout:
+			return nil, false
+		}
+	}
+}
+
+// releaseHandler frees the slot a request from client held.
+func (srv *Server) releaseHandler(client string) {
+	srv.inFlightMu.Lock()
+	defer srv.inFlightMu.Unlock()
+	if srv.inFlight[client]--; srv.inFlight[client] <= 0 {
+		delete(srv.inFlight, client)
+	}
+	if srv.inFlightDone != nil {
+		close(srv.inFlightDone)
+		srv.inFlightDone = nil
+	}
+}
+
 // ListenAndServe listens on the TCP network address srv.Addr and then
 // calls Serve to handle requests on incoming connections.  If
 // srv.Addr is blank, ":http" is used.
@@ -1889,7 +2066,7 @@ func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
 // ErrHandlerTimeout.
 func TimeoutHandler(h Handler, dt time.Duration, msg string) Handler {
 	f := func() <-chan time.Time {
-		return time.After(dt)
+		return timeAfter(dt)
 	}
 	return &timeoutHandler{h, f, msg}
 }
@@ -2088,9 +2265,45 @@ type checkConnErrorWriter struct {
 }
 
 func (w checkConnErrorWriter) Write(p []byte) (n int, err error) {
-	n, err = w.c.w.Write(p) // c.w == c.rwc, except after a hijack, when rwc is nil.
+	if rate := w.c.server.MaxResponseBytesPerSecond; rate > 0 {
+		n, err = w.c.writeThrottled(p, rate)
+	} else {
+		n, err = w.c.w.Write(p) // c.w == c.rwc, except after a hijack, when rwc is nil.
+	}
 	if err != nil && w.c.werr == nil {
 		w.c.werr = err
 	}
 	return
 }
+
+// writeThrottled writes p to c.w, pausing as needed to keep the
+// average rate of the current response within rate bytes per second.
+func (c *conn) writeThrottled(p []byte, rate int64) (n int, err error) {
+	// Write a tenth of a second's worth at a time, so that the
+	// output is smooth rather than bursty.
+	step := int(rate / 10)
+	if step < 1 {
+		step = 1
+	}
+	if c.throttleStart.IsZero() {
+		c.throttleStart = timeNow()
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > step {
+			chunk = chunk[:step]
+		}
+		due := c.throttleStart.Add(time.Duration(float64(c.throttleN) / float64(rate) * float64(time.Second)))
+		if d := due.Sub(timeNow()); d > 0 {
+			<-timeAfter(d)
+		}
+		m, err := c.w.Write(chunk)
+		n += m
+		c.throttleN += int64(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
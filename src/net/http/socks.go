@@ -0,0 +1,221 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// SOCKS proxy clients: SOCKS5, RFC 1928 and RFC 1929, and SOCKS4a.
+
+package http
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const (
+	socks5Version = 5
+
+	socks5AuthNone     = 0
+	socks5AuthPassword = 2
+
+	socks5Connect = 1
+
+	socks5IP4    = 1
+	socks5Domain = 3
+	socks5IP6    = 4
+
+	socks4Version = 4
+	socks4Connect = 1
+	socks4Granted = 90
+)
+
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Handshake asks the SOCKS5 proxy at the other end of conn,
+// whose URL is proxy, to connect to addr ("host:port"). Host names
+// are sent to the proxy unresolved, so DNS resolution happens on its
+// side. If proxy has user information, it is offered for
+// username/password authentication.
+func socks5Handshake(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return errors.New("net/http: invalid port in SOCKS5 target " + addr)
+	}
+
+	buf := []byte{socks5Version, 1, socks5AuthNone}
+	if proxy.User != nil {
+		buf = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return errors.New("net/http: unexpected SOCKS version " + strconv.Itoa(int(buf[0])))
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if proxy.User == nil {
+			return errors.New("net/http: SOCKS5 proxy requires authentication")
+		}
+		user := proxy.User.Username()
+		pass, _ := proxy.User.Password()
+		if len(user) == 0 || len(user) > 255 || len(pass) > 255 {
+			return errors.New("net/http: invalid SOCKS5 username or password")
+		}
+		buf = append([]byte{1, byte(len(user))}, user...)
+		buf = append(buf, byte(len(pass)))
+		buf = append(buf, pass...)
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return errors.New("net/http: SOCKS5 proxy rejected username and password")
+		}
+	default:
+		return errors.New("net/http: no acceptable SOCKS5 authentication method")
+	}
+
+	buf = []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(buf, socks5IP4)
+			buf = append(buf, ip4...)
+		} else {
+			buf = append(buf, socks5IP6)
+			buf = append(buf, ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("net/http: SOCKS5 target host name too long: " + host)
+		}
+		buf = append(buf, socks5Domain, byte(len(host)))
+		buf = append(buf, host...)
+	}
+	buf = append(buf, byte(port>>8), byte(port))
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// The reply echoes the bound address, whose length depends on
+	// its type.
+	buf = make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if rep := int(buf[1]); rep != 0 {
+		msg := "unknown error " + strconv.Itoa(rep)
+		if rep < len(socks5Errors) {
+			msg = socks5Errors[rep]
+		}
+		return errors.New("net/http: SOCKS5 proxy failed to connect to " + addr + ": " + msg)
+	}
+	var n int
+	switch buf[3] {
+	case socks5IP4:
+		n = net.IPv4len
+	case socks5IP6:
+		n = net.IPv6len
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return errors.New("net/http: unknown address type in SOCKS5 reply")
+	}
+	_, err = io.CopyN(ioutil.Discard, conn, int64(n+2)) // address and port
+	return err
+}
+
+var socks4Errors = map[byte]string{
+	91: "request rejected or failed",
+	92: "proxy can't reach the client's identd",
+	93: "identd reported a different user ID",
+}
+
+// isSOCKS reports whether a proxy URL scheme names a SOCKS proxy.
+func isSOCKS(scheme string) bool {
+	return scheme == "socks5" || scheme == "socks4"
+}
+
+// socks4Handshake asks the SOCKS4 proxy at the other end of conn,
+// whose URL is proxy, to connect to addr ("host:port"). Host names
+// are sent unresolved, using the SOCKS4a extension. If proxy has
+// user information, its user name is sent as the user ID.
+func socks4Handshake(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return errors.New("net/http: invalid port in SOCKS4 target " + addr)
+	}
+
+	buf := []byte{socks4Version, socks4Connect, byte(port >> 8), byte(port)}
+	ip := net.ParseIP(host)
+	if ip != nil {
+		ip = ip.To4()
+		if ip == nil {
+			return errors.New("net/http: SOCKS4 can't connect to IPv6 address " + host)
+		}
+		buf = append(buf, ip...)
+	} else {
+		// An address of 0.0.0.x, x non-zero, says that
+		// the host name follows the user ID.
+		buf = append(buf, 0, 0, 0, 1)
+	}
+	if proxy.User != nil {
+		buf = append(buf, proxy.User.Username()...)
+	}
+	buf = append(buf, 0)
+	if ip == nil {
+		buf = append(buf, host...)
+		buf = append(buf, 0)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// The reply is a null version, a status code, and a port and
+	// address that are only meaningful for BIND.
+	buf = buf[:8]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != 0 {
+		return errors.New("net/http: unexpected SOCKS4 reply version " + strconv.Itoa(int(buf[0])))
+	}
+	if buf[1] != socks4Granted {
+		msg, ok := socks4Errors[buf[1]]
+		if !ok {
+			msg = "unknown error " + strconv.Itoa(int(buf[1]))
+		}
+		return errors.New("net/http: SOCKS4 proxy failed to connect to " + addr + ": " + msg)
+	}
+	return nil
+}
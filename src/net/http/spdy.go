@@ -0,0 +1,1143 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// SPDY/3.1 client, for Transport.EnableSPDY.
+// See http://www.chromium.org/spdy/spdy-protocol/spdy-protocol-draft3-1
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// spdyProto is the TLS NPN/ALPN name of the SPDY version spoken.
+const spdyProto = "spdy/3.1"
+
+const (
+	spdyVersion = 3
+
+	// Control frame types.
+	spdyTypeSynStream    = 1
+	spdyTypeSynReply     = 2
+	spdyTypeRstStream    = 3
+	spdyTypeSettings     = 4
+	spdyTypePing         = 6
+	spdyTypeGoAway       = 7
+	spdyTypeHeaders      = 8
+	spdyTypeWindowUpdate = 9
+
+	spdyFlagFin            = 0x01
+	spdyFlagUnidirectional = 0x02
+
+	// SETTINGS IDs.
+	spdySettingsMaxConcurrentStreams = 4
+	spdySettingsInitialWindowSize    = 7
+
+	// RST_STREAM status codes.
+	spdyProtocolError    = 1
+	spdyInvalidStream    = 2
+	spdyRefusedStream    = 3
+	spdyCancel           = 5
+	spdyFlowControlError = 7
+
+	// spdyInitialWindow is the initial flow control window of
+	// streams and connections. Neither end of the connections
+	// made here advertises a larger one, so it is also the
+	// longest DATA frame accepted.
+	spdyInitialWindow = 64 << 10
+
+	spdyMaxDataLen    = 16 << 10 // longest DATA frame sent
+	spdyMaxControlLen = 4 << 10  // longest control frame read, other than those with header blocks
+	spdyMaxStreamID   = 1<<31 - 1
+)
+
+// spdyDictionary primes the zlib compression of header blocks.
+const spdyDictionary = "\x00\x00\x00\x07options" + "\x00\x00\x00\x04head" +
+	"\x00\x00\x00\x04post" + "\x00\x00\x00\x03put" +
+	"\x00\x00\x00\x06delete" + "\x00\x00\x00\x05trace" +
+	"\x00\x00\x00\x06accept" + "\x00\x00\x00\x0eaccept-charset" +
+	"\x00\x00\x00\x0faccept-encoding" +
+	"\x00\x00\x00\x0faccept-language" +
+	"\x00\x00\x00\x0daccept-ranges" + "\x00\x00\x00\x03age" +
+	"\x00\x00\x00\x05allow" + "\x00\x00\x00\x0dauthorization" +
+	"\x00\x00\x00\x0dcache-control" + "\x00\x00\x00\x0aconnection" +
+	"\x00\x00\x00\x0ccontent-base" +
+	"\x00\x00\x00\x10content-encoding" +
+	"\x00\x00\x00\x10content-language" +
+	"\x00\x00\x00\x0econtent-length" +
+	"\x00\x00\x00\x10content-location" +
+	"\x00\x00\x00\x0bcontent-md5" + "\x00\x00\x00\x0dcontent-range" +
+	"\x00\x00\x00\x0ccontent-type" + "\x00\x00\x00\x04date" +
+	"\x00\x00\x00\x04etag" + "\x00\x00\x00\x06expect" +
+	"\x00\x00\x00\x07expires" + "\x00\x00\x00\x04from" +
+	"\x00\x00\x00\x04host" + "\x00\x00\x00\x08if-match" +
+	"\x00\x00\x00\x11if-modified-since" +
+	"\x00\x00\x00\x0dif-none-match" + "\x00\x00\x00\x08if-range" +
+	"\x00\x00\x00\x13if-unmodified-since" +
+	"\x00\x00\x00\x0dlast-modified" + "\x00\x00\x00\x08location" +
+	"\x00\x00\x00\x0cmax-forwards" + "\x00\x00\x00\x06pragma" +
+	"\x00\x00\x00\x12proxy-authenticate" +
+	"\x00\x00\x00\x13proxy-authorization" +
+	"\x00\x00\x00\x05range" + "\x00\x00\x00\x07referer" +
+	"\x00\x00\x00\x0bretry-after" + "\x00\x00\x00\x06server" +
+	"\x00\x00\x00\x02te" + "\x00\x00\x00\x07trailer" +
+	"\x00\x00\x00\x11transfer-encoding" +
+	"\x00\x00\x00\x07upgrade" + "\x00\x00\x00\x0auser-agent" +
+	"\x00\x00\x00\x04vary" + "\x00\x00\x00\x03via" +
+	"\x00\x00\x00\x07warning" +
+	"\x00\x00\x00\x10www-authenticate" +
+	"\x00\x00\x00\x06method" + "\x00\x00\x00\x03get" +
+	"\x00\x00\x00\x06status" + "\x00\x00\x00\x06200 OK" +
+	"\x00\x00\x00\x07version" + "\x00\x00\x00\x08HTTP/1.1" +
+	"\x00\x00\x00\x03url" + "\x00\x00\x00\x06public" +
+	"\x00\x00\x00\x0aset-cookie" + "\x00\x00\x00\x0akeep-alive" +
+	"\x00\x00\x00\x06origin" +
+	"100101201202205206300302303304305306307402405406407408409410411412413414415416417502504505" +
+	"203 Non-Authoritative Information" +
+	"204 No Content" +
+	"301 Moved Permanently" +
+	"400 Bad Request" +
+	"401 Unauthorized" +
+	"403 Forbidden" +
+	"404 Not Found" +
+	"500 Internal Server Error" +
+	"501 Not Implemented" +
+	"503 Service Unavailable" +
+	"Jan Feb Mar Apr May Jun Jul Aug Sept Oct Nov Dec 00:00:00 Mon, Tue, Wed, Thu, Fri, Sat, Sun, GMT" +
+	"chunked,text/html,image/png,image/jpg,image/gif,application/xml,application/xhtml+xml,text/plain,text/javascript," +
+	"publicprivatemax-age=gzip,deflate,sdchcharset=utf-8charset=iso-8859-1,utf-,*,enq=0."
+
+// SPDY frames. Header blocks map lower-case names to values; the
+// values of a name are sent joined by NULs.
+type (
+	spdyData struct {
+		streamID uint32
+		flags    uint8
+		data     []byte
+	}
+
+	spdySynStream struct {
+		streamID, assocID uint32
+		priority          uint8 // 0 (highest) to 7
+		flags             uint8
+		header            Header
+	}
+
+	spdySynReply struct {
+		streamID uint32
+		flags    uint8
+		header   Header
+	}
+
+	spdyHeaders struct {
+		streamID uint32
+		flags    uint8
+		header   Header
+	}
+
+	spdyRstStream struct {
+		streamID, status uint32
+	}
+
+	spdySettings struct {
+		values map[uint32]uint32 // by SETTINGS ID
+	}
+
+	spdyPing struct {
+		id uint32
+	}
+
+	spdyGoAway struct {
+		lastStreamID, status uint32
+	}
+
+	spdyWindowUpdate struct {
+		streamID, delta uint32
+	}
+)
+
+// A spdyFramer reads and writes the frames of one SPDY connection.
+// Header blocks are compressed with a zlib stream per direction, so
+// all frames of a connection must go through the same spdyFramer.
+type spdyFramer struct {
+	r *bufio.Reader
+	w *bufio.Writer
+
+	// maxHeaderBytes limits the uncompressed size of a header
+	// block read.
+	maxHeaderBytes int64
+
+	hbuf bytes.Buffer // compressed header block being written
+	hzw  *zlib.Writer // lazily initialized
+
+	hlr io.LimitedReader // the header block being read
+	hzr io.ReadCloser    // lazily initialized
+}
+
+func newSPDYFramer(rw io.ReadWriter) *spdyFramer {
+	return &spdyFramer{
+		r:              bufio.NewReader(rw),
+		w:              bufio.NewWriter(rw),
+		maxHeaderBytes: DefaultMaxResponseHeaderBytes,
+	}
+}
+
+func spdyFrameError(typ uint32) error {
+	return fmt.Errorf("net/http: malformed SPDY control frame of type %d", typ)
+}
+
+// readFrame reads the next frame, skipping control frames of unknown
+// types.
+func (f *spdyFramer) readFrame() (interface{}, error) {
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(f.r, h[:]); err != nil {
+			return nil, err
+		}
+		first := binary.BigEndian.Uint32(h[0:])
+		flags := h[4]
+		n := int(binary.BigEndian.Uint32(h[4:]) & 0xffffff)
+		if first&0x80000000 == 0 {
+			if n > spdyInitialWindow {
+				return nil, errors.New("net/http: SPDY DATA frame exceeds the flow control window")
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(f.r, data); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			return &spdyData{streamID: first, flags: flags, data: data}, nil
+		}
+		if v := first >> 16 & 0x7fff; v != spdyVersion {
+			return nil, fmt.Errorf("net/http: unsupported SPDY version %d", v)
+		}
+		typ := first & 0xffff
+		switch typ {
+		case spdyTypeSynStream, spdyTypeSynReply, spdyTypeHeaders:
+			return f.readHeaderFrame(typ, flags, n)
+		case spdyTypeRstStream, spdyTypeSettings, spdyTypePing, spdyTypeGoAway, spdyTypeWindowUpdate:
+		default:
+			if _, err := io.CopyN(ioutil.Discard, f.r, int64(n)); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			continue
+		}
+		if n > spdyMaxControlLen {
+			return nil, spdyFrameError(typ)
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(f.r, p); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		switch typ {
+		case spdyTypeRstStream:
+			if n != 8 {
+				return nil, spdyFrameError(typ)
+			}
+			return &spdyRstStream{streamID: binary.BigEndian.Uint32(p) & spdyMaxStreamID, status: binary.BigEndian.Uint32(p[4:])}, nil
+		case spdyTypeSettings:
+			if n < 4 || uint32(n-4) != 8*binary.BigEndian.Uint32(p) {
+				return nil, spdyFrameError(typ)
+			}
+			fr := &spdySettings{values: make(map[uint32]uint32)}
+			for p = p[4:]; len(p) > 0; p = p[8:] {
+				fr.values[binary.BigEndian.Uint32(p)&0xffffff] = binary.BigEndian.Uint32(p[4:])
+			}
+			return fr, nil
+		case spdyTypePing:
+			if n != 4 {
+				return nil, spdyFrameError(typ)
+			}
+			return &spdyPing{id: binary.BigEndian.Uint32(p)}, nil
+		case spdyTypeGoAway:
+			if n != 8 {
+				return nil, spdyFrameError(typ)
+			}
+			return &spdyGoAway{lastStreamID: binary.BigEndian.Uint32(p) & spdyMaxStreamID, status: binary.BigEndian.Uint32(p[4:])}, nil
+		default: // spdyTypeWindowUpdate
+			if n != 8 {
+				return nil, spdyFrameError(typ)
+			}
+			return &spdyWindowUpdate{streamID: binary.BigEndian.Uint32(p) & spdyMaxStreamID, delta: binary.BigEndian.Uint32(p[4:]) & spdyMaxStreamID}, nil
+		}
+	}
+}
+
+// readHeaderFrame reads the rest of a SYN_STREAM, SYN_REPLY or
+// HEADERS frame of length n.
+func (f *spdyFramer) readHeaderFrame(typ uint32, flags uint8, n int) (interface{}, error) {
+	fixed := 4 // stream ID
+	if typ == spdyTypeSynStream {
+		fixed = 10 // and associated stream ID, priority and slot
+	}
+	if n < fixed {
+		return nil, spdyFrameError(typ)
+	}
+	var p [10]byte
+	if _, err := io.ReadFull(f.r, p[:fixed]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	id := binary.BigEndian.Uint32(p[:]) & spdyMaxStreamID
+	h, err := f.readHeaderBlock(int64(n - fixed))
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case spdyTypeSynStream:
+		return &spdySynStream{
+			streamID: id,
+			assocID:  binary.BigEndian.Uint32(p[4:]) & spdyMaxStreamID,
+			priority: p[8] >> 5,
+			flags:    flags,
+			header:   h,
+		}, nil
+	case spdyTypeSynReply:
+		return &spdySynReply{streamID: id, flags: flags, header: h}, nil
+	}
+	return &spdyHeaders{streamID: id, flags: flags, header: h}, nil
+}
+
+// readHeaderBlock reads a compressed header block of n bytes.
+func (f *spdyFramer) readHeaderBlock(n int64) (Header, error) {
+	f.hlr.R = f.r
+	f.hlr.N = n
+	if f.hzr == nil {
+		zr, err := zlib.NewReaderDict(&f.hlr, []byte(spdyDictionary))
+		if err != nil {
+			return nil, err
+		}
+		f.hzr = zr
+	}
+	left := f.maxHeaderBytes
+	var b [4]byte
+	readLen := func() (int, error) {
+		if _, err := io.ReadFull(f.hzr, b[:]); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if left -= 4; left < 0 {
+			return 0, &HeaderTooLargeError{Max: f.maxHeaderBytes}
+		}
+		n := binary.BigEndian.Uint32(b[:])
+		if int64(n) > left {
+			return 0, &HeaderTooLargeError{Max: f.maxHeaderBytes}
+		}
+		left -= int64(n)
+		return int(n), nil
+	}
+	readString := func() (string, error) {
+		n, err := readLen()
+		if err != nil {
+			return "", err
+		}
+		s := make([]byte, n)
+		if _, err := io.ReadFull(f.hzr, s); err != nil {
+			return "", unexpectedEOF(err)
+		}
+		return string(s), nil
+	}
+	count, err := readLen()
+	if err != nil {
+		return nil, err
+	}
+	h := make(Header)
+	for i := 0; i < count; i++ {
+		name, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		if name == "" || name != strings.ToLower(name) {
+			return nil, fmt.Errorf("net/http: invalid SPDY header name %q", name)
+		}
+		h[name] = append(h[name], strings.Split(value, "\x00")...)
+	}
+	if f.hlr.N != 0 {
+		return nil, errors.New("net/http: SPDY header block longer than its headers")
+	}
+	return h, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendHeaderBlock appends h, compressed, to b.
+func (f *spdyFramer) appendHeaderBlock(b []byte, h Header) ([]byte, error) {
+	if f.hzw == nil {
+		zw, err := zlib.NewWriterLevelDict(&f.hbuf, zlib.DefaultCompression, []byte(spdyDictionary))
+		if err != nil {
+			return nil, err
+		}
+		f.hzw = zw
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p := appendUint32(nil, uint32(len(names)))
+	for _, name := range names {
+		value := strings.Join(h[name], "\x00")
+		p = appendUint32(p, uint32(len(name)))
+		p = append(p, name...)
+		p = appendUint32(p, uint32(len(value)))
+		p = append(p, value...)
+	}
+	if _, err := f.hzw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := f.hzw.Flush(); err != nil {
+		return nil, err
+	}
+	b = append(b, f.hbuf.Bytes()...)
+	f.hbuf.Reset()
+	return b, nil
+}
+
+// writeFrame writes fr, one of the spdy frame types, and flushes it.
+func (f *spdyFramer) writeFrame(fr interface{}) error {
+	var (
+		typ   uint32
+		flags uint8
+		p     []byte
+		err   error
+	)
+	switch fr := fr.(type) {
+	case *spdyData:
+		var h [8]byte
+		binary.BigEndian.PutUint32(h[0:], fr.streamID&spdyMaxStreamID)
+		binary.BigEndian.PutUint32(h[4:], uint32(fr.flags)<<24|uint32(len(fr.data)))
+		f.w.Write(h[:])
+		f.w.Write(fr.data)
+		return f.w.Flush()
+	case *spdySynStream:
+		typ, flags = spdyTypeSynStream, fr.flags
+		p = appendUint32(p, fr.streamID)
+		p = appendUint32(p, fr.assocID)
+		p = append(p, fr.priority<<5, 0)
+		p, err = f.appendHeaderBlock(p, fr.header)
+	case *spdySynReply:
+		typ, flags = spdyTypeSynReply, fr.flags
+		p, err = f.appendHeaderBlock(appendUint32(p, fr.streamID), fr.header)
+	case *spdyHeaders:
+		typ, flags = spdyTypeHeaders, fr.flags
+		p, err = f.appendHeaderBlock(appendUint32(p, fr.streamID), fr.header)
+	case *spdyRstStream:
+		typ = spdyTypeRstStream
+		p = appendUint32(appendUint32(p, fr.streamID), fr.status)
+	case *spdySettings:
+		typ = spdyTypeSettings
+		ids := make([]int, 0, len(fr.values))
+		for id := range fr.values {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		p = appendUint32(p, uint32(len(ids)))
+		for _, id := range ids {
+			p = appendUint32(p, uint32(id)&0xffffff)
+			p = appendUint32(p, fr.values[uint32(id)])
+		}
+	case *spdyPing:
+		typ = spdyTypePing
+		p = appendUint32(p, fr.id)
+	case *spdyGoAway:
+		typ = spdyTypeGoAway
+		p = appendUint32(appendUint32(p, fr.lastStreamID), fr.status)
+	case *spdyWindowUpdate:
+		typ = spdyTypeWindowUpdate
+		p = appendUint32(appendUint32(p, fr.streamID), fr.delta)
+	default:
+		return fmt.Errorf("net/http: can't write SPDY frame of type %T", fr)
+	}
+	if err != nil {
+		return err
+	}
+	if len(p) > 0xffffff {
+		return errors.New("net/http: SPDY frame too large")
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint32(h[0:], 0x80000000|spdyVersion<<16|typ)
+	binary.BigEndian.PutUint32(h[4:], uint32(flags)<<24|uint32(len(p)))
+	f.w.Write(h[:])
+	f.w.Write(p)
+	return f.w.Flush()
+}
+
+var (
+	// errSPDYConnUnusable is returned by a spdyConn's RoundTrip
+	// when the conn can't take new requests. The request wasn't
+	// sent, so it may be sent on another connection.
+	errSPDYConnUnusable = errors.New("net/http: SPDY connection closed before the request was sent")
+
+	// errSPDYNotProcessed is returned by a spdyConn's RoundTrip
+	// when the server refused the request's stream or went away
+	// without processing it.
+	errSPDYNotProcessed = errors.New("net/http: SPDY server didn't process the request")
+)
+
+// spdyRetryable reports whether req, whose RoundTrip on a spdyConn
+// failed with err, may be sent again on a new connection: it wasn't
+// sent, or the server didn't process it and it has no body that was
+// used up.
+func spdyRetryable(req *Request, err error) bool {
+	return err == errSPDYConnUnusable || err == errSPDYNotProcessed && req.Body == nil
+}
+
+// A spdyConn is a SPDY/3.1 client connection. It sends the requests
+// given to its RoundTrip concurrently, each on a stream of its own.
+type spdyConn struct {
+	t        *Transport
+	conn     net.Conn
+	tlsState *tls.ConnectionState
+
+	wmu sync.Mutex  // guards writing to fr, and makes stream IDs increase on the wire
+	fr  *spdyFramer // read only by readLoop
+
+	mu            sync.Mutex
+	cond          *sync.Cond // broadcast on any change to the conn or its streams
+	streams       map[uint32]*spdyStream
+	nextID        uint32
+	maxStreams    int   // concurrent streams the server allows; 0 means no limit
+	reserved      int   // stream slots taken by streams not yet given an ID
+	initialWindow int32 // initial send window of streams
+	sendWindow    int32 // connection send window
+	recvWindow    int32 // bytes the server may still send
+	unacked       int32 // bytes consumed but not yet returned to recvWindow
+	goAway        bool  // the server sent a GOAWAY
+	closing       bool  // close once no streams remain
+	err           error // if non-nil, the conn is closed
+}
+
+// A spdyStream is the state of one request on a spdyConn. Its fields
+// are guarded by the conn's mu.
+type spdyStream struct {
+	c   *spdyConn
+	id  uint32 // zero until the SYN_STREAM is sent
+	req *Request
+
+	res        *Response
+	addedGzip  bool
+	err        error // io.EOF once the response is complete
+	buf        bytes.Buffer
+	sendWindow int32
+	recvWindow int32
+	unacked    int32
+	sentEnd    bool // our half of the stream is closed
+	recvEnd    bool // the server's half of the stream is closed
+	bodyClosed bool
+}
+
+// testHookSPDYStreamWait is called, with mu held, when a stream waits
+// for the server to allow another.
+var testHookSPDYStreamWait = func() {}
+
+func newSPDYConn(t *Transport, c net.Conn) *spdyConn {
+	sc := &spdyConn{
+		t:             t,
+		conn:          c,
+		fr:            newSPDYFramer(c),
+		streams:       make(map[uint32]*spdyStream),
+		nextID:        1,
+		initialWindow: spdyInitialWindow,
+		sendWindow:    spdyInitialWindow,
+		recvWindow:    spdyInitialWindow,
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		sc.tlsState = &cs
+	}
+	sc.fr.maxHeaderBytes = t.maxHeaderBytes()
+	sc.cond = sync.NewCond(&sc.mu)
+	go sc.readLoop()
+	return sc
+}
+
+// usable reports whether c may take new requests.
+func (c *spdyConn) usable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usableLocked()
+}
+
+func (c *spdyConn) usableLocked() bool {
+	return c.err == nil && !c.goAway && !c.closing && c.nextID <= spdyMaxStreamID
+}
+
+// CloseIdleConnections closes c once its requests are done. It is
+// called by Transport.CloseIdleConnections.
+func (c *spdyConn) CloseIdleConnections() {
+	c.mu.Lock()
+	c.closing = true
+	c.closeIfIdleLocked()
+	c.mu.Unlock()
+}
+
+func (c *spdyConn) closeIfIdleLocked() {
+	if len(c.streams) == 0 && (c.closing || c.goAway) {
+		c.conn.Close()
+	}
+}
+
+func (c *spdyConn) writeFrame(fr interface{}) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	err := c.fr.writeFrame(fr)
+	if err != nil {
+		// readLoop fails the streams.
+		c.conn.Close()
+	}
+	return err
+}
+
+func (c *spdyConn) RoundTrip(req *Request) (*Response, error) {
+	s := &spdyStream{c: c, req: req}
+	c.t.setReqCanceler(req, func() { c.cancel(s) })
+	c.t.addStat(&c.t.stats.Requests, 1)
+	if err := c.startStream(s); err != nil {
+		c.t.setReqCanceler(req, nil)
+		if err != errSPDYConnUnusable {
+			req.closeBody()
+		}
+		return nil, err
+	}
+	if req.Body != nil {
+		go c.writeBody(s)
+	}
+	c.mu.Lock()
+	for s.res == nil && s.err == nil {
+		c.cond.Wait()
+	}
+	res, err := s.res, s.err
+	c.mu.Unlock()
+	if res == nil {
+		c.t.setReqCanceler(req, nil)
+		return nil, err
+	}
+	return res, nil
+}
+
+// startStream waits for the server to allow another stream, then
+// sends the SYN_STREAM for s.
+func (c *spdyConn) startStream(s *spdyStream) error {
+	req := s.req
+	h := make(Header)
+	for k, vv := range req.Header {
+		switch k = strings.ToLower(k); k {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "host":
+			// Connection-specific; meaningless in SPDY.
+		default:
+			h[k] = append(h[k], vv...)
+		}
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	h[":method"] = []string{method}
+	h[":path"] = []string{req.URL.RequestURI()}
+	h[":version"] = []string{"HTTP/1.1"}
+	h[":host"] = []string{host}
+	h[":scheme"] = []string{req.URL.Scheme}
+	if _, ok := h["user-agent"]; !ok {
+		h["user-agent"] = []string{defaultUserAgent}
+	}
+	if req.Body != nil && req.ContentLength > 0 {
+		h["content-length"] = []string{strconv.FormatInt(req.ContentLength, 10)}
+	}
+	if !c.t.DisableCompression && h["accept-encoding"] == nil && h["range"] == nil && method != "HEAD" {
+		// As for HTTP/1.1; see persistConn.roundTrip.
+		s.addedGzip = true
+		h["accept-encoding"] = []string{"gzip, deflate"}
+	}
+	var flags uint8
+	if req.Body == nil {
+		flags = spdyFlagFin
+	}
+
+	// Wait for a stream slot without holding wmu, which readLoop
+	// and other streams need to make progress, and reserve it.
+	c.mu.Lock()
+	for c.usableLocked() && s.err == nil && c.maxStreams > 0 && len(c.streams)+c.reserved >= c.maxStreams {
+		testHookSPDYStreamWait()
+		c.cond.Wait()
+	}
+	c.reserved++
+	c.mu.Unlock()
+
+	// Assign the ID with wmu held, so that IDs increase on the wire.
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.Lock()
+	c.reserved--
+	if s.err != nil || !c.usableLocked() {
+		err := s.err
+		if err == nil {
+			err = errSPDYConnUnusable
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		return err
+	}
+	s.id = c.nextID
+	c.nextID += 2
+	s.sendWindow = c.initialWindow
+	s.recvWindow = spdyInitialWindow
+	s.sentEnd = req.Body == nil
+	c.streams[s.id] = s
+	c.mu.Unlock()
+	if err := c.fr.writeFrame(&spdySynStream{streamID: s.id, flags: flags, header: h}); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// writeBody sends the request body of s, as the flow control windows
+// allow.
+func (c *spdyConn) writeBody(s *spdyStream) {
+	body := s.req.Body
+	defer body.Close()
+	buf := make([]byte, spdyMaxDataLen)
+	for {
+		n, err := body.Read(buf)
+		for p := buf[:n]; len(p) > 0; {
+			m, ok := c.awaitSendWindow(s, len(p))
+			if !ok {
+				return
+			}
+			if c.writeFrame(&spdyData{streamID: s.id, data: p[:m]}) != nil {
+				return
+			}
+			p = p[m:]
+		}
+		if err == io.EOF {
+			c.mu.Lock()
+			ended := s.sentEnd
+			s.sentEnd = true
+			c.forgetIfDoneLocked(s)
+			c.mu.Unlock()
+			if !ended {
+				c.writeFrame(&spdyData{streamID: s.id, flags: spdyFlagFin})
+			}
+			return
+		}
+		if err != nil {
+			c.resetStream(s, spdyCancel, err)
+			return
+		}
+	}
+}
+
+// awaitSendWindow waits until up to n bytes may be sent on s, and
+// takes them from the windows. It reports false if s or c ended
+// first.
+func (c *spdyConn) awaitSendWindow(s *spdyStream, n int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !s.sentEnd && c.err == nil && (s.sendWindow <= 0 || c.sendWindow <= 0) {
+		c.cond.Wait()
+	}
+	if s.sentEnd || c.err != nil {
+		return 0, false
+	}
+	if int(s.sendWindow) < n {
+		n = int(s.sendWindow)
+	}
+	if int(c.sendWindow) < n {
+		n = int(c.sendWindow)
+	}
+	s.sendWindow -= int32(n)
+	c.sendWindow -= int32(n)
+	return n, true
+}
+
+// failStreamLocked ends both halves of s, with err unless it already
+// has a result.
+func (c *spdyConn) failStreamLocked(s *spdyStream, err error) {
+	if s.err == nil {
+		s.err = err
+	}
+	s.sentEnd = true
+	s.recvEnd = true
+	c.forgetIfDoneLocked(s)
+	c.cond.Broadcast()
+}
+
+func (c *spdyConn) forgetIfDoneLocked(s *spdyStream) {
+	if s.sentEnd && s.recvEnd && c.streams[s.id] == s {
+		delete(c.streams, s.id)
+		c.cond.Broadcast()
+		c.closeIfIdleLocked()
+	}
+}
+
+// resetStream ends s with err, and tells the server with status.
+func (c *spdyConn) resetStream(s *spdyStream, status uint32, err error) {
+	c.mu.Lock()
+	open := s.id != 0 && !(s.sentEnd && s.recvEnd)
+	c.failStreamLocked(s, err)
+	c.mu.Unlock()
+	if open {
+		c.writeFrame(&spdyRstStream{streamID: s.id, status: status})
+	}
+}
+
+// cancel is the Transport.CancelRequest function of s. Its
+// RST_STREAM is sent in the background, so that CancelRequest doesn't
+// wait behind a blocked write.
+func (c *spdyConn) cancel(s *spdyStream) {
+	c.mu.Lock()
+	open := s.id != 0 && !(s.sentEnd && s.recvEnd)
+	c.failStreamLocked(s, ErrRequestCanceled)
+	c.mu.Unlock()
+	if open {
+		go c.writeFrame(&spdyRstStream{streamID: s.id, status: spdyCancel})
+	}
+}
+
+// consumedLocked notes that n bytes received on c, and on s if
+// non-nil, were read or discarded, and returns the WINDOW_UPDATE
+// frames, if any, that give them back to the server.
+func (c *spdyConn) consumedLocked(s *spdyStream, n int) []interface{} {
+	var ups []interface{}
+	c.unacked += int32(n)
+	if c.unacked >= spdyInitialWindow/2 {
+		ups = append(ups, &spdyWindowUpdate{streamID: 0, delta: uint32(c.unacked)})
+		c.recvWindow += c.unacked
+		c.unacked = 0
+	}
+	if s != nil && !s.recvEnd {
+		s.unacked += int32(n)
+		if s.unacked >= spdyInitialWindow/2 {
+			ups = append(ups, &spdyWindowUpdate{streamID: s.id, delta: uint32(s.unacked)})
+			s.recvWindow += s.unacked
+			s.unacked = 0
+		}
+	}
+	return ups
+}
+
+func (c *spdyConn) writeFrames(frs []interface{}) {
+	for _, fr := range frs {
+		if c.writeFrame(fr) != nil {
+			return
+		}
+	}
+}
+
+// readLoop reads and handles the frames from the server, until the
+// conn fails.
+func (c *spdyConn) readLoop() {
+	var err error
+	for {
+		var fr interface{}
+		if fr, err = c.fr.readFrame(); err != nil {
+			break
+		}
+		if err = c.handleFrame(fr); err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.conn.Close()
+	c.mu.Lock()
+	c.err = err
+	for _, s := range c.streams {
+		c.failStreamLocked(s, err)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// handleFrame handles fr. An error ends the conn.
+func (c *spdyConn) handleFrame(fr interface{}) error {
+	var out []interface{} // frames to write in reply
+	c.mu.Lock()
+	switch fr := fr.(type) {
+	case *spdySynReply:
+		s := c.streams[fr.streamID]
+		if s == nil {
+			break
+		}
+		if s.res != nil || s.recvEnd {
+			out = append(out, c.streamErrorLocked(s, spdyProtocolError))
+			break
+		}
+		res, err := s.response(fr.header)
+		if err != nil {
+			c.failStreamLocked(s, err)
+			out = append(out, &spdyRstStream{streamID: s.id, status: spdyProtocolError})
+			break
+		}
+		s.res = res
+		if fr.flags&spdyFlagFin != 0 {
+			c.endRecvLocked(s)
+		}
+		c.cond.Broadcast()
+	case *spdyHeaders:
+		s := c.streams[fr.streamID]
+		if s == nil {
+			break
+		}
+		if s.res == nil || s.recvEnd {
+			out = append(out, c.streamErrorLocked(s, spdyProtocolError))
+			break
+		}
+		// Headers after the reply are trailers.
+		if s.res.Trailer == nil {
+			s.res.Trailer = make(Header)
+		}
+		for k, vv := range fr.header {
+			for _, v := range vv {
+				s.res.Trailer.Add(k, v)
+			}
+		}
+		if fr.flags&spdyFlagFin != 0 {
+			c.endRecvLocked(s)
+		}
+	case *spdyData:
+		n := len(fr.data)
+		if c.recvWindow -= int32(n); c.recvWindow < 0 {
+			c.mu.Unlock()
+			return errors.New("net/http: SPDY server exceeded the connection flow control window")
+		}
+		s := c.streams[fr.streamID]
+		switch {
+		case s == nil || s.recvEnd:
+			out = c.consumedLocked(nil, n)
+		case s.res == nil:
+			out = append(c.consumedLocked(nil, n), c.streamErrorLocked(s, spdyProtocolError))
+		default:
+			if s.recvWindow -= int32(n); s.recvWindow < 0 {
+				out = append(c.consumedLocked(nil, n), c.streamErrorLocked(s, spdyFlowControlError))
+				break
+			}
+			if s.bodyClosed {
+				out = c.consumedLocked(nil, n)
+			} else {
+				s.buf.Write(fr.data)
+			}
+			if fr.flags&spdyFlagFin != 0 {
+				c.endRecvLocked(s)
+			}
+			c.cond.Broadcast()
+		}
+	case *spdyRstStream:
+		if s := c.streams[fr.streamID]; s != nil {
+			err := errSPDYNotProcessed
+			if fr.status != spdyRefusedStream {
+				err = fmt.Errorf("net/http: SPDY server reset the stream with status %d", fr.status)
+			}
+			c.failStreamLocked(s, err)
+		}
+	case *spdySettings:
+		if v, ok := fr.values[spdySettingsMaxConcurrentStreams]; ok {
+			c.maxStreams = int(v)
+			if c.maxStreams <= 0 || uint64(v) > spdyMaxStreamID {
+				c.maxStreams = 0
+			}
+		}
+		if v, ok := fr.values[spdySettingsInitialWindowSize]; ok {
+			if v > spdyMaxStreamID {
+				c.mu.Unlock()
+				return errors.New("net/http: SPDY server sent an invalid initial window size")
+			}
+			delta := int32(v) - c.initialWindow
+			c.initialWindow = int32(v)
+			for _, s := range c.streams {
+				s.sendWindow += delta
+			}
+		}
+		c.cond.Broadcast()
+	case *spdyPing:
+		// Even IDs are the server's, to be echoed.
+		if fr.id%2 == 0 {
+			out = append(out, fr)
+		}
+	case *spdyGoAway:
+		c.goAway = true
+		for id, s := range c.streams {
+			if id > fr.lastStreamID {
+				c.failStreamLocked(s, errSPDYNotProcessed)
+			}
+		}
+		c.closeIfIdleLocked()
+		c.cond.Broadcast()
+	case *spdyWindowUpdate:
+		if fr.streamID == 0 {
+			if c.sendWindow += int32(fr.delta); c.sendWindow < 0 {
+				c.mu.Unlock()
+				return errors.New("net/http: SPDY server overflowed the connection flow control window")
+			}
+		} else if s := c.streams[fr.streamID]; s != nil {
+			if s.sendWindow += int32(fr.delta); s.sendWindow < 0 {
+				out = append(out, c.streamErrorLocked(s, spdyFlowControlError))
+			}
+		}
+		c.cond.Broadcast()
+	case *spdySynStream:
+		// Server push isn't supported.
+		out = append(out, &spdyRstStream{streamID: fr.streamID, status: spdyRefusedStream})
+	}
+	c.mu.Unlock()
+	c.writeFrames(out)
+	return nil
+}
+
+// streamErrorLocked fails s because the server misbehaved on it,
+// and returns the RST_STREAM frame that tells it so.
+func (c *spdyConn) streamErrorLocked(s *spdyStream, status uint32) interface{} {
+	c.failStreamLocked(s, fmt.Errorf("net/http: SPDY stream error %d", status))
+	return &spdyRstStream{streamID: s.id, status: status}
+}
+
+// endRecvLocked notes that the server closed its half of s.
+func (c *spdyConn) endRecvLocked(s *spdyStream) {
+	s.recvEnd = true
+	if s.err == nil {
+		s.err = io.EOF
+	}
+	c.forgetIfDoneLocked(s)
+	c.cond.Broadcast()
+}
+
+// response makes the Response to s from the SYN_REPLY headers h.
+func (s *spdyStream) response(h Header) (*Response, error) {
+	status, version := h[":status"], h[":version"]
+	if len(status) != 1 || len(version) != 1 {
+		return nil, errors.New("net/http: SPDY reply without status or version")
+	}
+	res := &Response{
+		Status:        status[0],
+		Proto:         version[0],
+		Header:        make(Header),
+		ContentLength: -1,
+		Request:       s.req,
+		TLS:           s.c.tlsState,
+	}
+	code := status[0]
+	if i := strings.IndexByte(code, ' '); i >= 0 {
+		code = code[:i]
+	}
+	var err error
+	if res.StatusCode, err = strconv.Atoi(code); err != nil || len(code) != 3 {
+		return nil, &badStringError{"malformed SPDY status", status[0]}
+	}
+	var ok bool
+	if res.ProtoMajor, res.ProtoMinor, ok = ParseHTTPVersion(res.Proto); !ok {
+		return nil, &badStringError{"malformed SPDY version", res.Proto}
+	}
+	for k, vv := range h {
+		if strings.HasPrefix(k, ":") {
+			continue
+		}
+		k = CanonicalHeaderKey(k)
+		for _, v := range vv {
+			res.Header.Add(k, v)
+		}
+	}
+	if cl := res.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			res.ContentLength = n
+		}
+	}
+	res.Body = &spdyBody{s: s}
+	hasBody := res.ContentLength != 0 && s.req.Method != "HEAD"
+	if s.addedGzip && hasBody {
+		ce := res.Header.Get("Content-Encoding")
+		if codings, ok := decodableCodings(ce); ok {
+			res.Header.Del("Content-Encoding")
+			res.Header.Del("Content-Length")
+			res.Uncompressed = true
+			res.EncodedContentLength = res.ContentLength
+			res.ContentEncoding = ce
+			res.ContentLength = -1
+			res.Body = decodeBody(res.Body, codings)
+		}
+	}
+	if max := s.c.t.MaxResponseBodyBytes; max > 0 && hasBody {
+		res.Body = &maxBytesBody{rc: res.Body, n: max, max: max}
+	}
+	return res, nil
+}
+
+// spdyBody is the Response.Body of a spdyStream.
+type spdyBody struct {
+	s *spdyStream
+}
+
+func (b *spdyBody) Read(p []byte) (int, error) {
+	s := b.s
+	c := s.c
+	c.mu.Lock()
+	for s.buf.Len() == 0 && s.err == nil && !s.bodyClosed {
+		c.cond.Wait()
+	}
+	if s.bodyClosed {
+		c.mu.Unlock()
+		return 0, errReadOnClosedResBody
+	}
+	if s.buf.Len() == 0 {
+		err := s.err
+		c.mu.Unlock()
+		c.t.setReqCanceler(s.req, nil)
+		return 0, err
+	}
+	n, _ := s.buf.Read(p)
+	ups := c.consumedLocked(s, n)
+	c.mu.Unlock()
+	c.writeFrames(ups)
+	return n, nil
+}
+
+func (b *spdyBody) Close() error {
+	s := b.s
+	c := s.c
+	c.t.setReqCanceler(s.req, nil)
+	c.mu.Lock()
+	if s.bodyClosed {
+		c.mu.Unlock()
+		return nil
+	}
+	s.bodyClosed = true
+	ups := c.consumedLocked(nil, s.buf.Len())
+	s.buf.Reset()
+	open := !s.recvEnd
+	if open {
+		c.failStreamLocked(s, errReadOnClosedResBody)
+		ups = append(ups, &spdyRstStream{streamID: s.id, status: spdyCancel})
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.writeFrames(ups)
+	return nil
+}
@@ -0,0 +1,457 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSPDYFramer(t *testing.T) {
+	var buf bytes.Buffer
+	w, r := newSPDYFramer(&buf), newSPDYFramer(&buf)
+	frames := []interface{}{
+		&spdySynStream{streamID: 1, priority: 3, flags: spdyFlagFin, header: Header{":method": {"GET"}, "accept": {"a", "b"}}},
+		&spdySynReply{streamID: 1, header: Header{":status": {"200 OK"}, ":version": {"HTTP/1.1"}}},
+		&spdyHeaders{streamID: 1, flags: spdyFlagFin, header: Header{"x-trailer": {"t"}}},
+		// Compressed against the earlier header blocks.
+		&spdySynStream{streamID: 3, assocID: 1, flags: spdyFlagUnidirectional, header: Header{":method": {"GET"}, "accept": {"a", "b"}}},
+		&spdyData{streamID: 3, flags: spdyFlagFin, data: []byte("hello")},
+		&spdyRstStream{streamID: 3, status: spdyCancel},
+		&spdySettings{values: map[uint32]uint32{spdySettingsMaxConcurrentStreams: 100, spdySettingsInitialWindowSize: 1 << 20}},
+		&spdyPing{id: 2},
+		&spdyGoAway{lastStreamID: 3},
+		&spdyWindowUpdate{streamID: 1, delta: 1000},
+	}
+	for _, want := range frames {
+		if err := w.writeFrame(want); err != nil {
+			t.Fatalf("writing %#v: %v", want, err)
+		}
+		got, err := r.readFrame()
+		if err != nil {
+			t.Fatalf("reading %#v: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("read %#v; want %#v", got, want)
+		}
+	}
+
+	// Control frames of unknown types are skipped.
+	buf.Write([]byte{0x80, spdyVersion, 0, 10, 0, 0, 0, 2, 'x', 'y'})
+	w.writeFrame(&spdyPing{id: 4})
+	if got, err := r.readFrame(); err != nil || !reflect.DeepEqual(got, &spdyPing{id: 4}) {
+		t.Errorf("after unknown frame, read %#v, %v; want PING 4", got, err)
+	}
+}
+
+// spdyTestServer is the server end of a pipe to a spdyConn under
+// test, driven by the test goroutine.
+type spdyTestServer struct {
+	t  *testing.T
+	c  net.Conn
+	fr *spdyFramer
+}
+
+func newSPDYTest(t *testing.T, tr *Transport) (*spdyConn, *spdyTestServer) {
+	cc, sc := net.Pipe()
+	return newSPDYConn(tr, cc), &spdyTestServer{t: t, c: sc, fr: newSPDYFramer(sc)}
+}
+
+func (s *spdyTestServer) read() interface{} {
+	fr, err := s.fr.readFrame()
+	if err != nil {
+		s.t.Fatalf("server reading frame: %v", err)
+	}
+	return fr
+}
+
+func (s *spdyTestServer) readSynStream() *spdySynStream {
+	fr := s.read()
+	syn, ok := fr.(*spdySynStream)
+	if !ok {
+		s.t.Fatalf("server read %#v; want a SYN_STREAM", fr)
+	}
+	return syn
+}
+
+func (s *spdyTestServer) readData() *spdyData {
+	fr := s.read()
+	d, ok := fr.(*spdyData)
+	if !ok {
+		s.t.Fatalf("server read %#v; want DATA", fr)
+	}
+	return d
+}
+
+func (s *spdyTestServer) write(fr interface{}) {
+	if err := s.fr.writeFrame(fr); err != nil {
+		s.t.Fatalf("server writing %#v: %v", fr, err)
+	}
+}
+
+// reply answers stream id with a 200 and body.
+func (s *spdyTestServer) reply(id uint32, body string) {
+	s.write(&spdySynReply{streamID: id, header: Header{
+		":status":        {"200 OK"},
+		":version":       {"HTTP/1.1"},
+		"content-length": {strconv.Itoa(len(body))},
+	}})
+	s.write(&spdyData{streamID: id, flags: spdyFlagFin, data: []byte(body)})
+}
+
+// drain reads and discards the rest of the client's frames.
+func (s *spdyTestServer) drain() {
+	go func() {
+		for {
+			if _, err := s.fr.readFrame(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+type spdyResult struct {
+	res  *Response
+	body string
+	err  error
+}
+
+// spdyDo sends req on c and reads its response body, in the
+// background.
+func spdyDo(c *spdyConn, req *Request) <-chan spdyResult {
+	ch := make(chan spdyResult, 1)
+	go func() {
+		res, err := c.RoundTrip(req)
+		if err != nil {
+			ch <- spdyResult{err: err}
+			return
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ch <- spdyResult{res, string(body), err}
+	}()
+	return ch
+}
+
+func TestSPDYConcurrentRequests(t *testing.T) {
+	c, s := newSPDYTest(t, &Transport{})
+	defer s.c.Close()
+
+	resc := make(map[string]<-chan spdyResult)
+	for _, path := range []string{"/a", "/b"} {
+		req, _ := NewRequest("GET", "https://spdy.test"+path, nil)
+		req.Header.Set("Connection", "close")
+		req.Header.Set("X-Foo", "bar")
+		resc[path] = spdyDo(c, req)
+	}
+	ids := make(map[string]uint32)
+	var lastID uint32
+	for i := 0; i < 2; i++ {
+		syn := s.readSynStream()
+		if syn.streamID%2 != 1 || syn.streamID <= lastID {
+			t.Errorf("stream ID %d after %d; want increasing odd IDs", syn.streamID, lastID)
+		}
+		lastID = syn.streamID
+		if syn.flags&spdyFlagFin == 0 {
+			t.Errorf("SYN_STREAM of bodiless request without FIN")
+		}
+		h := syn.header
+		for k, want := range map[string]string{
+			":method":  "GET",
+			":host":    "spdy.test",
+			":scheme":  "https",
+			":version": "HTTP/1.1",
+			"x-foo":    "bar",
+		} {
+			if got := h[k]; len(got) != 1 || got[0] != want {
+				t.Errorf("header %s = %q; want %q", k, got, want)
+			}
+		}
+		if _, ok := h["connection"]; ok {
+			t.Error("connection header sent")
+		}
+		ids[h[":path"][0]] = syn.streamID
+	}
+
+	// Answer in the other order, with a header of two values.
+	for _, path := range []string{"/b", "/a"} {
+		s.write(&spdySynReply{streamID: ids[path], header: Header{
+			":status":  {"404 Not Found"},
+			":version": {"HTTP/1.1"},
+			"x-path":   {path, "again"},
+		}})
+		s.write(&spdyData{streamID: ids[path], flags: spdyFlagFin, data: []byte("body " + path)})
+		r := <-resc[path]
+		if r.err != nil {
+			t.Fatalf("%s: %v", path, r.err)
+		}
+		if r.res.StatusCode != 404 || r.res.Proto != "HTTP/1.1" || r.body != "body "+path {
+			t.Errorf("%s: got %d %s %q", path, r.res.StatusCode, r.res.Proto, r.body)
+		}
+		if got, want := r.res.Header["X-Path"], []string{path, "again"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: X-Path = %q; want %q", path, got, want)
+		}
+	}
+}
+
+func TestSPDYRequestBodyFlowControl(t *testing.T) {
+	c, s := newSPDYTest(t, &Transport{})
+	defer s.c.Close()
+
+	body := strings.Repeat("0123456789", 10<<10)
+	req, _ := NewRequest("POST", "https://spdy.test/upload", strings.NewReader(body))
+	resc := spdyDo(c, req)
+	syn := s.readSynStream()
+	if syn.flags&spdyFlagFin != 0 {
+		t.Fatal("SYN_STREAM of request with body has FIN")
+	}
+	if got := syn.header["content-length"]; len(got) != 1 || got[0] != strconv.Itoa(len(body)) {
+		t.Errorf("content-length = %q; want %d", got, len(body))
+	}
+	var got []byte
+	for len(got) < spdyInitialWindow {
+		got = append(got, s.readData().data...)
+	}
+	if len(got) != spdyInitialWindow {
+		t.Fatalf("client sent %d bytes; want the window, %d", len(got), spdyInitialWindow)
+	}
+	// The client must be waiting for the window to open, so the
+	// next frame it sends is its answer to a PING.
+	s.write(&spdyPing{id: 2})
+	if fr := s.read(); !reflect.DeepEqual(fr, &spdyPing{id: 2}) {
+		t.Fatalf("with the window used up, client sent %#v; want PING 2 echoed", fr)
+	}
+	s.write(&spdyWindowUpdate{streamID: 0, delta: 1 << 20})
+	s.write(&spdyWindowUpdate{streamID: syn.streamID, delta: 1 << 20})
+	for {
+		d := s.readData()
+		got = append(got, d.data...)
+		if d.flags&spdyFlagFin != 0 {
+			break
+		}
+	}
+	if string(got) != body {
+		t.Errorf("server got %d bytes of body; want the %d sent", len(got), len(body))
+	}
+	s.reply(syn.streamID, "ok")
+	if r := <-resc; r.err != nil || r.body != "ok" {
+		t.Errorf("got %q, %v; want ok", r.body, r.err)
+	}
+}
+
+func TestSPDYResponseFlowControl(t *testing.T) {
+	c, s := newSPDYTest(t, &Transport{})
+	defer s.c.Close()
+
+	req, _ := NewRequest("GET", "https://spdy.test/big", nil)
+	resc := spdyDo(c, req)
+	syn := s.readSynStream()
+	s.write(&spdySynReply{streamID: syn.streamID, header: Header{":status": {"200 OK"}, ":version": {"HTTP/1.1"}}})
+	body := strings.Repeat("0123456789", 30<<10)
+	streamWindow, connWindow := spdyInitialWindow, spdyInitialWindow
+	for sent := 0; sent < len(body); {
+		// Without WINDOW_UPDATEs from the client, this blocks.
+		for streamWindow == 0 || connWindow == 0 {
+			fr, ok := s.read().(*spdyWindowUpdate)
+			if !ok {
+				t.Fatalf("server read %#v; want WINDOW_UPDATE", fr)
+			}
+			switch fr.streamID {
+			case 0:
+				connWindow += int(fr.delta)
+			case syn.streamID:
+				streamWindow += int(fr.delta)
+			}
+		}
+		n := len(body) - sent
+		for _, m := range []int{spdyMaxDataLen, streamWindow, connWindow} {
+			if n > m {
+				n = m
+			}
+		}
+		d := &spdyData{streamID: syn.streamID, data: []byte(body[sent : sent+n])}
+		if sent += n; sent == len(body) {
+			d.flags = spdyFlagFin
+		}
+		s.write(d)
+		streamWindow -= n
+		connWindow -= n
+	}
+	s.drain()
+	r := <-resc
+	if r.err != nil || r.body != body {
+		t.Errorf("got %d bytes, %v; want the %d sent", len(r.body), r.err, len(body))
+	}
+}
+
+// A request waiting for the server to allow another stream must not
+// keep the conn from answering PINGs and finishing other streams.
+func TestSPDYStreamWaitDoesNotBlockWrites(t *testing.T) {
+	waiting := make(chan bool, 1)
+	defer func(old func()) { testHookSPDYStreamWait = old }(testHookSPDYStreamWait)
+	testHookSPDYStreamWait = func() {
+		select {
+		case waiting <- true:
+		default:
+		}
+	}
+	c, s := newSPDYTest(t, &Transport{})
+	defer s.c.Close()
+
+	// ping sends a PING and waits for the client's reply, which
+	// also means the frames before it were handled.
+	ping := func(id uint32) {
+		s.write(&spdyPing{id: id})
+		done := make(chan interface{}, 1)
+		go func() {
+			fr, _ := s.fr.readFrame()
+			done <- fr
+		}()
+		select {
+		case fr := <-done:
+			if p, ok := fr.(*spdyPing); !ok || p.id != id {
+				t.Fatalf("server read %#v; want PING %d", fr, id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client didn't answer PING")
+		}
+	}
+	s.write(&spdySettings{values: map[uint32]uint32{spdySettingsMaxConcurrentStreams: 1}})
+	ping(2)
+
+	reqA, _ := NewRequest("GET", "https://spdy.test/a", nil)
+	resA := spdyDo(c, reqA)
+	synA := s.readSynStream()
+	reqB, _ := NewRequest("GET", "https://spdy.test/b", nil)
+	resB := spdyDo(c, reqB)
+	<-waiting
+
+	ping(4)
+	s.reply(synA.streamID, "a")
+	if r := <-resA; r.err != nil || r.body != "a" {
+		t.Fatalf("request A: %q, %v", r.body, r.err)
+	}
+	synB := s.readSynStream()
+	if synB.streamID <= synA.streamID {
+		t.Errorf("stream ID %d after %d; want increasing IDs", synB.streamID, synA.streamID)
+	}
+	s.reply(synB.streamID, "b")
+	if r := <-resB; r.err != nil || r.body != "b" {
+		t.Fatalf("request B: %q, %v", r.body, r.err)
+	}
+}
+
+func TestSPDYNotProcessed(t *testing.T) {
+	c, s := newSPDYTest(t, &Transport{})
+	defer s.c.Close()
+
+	req, _ := NewRequest("GET", "https://spdy.test/a", nil)
+	resc := spdyDo(c, req)
+	s.write(&spdyRstStream{streamID: s.readSynStream().streamID, status: spdyRefusedStream})
+	if r := <-resc; r.err != errSPDYNotProcessed || !spdyRetryable(req, r.err) {
+		t.Errorf("refused stream: error %v; want retryable %v", r.err, errSPDYNotProcessed)
+	}
+
+	req, _ = NewRequest("GET", "https://spdy.test/b", nil)
+	resc = spdyDo(c, req)
+	syn := s.readSynStream()
+	s.write(&spdyGoAway{lastStreamID: syn.streamID - 2})
+	if r := <-resc; r.err != errSPDYNotProcessed {
+		t.Errorf("stream past GOAWAY: error %v; want %v", r.err, errSPDYNotProcessed)
+	}
+	if _, err := c.RoundTrip(req); err != errSPDYConnUnusable {
+		t.Errorf("RoundTrip after GOAWAY: error %v; want %v", err, errSPDYConnUnusable)
+	}
+	// With no streams left, the client hangs up.
+	if fr, err := s.fr.readFrame(); err == nil {
+		t.Errorf("after GOAWAY, client sent %#v; want it to close the conn", fr)
+	}
+}
+
+func TestSPDYResetsPushAndCanceledStreams(t *testing.T) {
+	tr := &Transport{}
+	c, s := newSPDYTest(t, tr)
+	defer s.c.Close()
+
+	s.write(&spdySynStream{streamID: 2, assocID: 1, flags: spdyFlagUnidirectional, header: Header{":path": {"/pushed"}}})
+	if fr := s.read(); !reflect.DeepEqual(fr, &spdyRstStream{streamID: 2, status: spdyRefusedStream}) {
+		t.Errorf("for server push, client sent %#v; want RST_STREAM refusing it", fr)
+	}
+
+	req, _ := NewRequest("GET", "https://spdy.test/canceled", nil)
+	resc := spdyDo(c, req)
+	syn := s.readSynStream()
+	tr.CancelRequest(req)
+	if fr := s.read(); !reflect.DeepEqual(fr, &spdyRstStream{streamID: syn.streamID, status: spdyCancel}) {
+		t.Errorf("for canceled request, client sent %#v; want RST_STREAM canceling it", fr)
+	}
+	if r := <-resc; r.err != ErrRequestCanceled {
+		t.Errorf("canceled request: error %v; want %v", r.err, ErrRequestCanceled)
+	}
+
+	// Closing a body before its end resets the stream.
+	req, _ = NewRequest("GET", "https://spdy.test/closed", nil)
+	errc := make(chan error, 1)
+	go func() {
+		res, err := c.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
+	}()
+	syn = s.readSynStream()
+	s.write(&spdySynReply{streamID: syn.streamID, header: Header{":status": {"200 OK"}, ":version": {"HTTP/1.1"}}})
+	if fr := s.read(); !reflect.DeepEqual(fr, &spdyRstStream{streamID: syn.streamID, status: spdyCancel}) {
+		t.Errorf("for closed body, client sent %#v; want RST_STREAM canceling it", fr)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveSPDYEcho is a minimal SPDY server: it answers each request,
+// once complete, with a 200 whose body is "spdy " and the request
+// path.
+func serveSPDYEcho(c net.Conn) {
+	defer c.Close()
+	fr := newSPDYFramer(c)
+	paths := make(map[uint32]string)
+	for {
+		f, err := fr.readFrame()
+		if err != nil {
+			return
+		}
+		var id uint32
+		switch f := f.(type) {
+		case *spdySynStream:
+			paths[f.streamID] = strings.Join(f.header[":path"], "")
+			if f.flags&spdyFlagFin == 0 {
+				continue
+			}
+			id = f.streamID
+		case *spdyData:
+			if f.flags&spdyFlagFin == 0 {
+				continue
+			}
+			id = f.streamID
+		default:
+			continue
+		}
+		body := "spdy " + paths[id]
+		delete(paths, id)
+		fr.writeFrame(&spdySynReply{streamID: id, header: Header{
+			":status":        {"200 OK"},
+			":version":       {"HTTP/1.1"},
+			"content-length": {strconv.Itoa(len(body))},
+		}})
+		fr.writeFrame(&spdyData{streamID: id, flags: spdyFlagFin, data: []byte(body)})
+	}
+}
@@ -7,6 +7,7 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -31,6 +32,24 @@ func (r *errorReader) Read(p []byte) (n int, err error) {
 	return 0, r.err
 }
 
+// progressReader reports the number of bytes read through it so far
+// to fn, along with the expected total, which is -1 if unknown.
+type progressReader struct {
+	r     io.Reader
+	n     int64
+	total int64
+	fn    func(n, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.fn(r.n, r.total)
+	}
+	return
+}
+
 // transferWriter inspects the fields of a user-supplied Request or Response,
 // sanitizes them without changing the user object and provides methods for
 // writing the respective header, body and trailer in wire format.
@@ -43,6 +62,7 @@ type transferWriter struct {
 	Close            bool
 	TransferEncoding []string
 	Trailer          Header
+	Gzip             bool // compress Body with gzip as it's written
 }
 
 func newTransferWriter(r interface{}) (t *transferWriter, err error) {
@@ -88,6 +108,21 @@ func newTransferWriter(r interface{}) (t *transferWriter, err error) {
 				t.TransferEncoding = []string{"chunked"}
 			}
 		}
+		if t.Body != nil && rr.Trace != nil && rr.Trace.RequestBodyProgress != nil {
+			t.Body = &progressReader{r: t.Body, total: t.ContentLength, fn: rr.Trace.RequestBodyProgress}
+		}
+		if t.Body != nil && rr.GzipBody {
+			if rr.Header.Get("Content-Encoding") != "" {
+				return nil, errors.New("http: Request.GzipBody set with a Content-Encoding header")
+			}
+			// The compressed length is only known once written.
+			// Chunking is fine as requests are always written
+			// as HTTP/1.1.
+			t.Gzip = true
+			t.ContentLength = -1
+			t.TransferEncoding = []string{"chunked"}
+			atLeastHTTP11 = true
+		}
 	case *Response:
 		if rr.Request != nil {
 			t.Method = rr.Request.Method
@@ -171,6 +206,11 @@ func (t *transferWriter) WriteHeader(w io.Writer) error {
 			return err
 		}
 	}
+	if t.Gzip {
+		if _, err := io.WriteString(w, "Content-Encoding: gzip\r\n"); err != nil {
+			return err
+		}
+	}
 
 	// Write Trailer header
 	if t.Trailer != nil {
@@ -204,7 +244,15 @@ func (t *transferWriter) WriteBody(w io.Writer) error {
 	if t.Body != nil {
 		if chunked(t.TransferEncoding) {
 			cw := internal.NewChunkedWriter(w)
-			_, err = io.Copy(cw, t.Body)
+			if t.Gzip {
+				gz := gzip.NewWriter(cw)
+				_, err = io.Copy(gz, t.Body)
+				if err == nil {
+					err = gz.Close()
+				}
+			} else {
+				_, err = io.Copy(cw, t.Body)
+			}
 			if err == nil {
 				err = cw.Close()
 			}
@@ -9,9 +9,15 @@ package http_test
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -23,10 +29,13 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -406,50 +415,35 @@ func TestTransportMaxPerHostIdleConns(t *testing.T) {
 
 func TestTransportServerClosingUnexpectedly(t *testing.T) {
 	defer afterTest(t)
-	ts := httptest.NewServer(hostPortHandler)
+	ts := httptest.NewUnstartedServer(hostPortHandler)
+	// The server closes the first connection after its second
+	// response, while the Transport keeps it idle for reuse.
+	ts.Listener = httptest.NewFaultListener(ts.Listener, []*httptest.Fault{{CloseAfterWrites: 2}})
+	ts.Start()
 	defer ts.Close()
 
 	tr := &Transport{}
+	defer tr.CloseIdleConnections()
 	c := &Client{Transport: tr}
 
-	fetch := func(n, retries int) string {
-		condFatalf := func(format string, arg ...interface{}) {
-			if retries <= 0 {
-				t.Fatalf(format, arg...)
-			}
-			t.Logf("retrying shortly after expected error: "+format, arg...)
-			time.Sleep(time.Second / time.Duration(retries))
+	fetch := func(n int) string {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("error in req #%d, GET: %v", n, err)
 		}
-		for retries >= 0 {
-			retries--
-			res, err := c.Get(ts.URL)
-			if err != nil {
-				condFatalf("error in req #%d, GET: %v", n, err)
-				continue
-			}
-			body, err := ioutil.ReadAll(res.Body)
-			if err != nil {
-				condFatalf("error in req #%d, ReadAll: %v", n, err)
-				continue
-			}
-			res.Body.Close()
-			return string(body)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("error in req #%d, ReadAll: %v", n, err)
 		}
-		panic("unreachable")
+		return string(body)
 	}
 
-	body1 := fetch(1, 0)
-	body2 := fetch(2, 0)
-
-	ts.CloseClientConnections() // surprise!
-
-	// This test has an expected race. Sleeping for 25 ms prevents
-	// it on most fast machines, causing the next fetch() call to
-	// succeed quickly.  But if we do get errors, fetch() will retry 5
-	// times with some delays between.
-	time.Sleep(25 * time.Millisecond)
-
-	body3 := fetch(3, 5)
+	body1 := fetch(1)
+	body2 := fetch(2)
+	// Whether or not the Transport has noticed the close, the
+	// third request must succeed, on a new connection.
+	body3 := fetch(3)
 
 	if body1 != body2 {
 		t.Errorf("expected body1 and body2 to be equal")
@@ -586,7 +580,7 @@ var roundTripTests = []struct {
 	compressed   bool
 }{
 	// Requests with no accept-encoding header use transparent compression
-	{"", "gzip", false},
+	{"", "gzip, deflate", false},
 	// Requests with other accept-encoding should pass through unmodified
 	{"foo", "foo", false},
 	// Requests with accept-encoding == gzip should be passed through
@@ -603,7 +597,7 @@ func TestRoundTripGzip(t *testing.T) {
 			t.Errorf("in handler, test %v: Accept-Encoding = %q, want %q",
 				req.FormValue("testnum"), accept, expect)
 		}
-		if accept == "gzip" {
+		if accept == "gzip" || accept == "gzip, deflate" {
 			rw.Header().Set("Content-Encoding", "gzip")
 			gz := gzip.NewWriter(rw)
 			gz.Write([]byte(responseBody))
@@ -617,7 +611,7 @@ func TestRoundTripGzip(t *testing.T) {
 
 	for i, test := range roundTripTests {
 		// Test basic request (no accept-encoding)
-		req, _ := NewRequest("GET", fmt.Sprintf("%s/?testnum=%d&expect_accept=%s", ts.URL, i, test.expectAccept), nil)
+		req, _ := NewRequest("GET", fmt.Sprintf("%s/?testnum=%d&expect_accept=%s", ts.URL, i, url.QueryEscape(test.expectAccept)), nil)
 		if test.accept != "" {
 			req.Header.Set("Accept-Encoding", test.accept)
 		}
@@ -663,7 +657,7 @@ func TestTransportGzip(t *testing.T) {
 			}
 			return
 		}
-		if g, e := req.Header.Get("Accept-Encoding"), "gzip"; g != e {
+		if g, e := req.Header.Get("Accept-Encoding"), "gzip, deflate"; g != e {
 			t.Errorf("Accept-Encoding = %q, want %q", g, e)
 		}
 		rw.Header().Set("Content-Encoding", "gzip")
@@ -773,6 +767,280 @@ func TestTransportProxy(t *testing.T) {
 	}
 }
 
+// socks5Server is a minimal SOCKS5 proxy that requires the given
+// username and password and connects every request to backend,
+// recording the target it was asked for.
+func socks5Server(t *testing.T, user, pass, backend string, target chan<- string) net.Listener {
+	ln := newLocalListener(t)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 512)
+				read := func(n int) []byte {
+					if _, err := io.ReadFull(c, buf[:n]); err != nil {
+						panic(err)
+					}
+					return buf[:n]
+				}
+				defer func() {
+					if e := recover(); e != nil {
+						t.Errorf("socks5 server: %v", e)
+					}
+				}()
+				methods := read(int(read(2)[1]))
+				if bytes.IndexByte(methods, 2) < 0 {
+					c.Write([]byte{5, 0xff})
+					return
+				}
+				c.Write([]byte{5, 2})
+				gotUser := string(read(int(read(2)[1])))
+				gotPass := string(read(int(read(1)[0])))
+				if gotUser != user || gotPass != pass {
+					c.Write([]byte{1, 1})
+					return
+				}
+				c.Write([]byte{1, 0})
+				if hdr := read(4); hdr[1] != 1 || hdr[3] != 3 {
+					c.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				host := string(read(int(read(1)[0])))
+				port := read(2)
+				target <- fmt.Sprintf("%s:%d", host, int(port[0])<<8|int(port[1]))
+				bc, err := net.Dial("tcp", backend)
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer bc.Close()
+				c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+				go io.Copy(bc, c)
+				io.Copy(c, bc)
+			}(c)
+		}
+	}()
+	return ln
+}
+
+func TestTransportSOCKS5Proxy(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "real server for "+r.Host)
+	}))
+	defer ts.Close()
+	target := make(chan string, 2)
+	ln := socks5Server(t, "user", "secret", ts.Listener.Addr().String(), target)
+	defer ln.Close()
+
+	for _, tt := range []struct {
+		user    *url.Userinfo
+		wantErr bool
+	}{
+		{url.UserPassword("user", "secret"), false},
+		{url.UserPassword("user", "wrong"), true},
+		{nil, true},
+	} {
+		pu := &url.URL{Scheme: "socks5", Host: ln.Addr().String(), User: tt.user}
+		tr := &Transport{Proxy: ProxyURL(pu)}
+		c := &Client{Transport: tr}
+		res, err := c.Get("http://socks.test/")
+		if tt.wantErr {
+			if err == nil {
+				res.Body.Close()
+				t.Errorf("user %v: Get succeeded; want error", tt.user)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("user %v: %v", tt.user, err)
+			continue
+		}
+		slurp, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if want := "real server for socks.test"; err != nil || string(slurp) != want {
+			t.Errorf("user %v: got %q, %v; want %q", tt.user, slurp, err, want)
+		}
+		if got, want := <-target, "socks.test:80"; got != want {
+			t.Errorf("user %v: proxy asked to connect to %q; want %q", tt.user, got, want)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestTransportSOCKS4Proxy(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "real server for "+r.Host)
+	}))
+	defer ts.Close()
+	ln := newLocalListener(t)
+	defer ln.Close()
+	requests := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			t.Error(err)
+			return
+		}
+		user, _ := br.ReadString(0)
+		host, _ := br.ReadString(0)
+		requests <- fmt.Sprintf("%v %q %q", hdr, user, host)
+		bc, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			c.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer bc.Close()
+		c.Write([]byte{0, 90, 0, 0, 0, 0, 0, 0})
+		go io.Copy(bc, br)
+		io.Copy(c, bc)
+	}()
+
+	pu := &url.URL{Scheme: "socks4", Host: ln.Addr().String(), User: url.User("gopher")}
+	tr := &Transport{Proxy: ProxyURL(pu)}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("http://socks.test:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slurp, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "real server for socks.test:8080"; err != nil || string(slurp) != want {
+		t.Errorf("got %q, %v; want %q", slurp, err, want)
+	}
+	// SOCKS4a: version 4, CONNECT, port 8080, address 0.0.0.1,
+	// then the user ID and host name.
+	if got, want := <-requests, `[4 1 31 144 0 0 0 1] "gopher\x00" "socks.test\x00"`; got != want {
+		t.Errorf("SOCKS4 request = %s; want %s", got, want)
+	}
+}
+
+func TestTransportSOCKS4Rejected(t *testing.T) {
+	defer afterTest(t)
+	ln := newLocalListener(t)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		bufio.NewReader(c).ReadString(0) // header and empty user ID
+		c.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+	}()
+	pu := &url.URL{Scheme: "socks4", Host: ln.Addr().String()}
+	tr := &Transport{Proxy: ProxyURL(pu)}
+	_, err := (&Client{Transport: tr}).Get("http://127.0.0.1:81/")
+	if err == nil || !strings.Contains(err.Error(), "request rejected or failed") {
+		t.Errorf("Get = %v; want a rejection error", err)
+	}
+}
+
+func TestTransportHTTPSProxy(t *testing.T) {
+	defer afterTest(t)
+	proxy := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.TLS == nil {
+			t.Error("request to the proxy wasn't over TLS")
+		}
+		fmt.Fprintf(w, "proxied %s %s", r.Method, r.URL)
+	}))
+	defer proxy.Close()
+	pu, _ := url.Parse(proxy.URL)
+
+	tr := &Transport{
+		Proxy:           ProxyURL(pu),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("http://example.test/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slurp, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "proxied GET http://example.test/path"; err != nil || string(slurp) != want {
+		t.Errorf("got %q, %v; want %q", slurp, err, want)
+	}
+	if res.TLS != nil {
+		t.Error("Response.TLS is set for a plain http request through an https proxy")
+	}
+
+	// The proxy's certificate is verified.
+	tr2 := &Transport{Proxy: ProxyURL(pu)}
+	defer tr2.CloseIdleConnections()
+	_, err = (&Client{Transport: tr2}).Get("http://example.test/path")
+	if ue, ok := err.(*url.Error); !ok {
+		t.Errorf("Get through a proxy with an untrusted certificate: %v; want a *url.Error", err)
+	} else if _, ok := ue.Err.(*TLSError); !ok {
+		t.Errorf("Get through a proxy with an untrusted certificate: %v; want a *TLSError", err)
+	}
+}
+
+func TestTransportSOCKS5HandshakeTimeout(t *testing.T) {
+	defer afterTest(t)
+	ln := newLocalListener(t)
+	defer ln.Close()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		// Accept, but never answer the handshake.
+		c, err := ln.Accept()
+		if err == nil {
+			conns <- c
+		}
+	}()
+	defer func() {
+		select {
+		case c := <-conns:
+			c.Close()
+		default:
+		}
+	}()
+
+	pu := &url.URL{Scheme: "socks5", Host: ln.Addr().String()}
+	tr := &Transport{Proxy: ProxyURL(pu), DialTimeout: 50 * time.Millisecond}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	errc := make(chan error, 1)
+	go func() {
+		res, err := c.Get("http://socks.test/")
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-time.After(10 * time.Second):
+		t.Fatal("SOCKS5 handshake didn't time out")
+	}
+	ue, ok := err.(*url.Error)
+	if !ok {
+		t.Fatalf("Get error = %#v; want *url.Error", err)
+	}
+	de, ok := ue.Err.(*DialError)
+	if !ok {
+		t.Fatalf("Get error cause = %#v; want *DialError", ue.Err)
+	}
+	if de.Proxy == nil || de.Proxy.Host != pu.Host {
+		t.Errorf("DialError Proxy = %v; want %v", de.Proxy, pu)
+	}
+	if !de.Timeout() {
+		t.Errorf("DialError %v doesn't report a timeout", de)
+	}
+}
+
 // TestTransportGzipRecursive sends a gzip quine and checks that the
 // client gets the same value back. This is more cute than anything,
 // but checks that we don't recurse forever, and checks that
@@ -803,6 +1071,39 @@ func TestTransportGzipRecursive(t *testing.T) {
 	}
 }
 
+// Tests that the Transport's reuse of gzip readers across
+// responses doesn't mix up their contents.
+func TestTransportGzipReuse(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprintf(gz, "response %s", r.URL.Path)
+		gz.Close()
+	}))
+	defer ts.Close()
+
+	c := &Client{Transport: &Transport{}}
+	for i := 0; i < 5; i++ {
+		path := fmt.Sprintf("/%d", i)
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, e := string(body), "response "+path; g != e {
+			t.Errorf("body = %q; want %q", g, e)
+		}
+		if n, err := res.Body.Read(make([]byte, 1)); n != 0 || err == nil {
+			t.Errorf("Read after Close = %d, %v; want an error", n, err)
+		}
+	}
+}
+
 // golang.org/issue/7750: request fails when server replies with
 // a short gzip body
 func TestTransportGzipShort(t *testing.T) {
@@ -830,6 +1131,104 @@ func TestTransportGzipShort(t *testing.T) {
 	}
 }
 
+func TestTransportDeflate(t *testing.T) {
+	defer afterTest(t)
+	const body = "The test string aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	encode := func(coding string, p []byte) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch coding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		}
+		w.Write(p)
+		w.Close()
+		return buf.Bytes()
+	}
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if g, e := r.Header.Get("Accept-Encoding"), "gzip, deflate"; g != e {
+			t.Errorf("Accept-Encoding = %q, want %q", g, e)
+		}
+		p := []byte(body)
+		var applied []string
+		for _, coding := range strings.Split(r.FormValue("enc"), ",") {
+			p = encode(coding, p)
+			applied = append(applied, strings.TrimPrefix(coding, "raw-"))
+		}
+		w.Header().Set("Content-Encoding", strings.Join(applied, ", "))
+		w.Header().Set("Content-Length", strconv.Itoa(len(p)))
+		w.Header().Set("X-Encoded-Length", strconv.Itoa(len(p)))
+		w.Write(p)
+	}))
+	defer ts.Close()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for _, enc := range []string{"gzip", "deflate", "raw-deflate", "deflate,gzip", "gzip,raw-deflate,gzip"} {
+		res, err := c.Get(ts.URL + "/?enc=" + enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Errorf("%s: %v", enc, err)
+			continue
+		}
+		if string(got) != body {
+			t.Errorf("%s: body = %q; want %q", enc, got, body)
+		}
+		if ce := res.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: Content-Encoding = %q; want it removed", enc, ce)
+		}
+		if !res.Uncompressed {
+			t.Errorf("%s: Uncompressed = false; want true", enc)
+		}
+		if g, e := strconv.FormatInt(res.EncodedContentLength, 10), res.Header.Get("X-Encoded-Length"); g != e {
+			t.Errorf("%s: EncodedContentLength = %s; want %s", enc, g, e)
+		}
+		if g, e := res.ContentEncoding, strings.Replace(strings.Replace(enc, "raw-", "", -1), ",", ", ", -1); g != e {
+			t.Errorf("%s: ContentEncoding = %q; want %q", enc, g, e)
+		}
+		if res.ContentLength != -1 {
+			t.Errorf("%s: ContentLength = %d; want -1", enc, res.ContentLength)
+		}
+	}
+}
+
+func TestTransportUnknownContentEncoding(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Encoding", "gzip, br")
+		w.Write([]byte("opaque"))
+	}))
+	defer ts.Close()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(got) != "opaque" {
+		t.Errorf("body = %q, %v; want %q undecoded", got, err, "opaque")
+	}
+	if g, e := res.Header.Get("Content-Encoding"), "gzip, br"; g != e {
+		t.Errorf("Content-Encoding = %q; want %q", g, e)
+	}
+	if res.Uncompressed {
+		t.Error("Uncompressed = true; want false")
+	}
+}
+
 // tests that persistent goroutine connections shut down when no longer desired.
 func TestTransportPersistConnLeak(t *testing.T) {
 	if runtime.GOOS == "plan9" {
@@ -1243,460 +1642,2423 @@ func TestIssue4191_InfiniteGetToPutTimeout(t *testing.T) {
 	ts.Close()
 }
 
-func TestTransportResponseHeaderTimeout(t *testing.T) {
+func TestTransportConnInfo(t *testing.T) {
 	defer afterTest(t)
-	if testing.Short() {
-		t.Skip("skipping timeout test in -short mode")
-	}
-	inHandler := make(chan bool, 1)
-	mux := NewServeMux()
-	mux.HandleFunc("/fast", func(w ResponseWriter, r *Request) {
-		inHandler <- true
-	})
-	mux.HandleFunc("/slow", func(w ResponseWriter, r *Request) {
-		inHandler <- true
-		time.Sleep(2 * time.Second)
-	})
-	ts := httptest.NewServer(mux)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("hi"))
+	}))
 	defer ts.Close()
-
-	tr := &Transport{
-		ResponseHeaderTimeout: 500 * time.Millisecond,
-	}
+	tr := &Transport{}
 	defer tr.CloseIdleConnections()
 	c := &Client{Transport: tr}
 
-	tests := []struct {
-		path    string
-		want    int
-		wantErr string
-	}{
-		{path: "/fast", want: 200},
-		{path: "/slow", wantErr: "timeout awaiting response headers"},
-		{path: "/fast", want: 200},
-	}
-	for i, tt := range tests {
-		res, err := c.Get(ts.URL + tt.path)
-		select {
-		case <-inHandler:
-		case <-time.After(5 * time.Second):
-			t.Errorf("never entered handler for test index %d, %s", i, tt.path)
-			continue
-		}
+	for i, wantReused := range []bool{false, true} {
+		res, err := c.Get(ts.URL)
 		if err != nil {
-			uerr, ok := err.(*url.Error)
-			if !ok {
-				t.Errorf("error is not an url.Error; got: %#v", err)
-				continue
-			}
-			nerr, ok := uerr.Err.(net.Error)
-			if !ok {
-				t.Errorf("error does not satisfy net.Error interface; got: %#v", err)
-				continue
-			}
-			if !nerr.Timeout() {
-				t.Errorf("want timeout error; got: %q", nerr)
-				continue
-			}
-			if strings.Contains(err.Error(), tt.wantErr) {
-				continue
-			}
-			t.Errorf("%d. unexpected error: %v", i, err)
-			continue
+			t.Fatal(err)
 		}
-		if tt.wantErr != "" {
-			t.Errorf("%d. no error. expected error: %v", i, tt.wantErr)
-			continue
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ci := res.ConnInfo
+		if ci == nil {
+			t.Fatalf("request %d: nil ConnInfo", i)
 		}
-		if res.StatusCode != tt.want {
-			t.Errorf("%d for path %q status = %d; want %d", i, tt.path, res.StatusCode, tt.want)
+		if ci.Reused != wantReused {
+			t.Errorf("request %d: Reused = %v; want %v", i, ci.Reused, wantReused)
+		}
+		if ci.RemoteAddr == nil || ci.RemoteAddr.String() != ts.Listener.Addr().String() {
+			t.Errorf("request %d: RemoteAddr = %v; want %v", i, ci.RemoteAddr, ts.Listener.Addr())
+		}
+		if ci.DialDuration < 0 || ci.TLSHandshakeDuration != 0 || ci.FirstByteDelay < 0 {
+			t.Errorf("request %d: unexpected durations in %+v", i, ci)
 		}
 	}
 }
 
-func TestTransportCancelRequest(t *testing.T) {
+func TestTransportIdleConnTimeout(t *testing.T) {
 	defer afterTest(t)
-	if testing.Short() {
-		t.Skip("skipping test in -short mode")
-	}
-	unblockc := make(chan bool)
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
 	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
-		fmt.Fprintf(w, "Hello")
-		w.(Flusher).Flush() // send headers and some body
-		<-unblockc
+		w.Write([]byte("hi"))
 	}))
 	defer ts.Close()
-	defer close(unblockc)
-
-	tr := &Transport{}
+	tr := &Transport{IdleConnTimeout: time.Minute}
 	defer tr.CloseIdleConnections()
 	c := &Client{Transport: tr}
 
-	req, _ := NewRequest("GET", ts.URL, nil)
-	res, err := c.Do(req)
-	if err != nil {
-		t.Fatal(err)
+	get := func() *ConnInfo {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res.ConnInfo
+	}
+	cacheKey := "|http|" + ts.Listener.Addr().String()
+	waitIdle := func() {
+		for deadline := time.Now().Add(5 * time.Second); tr.IdleConnCountForTesting(cacheKey) != 1; {
+			if time.Now().After(deadline) {
+				t.Fatal("connection never became idle")
+			}
+			time.Sleep(time.Millisecond)
+		}
 	}
-	go func() {
-		time.Sleep(1 * time.Second)
-		tr.CancelRequest(req)
-	}()
-	t0 := time.Now()
-	body, err := ioutil.ReadAll(res.Body)
-	d := time.Since(t0)
 
-	if err == nil {
-		t.Error("expected an error reading the body")
+	get()
+	waitIdle()
+	clock.Advance(time.Minute - time.Second)
+	if ci := get(); !ci.Reused {
+		t.Error("connection closed before IdleConnTimeout expired")
 	}
-	if string(body) != "Hello" {
-		t.Errorf("Body = %q; want Hello", body)
+	waitIdle()
+	clock.Advance(time.Minute)
+	if n := tr.IdleConnCountForTesting(cacheKey); n != 0 {
+		t.Errorf("%d idle conns after IdleConnTimeout; want 0", n)
 	}
-	if d < 500*time.Millisecond {
-		t.Errorf("expected ~1 second delay; got %v", d)
+	if ci := get(); ci.Reused {
+		t.Error("connection reused after IdleConnTimeout expired")
 	}
-	// Verify no outstanding requests after readLoop/writeLoop
-	// goroutines shut down.
-	for tries := 3; tries > 0; tries-- {
-		n := tr.NumPendingRequestsForTesting()
-		if n == 0 {
-			break
+}
+
+func TestTransportMaxIdleConns(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("hi"))
+	}))
+	defer ts.Close()
+	tr := &Transport{MaxIdleConns: 2}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	// Each host name is a separate idle pool key, all served by ts.
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	for i, host := range []string{"127.0.0.1", "localhost"} {
+		res, err := c.Get("http://" + host + ":" + port + "/")
+		if err != nil {
+			t.Fatal(err)
 		}
-		time.Sleep(100 * time.Millisecond)
-		if tries == 1 {
-			t.Errorf("pending requests = %d; want 0", n)
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		// Wait for the conn to become idle, so the order of
+		// arrival in the pool is known.
+		for deadline := time.Now().Add(5 * time.Second); len(tr.IdleConnKeysForTesting()) != i+1; {
+			if time.Now().After(deadline) {
+				t.Fatalf("conn to %s never became idle", host)
+			}
+			time.Sleep(time.Millisecond)
 		}
 	}
+
+	// A third idle conn evicts the one idle the longest.
+	if !tr.PutIdleTestConn() {
+		t.Fatal("PutIdleTestConn failed")
+	}
+	keys := tr.IdleConnKeysForTesting()
+	sort.Strings(keys)
+	want := []string{"|http|example.com", "|http|localhost:" + port}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("idle conn keys = %q; want %q", keys, want)
+	}
 }
 
-func TestTransportCancelRequestInDial(t *testing.T) {
+func TestTransportMaxConnsPerHost(t *testing.T) {
 	defer afterTest(t)
-	if testing.Short() {
-		t.Skip("skipping test in -short mode")
+	inHandler := make(chan bool)
+	unblock := make(chan bool)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		inHandler <- true
+		<-unblock
+	}))
+	var mu sync.Mutex
+	var conns int
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
 	}
-	var logbuf bytes.Buffer
-	eventLog := log.New(&logbuf, "", 0)
-
-	unblockDial := make(chan bool)
-	defer close(unblockDial)
+	ts.Start()
+	defer ts.Close()
+	tr := &Transport{MaxConnsPerHost: 1}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
 
-	inDial := make(chan bool)
-	tr := &Transport{
-		Dial: func(network, addr string) (net.Conn, error) {
-			eventLog.Println("dial: blocking")
-			inDial <- true
-			<-unblockDial
-			return nil, errors.New("nope")
-		},
+	const n = 3
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			res, err := c.Get(ts.URL)
+			if err == nil {
+				ioutil.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			errc <- err
+		}()
 	}
-	cl := &Client{Transport: tr}
-	gotres := make(chan bool)
-	req, _ := NewRequest("GET", "http://something.no-network.tld/", nil)
-	go func() {
-		_, err := cl.Do(req)
-		eventLog.Printf("Get = %v", err)
-		gotres <- true
-	}()
-
-	select {
-	case <-inDial:
-	case <-time.After(5 * time.Second):
-		t.Fatal("timeout; never saw blocking dial")
+	for i := 0; i < n; i++ {
+		<-inHandler
+		select {
+		case <-inHandler:
+			t.Fatal("two requests in the handler at once")
+		case <-time.After(10 * time.Millisecond):
+		}
+		unblock <- true
 	}
-
-	eventLog.Printf("canceling")
-	tr.CancelRequest(req)
-
-	select {
-	case <-gotres:
-	case <-time.After(5 * time.Second):
-		panic("hang. events are: " + logbuf.String())
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
 	}
-
-	got := logbuf.String()
-	want := `dial: blocking
-canceling
-Get = Get http://something.no-network.tld/: net/http: request canceled while waiting for connection
-`
-	if got != want {
-		t.Errorf("Got events:\n%s\nWant:\n%s", got, want)
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("server saw %d connections; want 1", conns)
 	}
 }
 
-// golang.org/issue/3672 -- Client can't close HTTP stream
-// Calling Close on a Response.Body used to just read until EOF.
-// Now it actually closes the TCP connection.
-func TestTransportCloseResponseBody(t *testing.T) {
+func TestTransportMaxConnLifetime(t *testing.T) {
 	defer afterTest(t)
-	writeErr := make(chan error, 1)
-	msg := []byte("young\n")
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
 	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
-		for {
-			_, err := w.Write(msg)
+		w.Write([]byte("hi"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		tr      *Transport
+		advance time.Duration // before each request
+		want    []bool        // Reused for each request
+	}{
+		{&Transport{MaxConnLifetime: time.Minute}, 40 * time.Second, []bool{false, true, false, true}},
+		{&Transport{MaxConnRequests: 2}, 0, []bool{false, true, false, true, false}},
+	}
+	for i, tt := range tests {
+		c := &Client{Transport: tt.tr}
+		var got []bool
+		for range tt.want {
+			clock.Advance(tt.advance)
+			res, err := c.Get(ts.URL)
 			if err != nil {
-				writeErr <- err
-				return
+				t.Fatal(err)
+			}
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			got = append(got, res.ConnInfo.Reused)
+			// Let the conn return to the pool, or be closed.
+			for deadline := time.Now().Add(5 * time.Second); tt.tr.NumPendingRequestsForTesting() != 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("request never finished")
+				}
+				time.Sleep(time.Millisecond)
 			}
-			w.(Flusher).Flush()
 		}
-	}))
-	defer ts.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d. Reused = %v; want %v", i, got, tt.want)
+		}
+		tt.tr.CloseIdleConnections()
+	}
+}
 
-	tr := &Transport{}
-	defer tr.CloseIdleConnections()
-	c := &Client{Transport: tr}
+// Tests that a request failing because the server closed a kept-alive
+// connection on receiving it is retried on a new connection if its
+// method is idempotent, but not if it has a body or isn't.
+func TestTransportRetryOnStaleConn(t *testing.T) {
+	defer afterTest(t)
+	for _, tt := range []struct {
+		method, body string
+		wantRetry    bool
+	}{
+		{"GET", "", true},
+		{"DELETE", "", true},
+		{"POST", "", false},
+		{"POST", "data", false},
+	} {
+		ln := newLocalListener(t)
+		go func() {
+			for n := 1; ; n++ {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func(c net.Conn, n int) {
+					defer c.Close()
+					br := bufio.NewReader(c)
+					for i := 0; ; i++ {
+						req, err := ReadRequest(br)
+						if err != nil {
+							return
+						}
+						io.Copy(ioutil.Discard, req.Body)
+						if n == 1 && i == 1 {
+							// Hang up on the second request
+							// on the first connection.
+							return
+						}
+						fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nconn %d", n)
+					}
+				}(c, n)
+			}
+		}()
 
-	req, _ := NewRequest("GET", ts.URL, nil)
-	defer tr.CancelRequest(req)
+		tr := &Transport{}
+		c := &Client{Transport: tr}
+		get := func() (string, error) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, _ := NewRequest(tt.method, "http://"+ln.Addr().String()+"/", body)
+			res, err := c.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer res.Body.Close()
+			slurp, err := ioutil.ReadAll(res.Body)
+			return string(slurp), err
+		}
+		if got, err := get(); err != nil || got != "conn 1" {
+			t.Fatalf("%s: first request = %q, %v; want \"conn 1\"", tt.method, got, err)
+		}
+		got, err := get()
+		if tt.wantRetry {
+			if err != nil || got != "conn 2" {
+				t.Errorf("%s: second request = %q, %v; want \"conn 2\"", tt.method, got, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: second request succeeded with %q; want error", tt.method, got)
+		}
+		tr.CloseIdleConnections()
+		ln.Close()
+	}
+}
 
-	res, err := c.Do(req)
+func TestTransportDialError(t *testing.T) {
+	defer afterTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
+	addr := ln.Addr().String()
+	ln.Close()
 
-	const repeats = 3
-	buf := make([]byte, len(msg)*repeats)
-	want := bytes.Repeat(msg, repeats)
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	_, err = c.Get("http://" + addr + "/")
+	ue, ok := err.(*url.Error)
+	if !ok {
+		t.Fatalf("Get error = %#v; want *url.Error", err)
+	}
+	de, ok := ue.Err.(*DialError)
+	if !ok {
+		t.Fatalf("Get error cause = %#v; want *DialError", ue.Err)
+	}
+	if de.Addr != addr || de.Proxy != nil {
+		t.Errorf("DialError Addr, Proxy = %q, %v; want %q, nil", de.Addr, de.Proxy, addr)
+	}
+	if ue.Timeout() {
+		t.Error("refused connection reported as a timeout")
+	}
+}
 
-	_, err = io.ReadFull(res.Body, buf)
-	if err != nil {
-		t.Fatal(err)
+func TestTransportDialTimeout(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	// A deadline already past by the time the dial starts times
+	// out without waiting on the network.
+	tr := &Transport{DialTimeout: time.Nanosecond}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	_, err := c.Get(ts.URL)
+	ue, ok := err.(*url.Error)
+	if !ok {
+		t.Fatalf("Get error = %#v; want *url.Error", err)
 	}
-	if !bytes.Equal(buf, want) {
-		t.Fatalf("read %q; want %q", buf, want)
+	if de, ok := ue.Err.(*DialError); !ok || !de.Timeout() {
+		t.Errorf("Get error cause = %#v; want *DialError reporting a timeout", ue.Err)
 	}
-	didClose := make(chan error, 1)
+	if !ue.Timeout() {
+		t.Error("url.Error does not report a timeout")
+	}
+}
+
+func TestTransportDialTimeoutLookupFakeClock(t *testing.T) {
+	defer afterTest(t)
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+	looking := make(chan bool)
+	unblock := make(chan bool)
+	defer close(unblock)
+	defer SetDialHooks(func(host string) ([]net.IP, error) {
+		looking <- true
+		<-unblock
+		return nil, errors.New("lookup abandoned")
+	}, nil)()
+
+	tr := &Transport{DialTimeout: time.Minute}
+	defer tr.CloseIdleConnections()
+	errc := make(chan error, 1)
 	go func() {
-		didClose <- res.Body.Close()
+		res, err := tr.RoundTrip(&Request{Method: "GET", URL: &url.URL{Scheme: "http", Host: "slow.example"}, Header: Header{}})
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
 	}()
+	<-looking
+	clock.Advance(time.Minute)
 	select {
-	case err := <-didClose:
-		if err != nil {
-			t.Errorf("Close = %v", err)
+	case err := <-errc:
+		if de, ok := err.(*DialError); !ok || !de.Timeout() {
+			t.Errorf("RoundTrip error = %#v; want *DialError reporting a timeout", err)
 		}
-	case <-time.After(10 * time.Second):
-		t.Fatal("too long waiting for close")
+	case <-time.After(5 * time.Second):
+		t.Fatal("DNS lookup did not time out after advancing the clock")
 	}
-	select {
-	case err := <-writeErr:
-		if err == nil {
-			t.Errorf("expected non-nil write error")
-		}
-	case <-time.After(10 * time.Second):
-		t.Fatal("too long waiting for write error")
+}
+
+// The dial itself is held to DialTimeout by the real clock, whatever
+// the clock hooks say.
+func TestTransportDialTimeoutRealClock(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	clock := newFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer clock.install()()
+
+	tr := &Transport{DialTimeout: time.Minute}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
 	}
+	res.Body.Close()
 }
 
-type fooProto struct{}
+func TestTransportFallbackDelay(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
 
-func (fooProto) RoundTrip(req *Request) (*Response, error) {
-	res := &Response{
-		Status:     "200 OK",
-		StatusCode: 200,
-		Header:     make(Header),
-		Body:       ioutil.NopCloser(strings.NewReader("You wanted " + req.URL.String())),
+	for _, tt := range []struct {
+		delay    time.Duration
+		v6Hangs  bool
+		wantDial string
+	}{
+		// IPv6 hangs; IPv4 is tried after the delay.
+		{10 * time.Millisecond, true, "[2001:db8::1]:80 127.0.0.2:80"},
+		// IPv6 is refused; IPv4 is tried without waiting.
+		{time.Hour, false, "[2001:db8::1]:80 127.0.0.2:80"},
+		// No racing.
+		{-1, false, "[2001:db8::1]:80 127.0.0.2:80"},
+	} {
+		var (
+			mu      sync.Mutex
+			dials   []string
+			unblock = make(chan bool)
+		)
+		restore := SetDialHooks(func(host string) ([]net.IP, error) {
+			if host != "dual.test" {
+				t.Errorf("lookup of %q", host)
+			}
+			return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("127.0.0.2")}, nil
+		}, func(addr string) (net.Conn, error) {
+			mu.Lock()
+			dials = append(dials, addr)
+			mu.Unlock()
+			if addr == "127.0.0.2:80" {
+				return net.Dial("tcp", ts.Listener.Addr().String())
+			}
+			if tt.v6Hangs {
+				<-unblock
+			}
+			return nil, errors.New("unreachable")
+		})
+		tr := &Transport{FallbackDelay: tt.delay}
+		res, err := (&Client{Transport: tr}).Get("http://dual.test/")
+		if err != nil {
+			t.Errorf("FallbackDelay %v: %v", tt.delay, err)
+		} else {
+			res.Body.Close()
+		}
+		close(unblock)
+		tr.CloseIdleConnections()
+		restore()
+		mu.Lock()
+		if got := strings.Join(dials, " "); got != tt.wantDial {
+			t.Errorf("FallbackDelay %v: dialed %s; want %s", tt.delay, got, tt.wantDial)
+		}
+		mu.Unlock()
 	}
-	return res, nil
 }
 
-func TestTransportAltProto(t *testing.T) {
+type countingResolver struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (r *countingResolver) LookupIP(host string) ([]net.IP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+	return []net.IP{net.ParseIP("127.0.0.2")}, nil
+}
+
+func (r *countingResolver) lookups() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+func TestTransportDNSCache(t *testing.T) {
 	defer afterTest(t)
-	tr := &Transport{}
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+	defer SetDialHooks(func(host string) ([]net.IP, error) {
+		t.Errorf("system resolver used for %q", host)
+		return nil, errors.New("unexpected lookup")
+	}, func(addr string) (net.Conn, error) {
+		return net.Dial("tcp", ts.Listener.Addr().String())
+	})()
+
+	r := new(countingResolver)
+	tr := &Transport{Resolver: r, DNSCacheTTL: time.Minute, DisableKeepAlives: true}
 	c := &Client{Transport: tr}
-	tr.RegisterProtocol("foo", fooProto{})
-	res, err := c.Get("foo://bar.com/path")
-	if err != nil {
-		t.Fatal(err)
+	get := func() {
+		res, err := c.Get("http://cached.test/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
 	}
-	bodyb, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		t.Fatal(err)
+
+	get()
+	get()
+	if n := r.lookups(); n != 1 {
+		t.Errorf("after two requests, %d lookups; want 1", n)
 	}
-	body := string(bodyb)
-	if e := "You wanted foo://bar.com/path"; body != e {
-		t.Errorf("got response %q, want %q", body, e)
+	clock.Advance(2 * time.Minute)
+	get()
+	if n := r.lookups(); n != 2 {
+		t.Errorf("after TTL expired, %d lookups; want 2", n)
+	}
+	tr.FlushDNSCache()
+	get()
+	if n := r.lookups(); n != 3 {
+		t.Errorf("after FlushDNSCache, %d lookups; want 3", n)
 	}
 }
 
-func TestTransportNoHost(t *testing.T) {
+func TestTransportStats(t *testing.T) {
 	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
 	tr := &Transport{}
-	_, err := tr.RoundTrip(&Request{
-		Header: make(Header),
-		URL: &url.URL{
-			Scheme: "http",
-		},
-	})
-	want := "http: no Host in request URL"
-	if got := fmt.Sprint(err); got != want {
-		t.Errorf("error = %v; want %q", err, want)
+	c := &Client{Transport: tr}
+	for i := 0; i < 3; i++ {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	tr.CloseIdleConnections()
+
+	st := tr.Stats()
+	if st.BytesRead <= 3*int64(len("hello")) || st.BytesWritten <= 0 {
+		t.Errorf("BytesRead, BytesWritten = %d, %d; want more than the response bodies and positive", st.BytesRead, st.BytesWritten)
+	}
+	st.BytesRead, st.BytesWritten = 0, 0
+	want := TransportStats{
+		ConnsOpened: 1,
+		ConnsClosed: 1,
+		Requests:    3,
+		IdleHits:    2,
+		IdleMisses:  1,
+	}
+	if st != want {
+		t.Errorf("Stats = %+v; want %+v", st, want)
 	}
 }
 
-func TestTransportSocketLateBinding(t *testing.T) {
+func TestTransportTLSSessionCache(t *testing.T) {
 	defer afterTest(t)
-
-	mux := NewServeMux()
-	fooGate := make(chan bool, 1)
-	mux.HandleFunc("/foo", func(w ResponseWriter, r *Request) {
-		w.Header().Set("foo-ipport", r.RemoteAddr)
-		w.(Flusher).Flush()
-		<-fooGate
-	})
-	mux.HandleFunc("/bar", func(w ResponseWriter, r *Request) {
-		w.Header().Set("bar-ipport", r.RemoteAddr)
-	})
-	ts := httptest.NewServer(mux)
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
 	defer ts.Close()
 
-	dialGate := make(chan bool, 1)
 	tr := &Transport{
-		Dial: func(n, addr string) (net.Conn, error) {
-			if <-dialGate {
-				return net.Dial(n, addr)
-			}
-			return nil, errors.New("manually closed")
-		},
-		DisableKeepAlives: false,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		TLSSessionCacheSize: 10,
+		DisableKeepAlives:   true, // a new handshake per request
 	}
 	defer tr.CloseIdleConnections()
-	c := &Client{
-		Transport: tr,
-	}
-
-	dialGate <- true // only allow one dial
-	fooRes, err := c.Get(ts.URL + "/foo")
-	if err != nil {
-		t.Fatal(err)
+	c := &Client{Transport: tr}
+	for i := 0; i < 3; i++ {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if want := i > 0; res.TLS.DidResume != want {
+			t.Errorf("request %d: DidResume = %v; want %v", i, res.TLS.DidResume, want)
+		}
 	}
-	fooAddr := fooRes.Header.Get("foo-ipport")
-	if fooAddr == "" {
-		t.Fatal("No addr on /foo request")
+	st := tr.Stats()
+	if st.TLSSessionHits != 2 || st.TLSSessionMisses != 1 {
+		t.Errorf("TLSSessionHits, TLSSessionMisses = %d, %d; want 2, 1", st.TLSSessionHits, st.TLSSessionMisses)
 	}
-	time.AfterFunc(200*time.Millisecond, func() {
-		// let the foo response finish so we can use its
-		// connection for /bar
-		fooGate <- true
-		io.Copy(ioutil.Discard, fooRes.Body)
-		fooRes.Body.Close()
-	})
+}
 
-	barRes, err := c.Get(ts.URL + "/bar")
+func TestTransportVerifyPeerCertificate(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	cert, err := x509.ParseCertificate(ts.TLS.Certificates[0].Certificate[0])
 	if err != nil {
 		t.Fatal(err)
 	}
-	barAddr := barRes.Header.Get("bar-ipport")
-	if barAddr != fooAddr {
-		t.Fatalf("/foo came from conn %q; /bar came from %q instead", fooAddr, barAddr)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	errPin := errors.New("certificate not pinned")
+	for _, insecure := range []bool{false, true} {
+		var calls int
+		pinned := cert
+		tr := &Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, InsecureSkipVerify: insecure},
+			VerifyPeerCertificate: func(serverName string, certs []*x509.Certificate, verifiedChains [][]*x509.Certificate) error {
+				calls++
+				if serverName != "127.0.0.1" {
+					t.Errorf("serverName = %q; want 127.0.0.1", serverName)
+				}
+				if (verifiedChains == nil) != insecure {
+					t.Errorf("insecure=%v: verifiedChains = %v", insecure, verifiedChains)
+				}
+				if pinned == nil || len(certs) == 0 || !certs[0].Equal(pinned) {
+					return errPin
+				}
+				return nil
+			},
+		}
+		c := &Client{Transport: tr}
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("insecure=%v: %v", insecure, err)
+		}
+		res.Body.Close()
+		tr.CloseIdleConnections()
+
+		pinned = nil
+		_, err = c.Get(ts.URL)
+		if ue, ok := err.(*url.Error); !ok {
+			t.Errorf("insecure=%v: unpinned Get error = %v; want *url.Error", insecure, err)
+		} else if te, ok := ue.Err.(*TLSError); !ok || te.Err != errPin || te.ServerName != "127.0.0.1" {
+			t.Errorf("insecure=%v: unpinned Get error cause = %#v; want *TLSError for 127.0.0.1 wrapping %v", insecure, ue.Err, errPin)
+		}
+		if calls != 2 {
+			t.Errorf("insecure=%v: VerifyPeerCertificate called %d times; want 2", insecure, calls)
+		}
 	}
-	barRes.Body.Close()
-	dialGate <- false
 }
 
-// Issue 2184
-func TestTransportReading100Continue(t *testing.T) {
+func TestTransportIdleConns(t *testing.T) {
 	defer afterTest(t)
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {})
+	ts1 := httptest.NewServer(h)
+	defer ts1.Close()
+	ts2 := httptest.NewServer(h)
+	defer ts2.Close()
+	addr1, addr2 := ts1.Listener.Addr().String(), ts2.Listener.Addr().String()
 
-	const numReqs = 5
-	reqBody := func(n int) string { return fmt.Sprintf("request body %d", n) }
-	reqID := func(n int) string { return fmt.Sprintf("REQ-ID-%d", n) }
-
-	send100Response := func(w *io.PipeWriter, r *io.PipeReader) {
-		defer w.Close()
-		defer r.Close()
-		br := bufio.NewReader(r)
-		n := 0
-		for {
-			n++
-			req, err := ReadRequest(br)
-			if err == io.EOF {
-				return
-			}
-			if err != nil {
-				t.Error(err)
-				return
-			}
-			slurp, err := ioutil.ReadAll(req.Body)
-			if err != nil {
-				t.Errorf("Server request body slurp: %v", err)
-				return
-			}
-			id := req.Header.Get("Request-Id")
-			resCode := req.Header.Get("X-Want-Response-Code")
-			if resCode == "" {
-				resCode = "100 Continue"
-				if string(slurp) != reqBody(n) {
-					t.Errorf("Server got %q, %v; want %q", slurp, err, reqBody(n))
-				}
-			}
-			body := fmt.Sprintf("Response number %d", n)
-			v := []byte(strings.Replace(fmt.Sprintf(`HTTP/1.1 %s
-Date: Thu, 28 Feb 2013 17:55:41 GMT
-
-HTTP/1.1 200 OK
-Content-Type: text/html
-Echo-Request-Id: %s
-Content-Length: %d
-
-%s`, resCode, id, len(body), body), "\n", "\r\n", -1))
-			w.Write(v)
-			if id == reqID(numReqs) {
-				return
-			}
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	get := func(url string) {
+		res, err := c.Get(url)
+		if err != nil {
+			t.Fatal(err)
 		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	get(ts1.URL)
+	clock.Advance(time.Second)
+	get(ts2.URL)
+	clock.Advance(time.Second)
+	get(ts1.URL)
+	clock.Advance(time.Second)
 
+	want := []IdleConnInfo{
+		{Scheme: "http", Addr: addr1, Age: 3 * time.Second, IdleTime: time.Second, Requests: 2},
+		{Scheme: "http", Addr: addr2, Age: 2 * time.Second, IdleTime: 2 * time.Second, Requests: 1},
+	}
+	if got := tr.IdleConns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("IdleConns = %+v; want %+v", got, want)
 	}
 
-	tr := &Transport{
-		Dial: func(n, addr string) (net.Conn, error) {
-			sr, sw := io.Pipe() // server read/write
-			cr, cw := io.Pipe() // client read/write
-			conn := &rwTestConn{
-				Reader: cr,
-				Writer: sw,
-				closeFunc: func() error {
-					sw.Close()
-					cw.Close()
-					return nil
-				},
-			}
-			go send100Response(cw, sr)
-			return conn, nil
-		},
-		DisableKeepAlives: false,
+	tr.CloseIdleConnectionsFor(addr1)
+	if got := tr.IdleConns(); len(got) != 1 || got[0].Addr != addr2 {
+		t.Errorf("after CloseIdleConnectionsFor(%q), IdleConns = %+v; want only %s", addr1, got, addr2)
 	}
-	defer tr.CloseIdleConnections()
-	c := &Client{Transport: tr}
+	host, _, _ := net.SplitHostPort(addr2)
+	tr.CloseIdleConnectionsFor(host)
+	if got := tr.IdleConns(); len(got) != 0 {
+		t.Errorf("after CloseIdleConnectionsFor(%q), IdleConns = %+v; want none", host, got)
+	}
+}
 
-	testResponse := func(req *Request, name string, wantCode int) {
-		res, err := c.Do(req)
+func TestTransportSharedConnPool(t *testing.T) {
+	defer afterTest(t)
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	pool := new(ConnPool)
+	tr1 := &Transport{ConnPool: pool}
+	tr2 := &Transport{ConnPool: pool, DisableCompression: true}
+	defer tr1.CloseIdleConnections()
+	for i, tr := range []*Transport{tr1, tr2, tr1} {
+		res, err := (&Client{Transport: tr}).Get(ts.URL)
 		if err != nil {
-			t.Fatalf("%s: Do: %v", name, err)
+			t.Fatal(err)
 		}
-		if res.StatusCode != wantCode {
-			t.Fatalf("%s: Response Statuscode=%d; want %d", name, res.StatusCode, wantCode)
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		// The request is forgotten by the Transport it was
+		// made on, whichever dialed the conn.
+		for deadline := time.Now().Add(5 * time.Second); tr.NumPendingRequestsForTesting() != 0; {
+			if time.Now().After(deadline) {
+				t.Fatalf("request %d never finished", i)
+			}
+			time.Sleep(time.Millisecond)
 		}
-		if id, idBack := req.Header.Get("Request-Id"), res.Header.Get("Echo-Request-Id"); id != "" && id != idBack {
-			t.Errorf("%s: response id %q != request id %q", name, idBack, id)
+		if want := i > 0; res.ConnInfo.Reused != want {
+			t.Errorf("request %d: Reused = %v; want %v", i, res.ConnInfo.Reused, want)
 		}
-		_, err = ioutil.ReadAll(res.Body)
-		if err != nil {
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("server saw %d connections; want 1", conns)
+	}
+	if got := len(tr2.IdleConns()); got != 1 {
+		t.Errorf("%d idle conns in shared pool; want 1", got)
+	}
+}
+
+// Transports sharing a ConnPool share https conns only if they set
+// them up alike, and each request gets the limits of the Transport
+// it was made on.
+func TestTransportSharedConnPoolSettings(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "0123456789")
+	}))
+	defer ts.Close()
+
+	pool := new(ConnPool)
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	get := func(tr *Transport) (reused bool, err error) {
+		res, err := (&Client{Transport: tr}).Get(ts.URL)
+		if err != nil {
+			return false, err
+		}
+		_, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res.ConnInfo.Reused, err
+	}
+	tr := &Transport{ConnPool: pool, TLSClientConfig: cfg}
+	defer tr.CloseIdleConnections()
+	for _, tt := range []struct {
+		name      string
+		tr        *Transport
+		wantReuse bool
+		wantErr   bool
+	}{
+		{"same config", &Transport{ConnPool: pool, TLSClientConfig: cfg}, true, false},
+		{"other config", &Transport{ConnPool: pool, TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, false, false},
+		{"VerifyPeerCertificate", &Transport{ConnPool: pool, TLSClientConfig: cfg,
+			VerifyPeerCertificate: func(string, []*x509.Certificate, [][]*x509.Certificate) error { return nil }}, false, false},
+		{"MaxResponseBodyBytes", &Transport{ConnPool: pool, TLSClientConfig: cfg, MaxResponseBodyBytes: 5}, true, true},
+		{"MaxResponseHeaderBytes", &Transport{ConnPool: pool, TLSClientConfig: cfg, MaxResponseHeaderBytes: 10}, false, true},
+	} {
+		if _, err := get(tr); err != nil {
+			t.Fatalf("%s: priming the pool: %v", tt.name, err)
+		}
+		reused, err := get(tt.tr)
+		if reused != tt.wantReuse || (err != nil) != tt.wantErr {
+			t.Errorf("%s: reused = %v, err = %v; want reused %v, error %v", tt.name, reused, err, tt.wantReuse, tt.wantErr)
+		}
+	}
+}
+
+func TestTransportPreconnect(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+
+	tr := &Transport{MaxConnsPerHost: 3, MaxIdleConnsPerHost: 5}
+	defer tr.CloseIdleConnections()
+	if err := tr.Preconnect("http", addr, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.IdleConnCountForTesting("|http|" + addr); got != 3 {
+		t.Errorf("%d idle conns after Preconnect; want 3 (MaxConnsPerHost)", got)
+	}
+	for i := 0; i < 3; i++ {
+		res, err := (&Client{Transport: tr}).Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	if st := tr.Stats(); st.ConnsOpened != 3 || st.IdleHits != 3 {
+		t.Errorf("ConnsOpened, IdleHits = %d, %d; want 3, 3", st.ConnsOpened, st.IdleHits)
+	}
+
+	if err := tr.Preconnect("ftp", addr, 1); err == nil {
+		t.Error("Preconnect with scheme ftp succeeded")
+	}
+	if err := tr.Preconnect("http", addr, -1); err == nil {
+		t.Error("Preconnect of -1 connections succeeded")
+	}
+}
+
+// Tests that with MaxPipelineDepth, a GET is sent on a connection
+// still awaiting an earlier response: the server's first connection
+// answers nothing until it has read two requests.
+func TestTransportPipelining(t *testing.T) {
+	defer afterTest(t)
+	for _, closeFirst := range []bool{false, true} {
+		ln := newLocalListener(t)
+		gotFirst := make(chan bool, 1)
+		go func() {
+			for n := 1; ; n++ {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func(c net.Conn, n int) {
+					defer c.Close()
+					br := bufio.NewReader(c)
+					var paths []string
+					for {
+						req, err := ReadRequest(br)
+						if err != nil {
+							return
+						}
+						if n > 1 {
+							// Don't let the conn be pooled, so
+							// that /b finds no idle conn.
+							fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: %d\r\n\r\nconn %d %s", 7+len(req.URL.Path), n, req.URL.Path)
+							return
+						}
+						paths = append(paths, req.URL.Path)
+						if len(paths) == 1 {
+							gotFirst <- true
+							continue
+						}
+						for i, path := range paths {
+							conn := ""
+							if i == 0 && closeFirst {
+								conn = "Connection: close\r\n"
+							}
+							fmt.Fprintf(c, "HTTP/1.1 200 OK\r\n%sContent-Length: %d\r\n\r\nconn 1 %s", conn, 7+len(path), path)
+							if conn != "" {
+								return
+							}
+						}
+					}
+				}(c, n)
+			}
+		}()
+
+		tr := &Transport{MaxPipelineDepth: 2}
+		c := &Client{Transport: tr}
+		get := func(method, path string) string {
+			req, _ := NewRequest(method, "http://"+ln.Addr().String()+path, nil)
+			res, err := c.Do(req)
+			if err != nil {
+				return err.Error()
+			}
+			defer res.Body.Close()
+			slurp, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				return err.Error()
+			}
+			return string(slurp)
+		}
+		resa := make(chan string, 1)
+		go func() { resa <- get("GET", "/a") }()
+		<-gotFirst
+
+		// A POST isn't pipelined, so it gets a new connection.
+		if got, want := get("POST", "/post"), "conn 2 /post"; got != want {
+			t.Errorf("closeFirst=%v: POST = %q; want %q", closeFirst, got, want)
+		}
+		wantb := "conn 1 /b"
+		if closeFirst {
+			// /b is retried on a new connection.
+			wantb = "conn 3 /b"
+		}
+		// /b is pipelined behind /a: DELETE, like GET, is
+		// idempotent.
+		if got := get("DELETE", "/b"); got != wantb {
+			t.Errorf("closeFirst=%v: /b = %q; want %q", closeFirst, got, wantb)
+		}
+		if got, want := <-resa, "conn 1 /a"; got != want {
+			t.Errorf("closeFirst=%v: /a = %q; want %q", closeFirst, got, want)
+		}
+		tr.CloseIdleConnections()
+		ln.Close()
+	}
+}
+
+// lineRoundTripper speaks a toy protocol negotiated by TLSNextProto:
+// it sends the request path on a line and reads the response body on
+// the next.
+type lineRoundTripper struct {
+	mu sync.Mutex
+	c  *tls.Conn
+	br *bufio.Reader
+}
+
+func (rt *lineRoundTripper) RoundTrip(req *Request) (*Response, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if _, err := io.WriteString(rt.c, req.URL.Path+"\n"); err != nil {
+		return nil, err
+	}
+	line, err := rt.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		StatusCode: 200,
+		Proto:      "LINE",
+		Header:     make(Header),
+		Body:       ioutil.NopCloser(strings.NewReader(line)),
+		Request:    req,
+	}, nil
+}
+
+func (rt *lineRoundTripper) CloseIdleConnections() {
+	rt.c.Close()
+}
+
+func TestTransportTLSNextProto(t *testing.T) {
+	defer afterTest(t)
+	lineTS := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		t.Errorf("unexpected HTTP/1.1 request for %s", r.URL.Path)
+	}))
+	lineTS.TLS = &tls.Config{NextProtos: []string{"test-line", "http/1.1"}}
+	lineTS.Config.TLSNextProto = map[string]func(*Server, *tls.Conn, Handler){
+		"test-line": func(_ *Server, c *tls.Conn, _ Handler) {
+			br := bufio.NewReader(c)
+			for {
+				path, err := br.ReadString('\n')
+				if err != nil {
+					return
+				}
+				io.WriteString(c, "line "+path)
+			}
+		},
+	}
+	lineTS.StartTLS()
+	defer lineTS.Close()
+	httpTS := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Proto+" "+r.URL.Path)
+	}))
+	defer httpTS.Close()
+
+	var authorities []string
+	tr := &Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSNextProto: map[string]func(string, *tls.Conn) RoundTripper{
+			"test-line": func(authority string, c *tls.Conn) RoundTripper {
+				authorities = append(authorities, authority)
+				return &lineRoundTripper{c: c, br: bufio.NewReader(c)}
+			},
+		},
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	get := func(url, want string) {
+		res, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("Get %s: %v", url, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", url, err)
+		}
+		if got := strings.TrimSpace(string(body)); got != want {
+			t.Errorf("Get %s = %q; want %q", url, got, want)
+		}
+	}
+	get(lineTS.URL+"/a", "line /a")
+	get(lineTS.URL+"/b", "line /b")
+	get(httpTS.URL+"/c", "HTTP/1.1 /c") // not offered; falls back
+
+	if want := []string{lineTS.Listener.Addr().String()}; !reflect.DeepEqual(authorities, want) {
+		t.Errorf("TLSNextProto func called for %q; want once, for %q", authorities, want)
+	}
+
+	// Only one of two conns switching protocols is kept.
+	tr2 := &Transport{
+		TLSClientConfig: tr.TLSClientConfig,
+		TLSNextProto:    tr.TLSNextProto,
+	}
+	if err := tr2.Preconnect("https", lineTS.Listener.Addr().String(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if st := tr2.Stats(); st.ConnsOpened != 2 || st.ConnsClosed != 1 {
+		t.Errorf("after Preconnect of 2: ConnsOpened, ConnsClosed = %d, %d; want 2, 1", st.ConnsOpened, st.ConnsClosed)
+	}
+	c = &Client{Transport: tr2}
+	get(lineTS.URL+"/d", "line /d")
+}
+
+func TestTransportSPDY(t *testing.T) {
+	defer afterTest(t)
+	spdyTS := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		t.Errorf("unexpected HTTP/1.1 request for %s", r.URL.Path)
+	}))
+	spdyTS.TLS = &tls.Config{NextProtos: []string{"spdy/3.1", "http/1.1"}}
+	spdyTS.Config.TLSNextProto = map[string]func(*Server, *tls.Conn, Handler){
+		"spdy/3.1": func(_ *Server, c *tls.Conn, _ Handler) { ServeSPDYEcho(c) },
+	}
+	spdyTS.StartTLS()
+	defer spdyTS.Close()
+	httpTS := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Proto+" "+r.URL.Path)
+	}))
+	defer httpTS.Close()
+
+	tr := &Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		EnableSPDY:      true,
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	get := func(url, want string) error {
+		res, err := c.Get(url)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if res.TLS == nil {
+			return fmt.Errorf("Get %s: Response.TLS is nil", url)
+		}
+		if string(body) != want {
+			return fmt.Errorf("Get %s = %q; want %q", url, body, want)
+		}
+		return nil
+	}
+	if err := get(spdyTS.URL+"/a", "spdy /a"); err != nil {
+		t.Fatal(err)
+	}
+	// Once a SPDY conn is up, concurrent requests share it.
+	errc := make(chan error)
+	for i := 0; i < 5; i++ {
+		path := fmt.Sprintf("/c%d", i)
+		go func() { errc <- get(spdyTS.URL+path, "spdy "+path) }()
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}
+	if st := tr.Stats(); st.ConnsOpened != 1 {
+		t.Errorf("ConnsOpened = %d; want 1", st.ConnsOpened)
+	}
+	if err := get(httpTS.URL+"/h", "HTTP/1.1 /h"); err != nil { // not offered; falls back
+		t.Error(err)
+	}
+}
+
+func TestTransportConnSetup(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	var setups []string
+	errSetup := errors.New("setup failed")
+	var fail bool
+	tr := &Transport{ConnSetup: func(c net.Conn) error {
+		tc, ok := c.(*net.TCPConn)
+		if !ok {
+			t.Errorf("ConnSetup got %T; want *net.TCPConn", c)
+		} else if err := tc.SetKeepAlive(true); err != nil {
+			t.Errorf("SetKeepAlive: %v", err)
+		}
+		setups = append(setups, c.RemoteAddr().String())
+		if fail {
+			return errSetup
+		}
+		return nil
+	}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	if want := []string{ts.Listener.Addr().String()}; !reflect.DeepEqual(setups, want) {
+		t.Errorf("ConnSetup called for %q; want once, for %q", setups, want)
+	}
+
+	fail = true
+	tr.CloseIdleConnections()
+	_, err := c.Get(ts.URL)
+	if ue, ok := err.(*url.Error); !ok || ue.Err != errSetup {
+		t.Errorf("Get with failing ConnSetup: %v; want %v", err, errSetup)
+	}
+}
+
+type staticResolver []net.IP
+
+func (r staticResolver) LookupIP(host string) ([]net.IP, error) { return r, nil }
+
+func TestTransportLocalAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("skipping; binding to 127.0.0.2 requires Linux")
+	}
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	tr := &Transport{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")},
+		// An IPv6 address can't be reached from LocalAddr, so
+		// only the IPv4 one is dialed.
+		Resolver:      staticResolver{net.ParseIP("::1"), net.ParseIP("127.0.0.1")},
+		FallbackDelay: -1,
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for _, url := range []string{ts.URL, "http://local.test:" + port} {
+		res, err := c.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slurp, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host, _, _ := net.SplitHostPort(string(slurp)); host != "127.0.0.2" {
+			t.Errorf("Get %s: server saw RemoteAddr %s; want host 127.0.0.2", url, slurp)
+		}
+	}
+
+	tr.LocalAddr = &net.TCPAddr{IP: net.ParseIP("::1")}
+	tr.Resolver = staticResolver{net.ParseIP("127.0.0.1")}
+	if res, err := c.Get("http://other.test:" + port); err == nil {
+		res.Body.Close()
+		t.Error("Get with no address of LocalAddr's family succeeded")
+	}
+}
+
+func TestTransportUnixSocket(t *testing.T) {
+	switch runtime.GOOS {
+	case "nacl", "plan9", "windows":
+		t.Skipf("skipping on %s; no Unix domain sockets", runtime.GOOS)
+	}
+	defer afterTest(t)
+	dir, err := ioutil.TempDir("", "http-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := dir + "/sock"
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Host+r.URL.Path)
+	})}).Serve(ln)
+
+	tr := &Transport{UnixSocket: sock}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for i, want := range []bool{false, true} {
+		res, err := c.Get("http://daemon/v1/info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slurp, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(slurp) != "daemon/v1/info" {
+			t.Errorf("request %d: got %q, %v; want %q", i, slurp, err, "daemon/v1/info")
+		}
+		if res.ConnInfo.Reused != want {
+			t.Errorf("request %d: Reused = %v; want %v", i, res.ConnInfo.Reused, want)
+		}
+	}
+}
+
+func TestTransportResponseHeaderTimeoutFakeClock(t *testing.T) {
+	defer afterTest(t)
+	clock := newFakeClock(time.Now())
+	defer clock.install()()
+	unblock := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	tr := &Transport{ResponseHeaderTimeout: time.Hour}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	errc := make(chan error, 1)
+	go func() {
+		res, err := c.Get(ts.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Hour)
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+			t.Errorf("Get error = %v; want response header timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout did not fire after advancing the clock")
+	}
+}
+
+func TestTransportResponseHeaderTimeout(t *testing.T) {
+	defer afterTest(t)
+	if testing.Short() {
+		t.Skip("skipping timeout test in -short mode")
+	}
+	inHandler := make(chan bool, 1)
+	mux := NewServeMux()
+	mux.HandleFunc("/fast", func(w ResponseWriter, r *Request) {
+		inHandler <- true
+	})
+	mux.HandleFunc("/slow", func(w ResponseWriter, r *Request) {
+		inHandler <- true
+		time.Sleep(2 * time.Second)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tr := &Transport{
+		ResponseHeaderTimeout: 500 * time.Millisecond,
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	tests := []struct {
+		path    string
+		want    int
+		wantErr string
+	}{
+		{path: "/fast", want: 200},
+		{path: "/slow", wantErr: "timeout awaiting response headers"},
+		{path: "/fast", want: 200},
+	}
+	for i, tt := range tests {
+		res, err := c.Get(ts.URL + tt.path)
+		select {
+		case <-inHandler:
+		case <-time.After(5 * time.Second):
+			t.Errorf("never entered handler for test index %d, %s", i, tt.path)
+			continue
+		}
+		if err != nil {
+			uerr, ok := err.(*url.Error)
+			if !ok {
+				t.Errorf("error is not an url.Error; got: %#v", err)
+				continue
+			}
+			nerr, ok := uerr.Err.(net.Error)
+			if !ok {
+				t.Errorf("error does not satisfy net.Error interface; got: %#v", err)
+				continue
+			}
+			if !nerr.Timeout() {
+				t.Errorf("want timeout error; got: %q", nerr)
+				continue
+			}
+			if strings.Contains(err.Error(), tt.wantErr) {
+				continue
+			}
+			t.Errorf("%d. unexpected error: %v", i, err)
+			continue
+		}
+		if tt.wantErr != "" {
+			t.Errorf("%d. no error. expected error: %v", i, tt.wantErr)
+			continue
+		}
+		if res.StatusCode != tt.want {
+			t.Errorf("%d for path %q status = %d; want %d", i, tt.path, res.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestTransportCancelRequest(t *testing.T) {
+	defer afterTest(t)
+	if testing.Short() {
+		t.Skip("skipping test in -short mode")
+	}
+	unblockc := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "Hello")
+		w.(Flusher).Flush() // send headers and some body
+		<-unblockc
+	}))
+	defer ts.Close()
+	defer close(unblockc)
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	req, _ := NewRequest("GET", ts.URL, nil)
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(1 * time.Second)
+		tr.CancelRequest(req)
+	}()
+	t0 := time.Now()
+	body, err := ioutil.ReadAll(res.Body)
+	d := time.Since(t0)
+
+	if err != ErrRequestCanceled {
+		t.Errorf("ReadAll error = %v; want ErrRequestCanceled", err)
+	}
+	if string(body) != "Hello" {
+		t.Errorf("Body = %q; want Hello", body)
+	}
+	if d < 500*time.Millisecond {
+		t.Errorf("expected ~1 second delay; got %v", d)
+	}
+	// Verify no outstanding requests after readLoop/writeLoop
+	// goroutines shut down.
+	for tries := 3; tries > 0; tries-- {
+		n := tr.NumPendingRequestsForTesting()
+		if n == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+		if tries == 1 {
+			t.Errorf("pending requests = %d; want 0", n)
+		}
+	}
+}
+
+func TestTransportCancelRequestAwaitingHeaders(t *testing.T) {
+	defer afterTest(t)
+	inHandler := make(chan bool)
+	unblockc := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		inHandler <- true
+		<-unblockc
+	}))
+	defer ts.Close()
+	defer close(unblockc)
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	req, _ := NewRequest("GET", ts.URL, nil)
+	go func() {
+		<-inHandler
+		tr.CancelRequest(req)
+	}()
+	res, err := tr.RoundTrip(req)
+	if err != ErrRequestCanceled {
+		if err == nil {
+			res.Body.Close()
+		}
+		t.Errorf("RoundTrip error = %v; want ErrRequestCanceled", err)
+	}
+}
+
+func TestTransportCancelRequestInDial(t *testing.T) {
+	defer afterTest(t)
+	if testing.Short() {
+		t.Skip("skipping test in -short mode")
+	}
+	var logbuf bytes.Buffer
+	eventLog := log.New(&logbuf, "", 0)
+
+	unblockDial := make(chan bool)
+	defer close(unblockDial)
+
+	inDial := make(chan bool)
+	tr := &Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			eventLog.Println("dial: blocking")
+			inDial <- true
+			<-unblockDial
+			return nil, errors.New("nope")
+		},
+	}
+	cl := &Client{Transport: tr}
+	gotres := make(chan bool)
+	req, _ := NewRequest("GET", "http://something.no-network.tld/", nil)
+	var err error
+	go func() {
+		_, err = cl.Do(req)
+		eventLog.Printf("Get = %v", err)
+		gotres <- true
+	}()
+
+	select {
+	case <-inDial:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout; never saw blocking dial")
+	}
+
+	eventLog.Printf("canceling")
+	tr.CancelRequest(req)
+
+	select {
+	case <-gotres:
+	case <-time.After(5 * time.Second):
+		panic("hang. events are: " + logbuf.String())
+	}
+
+	got := logbuf.String()
+	want := `dial: blocking
+canceling
+Get = Get http://something.no-network.tld/: net/http: request canceled
+`
+	if got != want {
+		t.Errorf("Got events:\n%s\nWant:\n%s", got, want)
+	}
+	if ue, ok := err.(*url.Error); !ok || ue.Err != ErrRequestCanceled {
+		t.Errorf("Get error = %#v; want *url.Error wrapping ErrRequestCanceled", err)
+	}
+}
+
+func TestTransportCancelRequestWaitingForConn(t *testing.T) {
+	defer afterTest(t)
+	inHandler := make(chan bool)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		inHandler <- true
+		<-unblock
+	}))
+	defer ts.Close()
+	tr := &Transport{MaxConnsPerHost: 1}
+	defer tr.CloseIdleConnections()
+
+	firstDone := make(chan bool)
+	go func() {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		firstDone <- true
+	}()
+	<-inHandler
+
+	// The second request waits for the first's conn.
+	req, _ := NewRequest("GET", ts.URL, nil)
+	errc := make(chan error, 1)
+	go func() {
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
+	}()
+	for tr.NumPendingRequestsForTesting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	tr.CancelRequest(req)
+	select {
+	case err := <-errc:
+		if err != ErrRequestCanceled {
+			t.Errorf("RoundTrip error = %v; want ErrRequestCanceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timeout waiting for canceled RoundTrip")
+	}
+	unblock <- true
+	<-firstDone
+}
+
+// golang.org/issue/3672 -- Client can't close HTTP stream
+// Calling Close on a Response.Body used to just read until EOF.
+// Now it actually closes the TCP connection.
+func TestTransportCloseResponseBody(t *testing.T) {
+	defer afterTest(t)
+	writeErr := make(chan error, 1)
+	msg := []byte("young\n")
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		for {
+			_, err := w.Write(msg)
+			if err != nil {
+				writeErr <- err
+				return
+			}
+			w.(Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	req, _ := NewRequest("GET", ts.URL, nil)
+	defer tr.CancelRequest(req)
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const repeats = 3
+	buf := make([]byte, len(msg)*repeats)
+	want := bytes.Repeat(msg, repeats)
+
+	_, err = io.ReadFull(res.Body, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, want) {
+		t.Fatalf("read %q; want %q", buf, want)
+	}
+	didClose := make(chan error, 1)
+	go func() {
+		didClose <- res.Body.Close()
+	}()
+	select {
+	case err := <-didClose:
+		if err != nil {
+			t.Errorf("Close = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("too long waiting for close")
+	}
+	select {
+	case err := <-writeErr:
+		if err == nil {
+			t.Errorf("expected non-nil write error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("too long waiting for write error")
+	}
+}
+
+type fooProto struct{}
+
+func (fooProto) RoundTrip(req *Request) (*Response, error) {
+	res := &Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     make(Header),
+		Body:       ioutil.NopCloser(strings.NewReader("You wanted " + req.URL.String())),
+	}
+	return res, nil
+}
+
+func TestTransportAltProto(t *testing.T) {
+	defer afterTest(t)
+	tr := &Transport{}
+	c := &Client{Transport: tr}
+	tr.RegisterProtocol("foo", fooProto{})
+	res, err := c.Get("foo://bar.com/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyb, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(bodyb)
+	if e := "You wanted foo://bar.com/path"; body != e {
+		t.Errorf("got response %q, want %q", body, e)
+	}
+}
+
+func TestTransportNoHost(t *testing.T) {
+	defer afterTest(t)
+	tr := &Transport{}
+	_, err := tr.RoundTrip(&Request{
+		Header: make(Header),
+		URL: &url.URL{
+			Scheme: "http",
+		},
+	})
+	want := "http: no Host in request URL"
+	if got := fmt.Sprint(err); got != want {
+		t.Errorf("error = %v; want %q", err, want)
+	}
+}
+
+func TestTransportSocketLateBinding(t *testing.T) {
+	defer afterTest(t)
+
+	mux := NewServeMux()
+	fooGate := make(chan bool, 1)
+	mux.HandleFunc("/foo", func(w ResponseWriter, r *Request) {
+		w.Header().Set("foo-ipport", r.RemoteAddr)
+		w.(Flusher).Flush()
+		<-fooGate
+	})
+	mux.HandleFunc("/bar", func(w ResponseWriter, r *Request) {
+		w.Header().Set("bar-ipport", r.RemoteAddr)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dialGate := make(chan bool, 1)
+	tr := &Transport{
+		Dial: func(n, addr string) (net.Conn, error) {
+			if <-dialGate {
+				return net.Dial(n, addr)
+			}
+			return nil, errors.New("manually closed")
+		},
+		DisableKeepAlives: false,
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{
+		Transport: tr,
+	}
+
+	dialGate <- true // only allow one dial
+	fooRes, err := c.Get(ts.URL + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fooAddr := fooRes.Header.Get("foo-ipport")
+	if fooAddr == "" {
+		t.Fatal("No addr on /foo request")
+	}
+	time.AfterFunc(200*time.Millisecond, func() {
+		// let the foo response finish so we can use its
+		// connection for /bar
+		fooGate <- true
+		io.Copy(ioutil.Discard, fooRes.Body)
+		fooRes.Body.Close()
+	})
+
+	barRes, err := c.Get(ts.URL + "/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	barAddr := barRes.Header.Get("bar-ipport")
+	if barAddr != fooAddr {
+		t.Fatalf("/foo came from conn %q; /bar came from %q instead", fooAddr, barAddr)
+	}
+	barRes.Body.Close()
+	dialGate <- false
+}
+
+func TestTransportBodyProgress(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		slurp, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("server read: %v", err)
+		}
+		if r.Header.Get("X-Chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(slurp)))
+		}
+		w.Write(slurp)
+	}))
+	defer ts.Close()
+
+	body := strings.Repeat("x", 100<<10)
+	for _, chunked := range []bool{false, true} {
+		var sent, sentTotal, read, readTotal int64
+		var r io.Reader = strings.NewReader(body)
+		wantTotal := int64(len(body))
+		if chunked {
+			r = struct{ io.Reader }{r}
+			wantTotal = -1
+		}
+		req, _ := NewRequest("POST", ts.URL, r)
+		if chunked {
+			req.Header.Set("X-Chunked", "1")
+		}
+		req.Trace = &ClientTrace{
+			RequestBodyProgress: func(n, total int64) {
+				if n < sent {
+					t.Errorf("chunked=%v: request progress went back from %d to %d", chunked, sent, n)
+				}
+				sent, sentTotal = n, total
+			},
+			ResponseBodyProgress: func(n, total int64) {
+				read, readTotal = n, total
+			},
+		}
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != int64(len(body)) || sentTotal != wantTotal {
+			t.Errorf("chunked=%v: request progress = %d of %d; want %d of %d", chunked, sent, sentTotal, len(body), wantTotal)
+		}
+		if read != int64(len(body)) || readTotal != wantTotal {
+			t.Errorf("chunked=%v: response progress = %d of %d; want %d of %d", chunked, read, readTotal, len(body), wantTotal)
+		}
+	}
+}
+
+// Issue 2184
+func TestTransportReading100Continue(t *testing.T) {
+	defer afterTest(t)
+
+	const numReqs = 5
+	reqBody := func(n int) string { return fmt.Sprintf("request body %d", n) }
+	reqID := func(n int) string { return fmt.Sprintf("REQ-ID-%d", n) }
+
+	send100Response := func(w *io.PipeWriter, r *io.PipeReader) {
+		defer w.Close()
+		defer r.Close()
+		br := bufio.NewReader(r)
+		n := 0
+		for {
+			n++
+			req, err := ReadRequest(br)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			slurp, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Errorf("Server request body slurp: %v", err)
+				return
+			}
+			id := req.Header.Get("Request-Id")
+			resCode := req.Header.Get("X-Want-Response-Code")
+			if resCode == "" {
+				resCode = "100 Continue"
+				if string(slurp) != reqBody(n) {
+					t.Errorf("Server got %q, %v; want %q", slurp, err, reqBody(n))
+				}
+			}
+			body := fmt.Sprintf("Response number %d", n)
+			v := []byte(strings.Replace(fmt.Sprintf(`HTTP/1.1 %s
+Date: Thu, 28 Feb 2013 17:55:41 GMT
+
+HTTP/1.1 200 OK
+Content-Type: text/html
+Echo-Request-Id: %s
+Content-Length: %d
+
+%s`, resCode, id, len(body), body), "\n", "\r\n", -1))
+			w.Write(v)
+			if id == reqID(numReqs) {
+				return
+			}
+		}
+
+	}
+
+	tr := &Transport{
+		Dial: func(n, addr string) (net.Conn, error) {
+			sr, sw := io.Pipe() // server read/write
+			cr, cw := io.Pipe() // client read/write
+			conn := &rwTestConn{
+				Reader: cr,
+				Writer: sw,
+				closeFunc: func() error {
+					sw.Close()
+					cw.Close()
+					return nil
+				},
+			}
+			go send100Response(cw, sr)
+			return conn, nil
+		},
+		DisableKeepAlives: false,
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	testResponse := func(req *Request, name string, wantCode int) {
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s: Do: %v", name, err)
+		}
+		if res.StatusCode != wantCode {
+			t.Fatalf("%s: Response Statuscode=%d; want %d", name, res.StatusCode, wantCode)
+		}
+		if id, idBack := req.Header.Get("Request-Id"), res.Header.Get("Echo-Request-Id"); id != "" && id != idBack {
+			t.Errorf("%s: response id %q != request id %q", name, idBack, id)
+		}
+		_, err = ioutil.ReadAll(res.Body)
+		if err != nil {
 			t.Fatalf("%s: Slurp error: %v", name, err)
 		}
 	}
 
-	// Few 100 responses, making sure we're not off-by-one.
-	for i := 1; i <= numReqs; i++ {
-		req, _ := NewRequest("POST", "http://dummy.tld/", strings.NewReader(reqBody(i)))
-		req.Header.Set("Request-Id", reqID(i))
-		testResponse(req, fmt.Sprintf("100, %d/%d", i, numReqs), 200)
+	// Few 100 responses, making sure we're not off-by-one.
+	for i := 1; i <= numReqs; i++ {
+		req, _ := NewRequest("POST", "http://dummy.tld/", strings.NewReader(reqBody(i)))
+		req.Header.Set("Request-Id", reqID(i))
+		testResponse(req, fmt.Sprintf("100, %d/%d", i, numReqs), 200)
+	}
+
+	// And some other informational 1xx but non-100 responses, to test
+	// we skip them too and report them to the request's Trace.
+	for i := 1; i <= numReqs; i++ {
+		var got []int
+		req, _ := NewRequest("POST", "http://other.tld/", strings.NewReader(reqBody(i)))
+		req.Header.Set("X-Want-Response-Code", "123 Sesame Street")
+		req.Trace = &ClientTrace{
+			Got1xxResponse: func(code int, header Header) error {
+				if header.Get("Date") == "" {
+					t.Errorf("1xx response header missing Date: %v", header)
+				}
+				got = append(got, code)
+				return nil
+			},
+		}
+		testResponse(req, fmt.Sprintf("123, %d/%d", i, numReqs), 200)
+		if len(got) != 1 || got[0] != 123 {
+			t.Errorf("123, %d/%d: Got1xxResponse saw %v; want [123]", i, numReqs, got)
+		}
+	}
+
+	// A Got1xxResponse error aborts the request.
+	errStop := errors.New("stop")
+	req, _ := NewRequest("POST", "http://abort.tld/", strings.NewReader(reqBody(1)))
+	req.Header.Set("X-Want-Response-Code", "103 Early Hints")
+	req.Trace = &ClientTrace{
+		Got1xxResponse: func(code int, header Header) error { return errStop },
+	}
+	if _, err := c.Do(req); err == nil || !strings.Contains(err.Error(), "stop") {
+		t.Errorf("Got1xxResponse error: Do returned %v; want stop", err)
+	}
+}
+
+func TestTransportExpectContinue(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/reject" {
+			// Replying without reading the body sends no
+			// 100 Continue.
+			w.WriteHeader(StatusUnauthorized)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	const body = "some body"
+	tests := []struct {
+		path     string
+		status   int
+		wantRead bool
+	}{
+		{"/echo", 200, true},
+		{"/reject", 401, false},
+	}
+	for _, tt := range tests {
+		tr := &Transport{ExpectContinueTimeout: time.Hour}
+		c := &Client{Transport: tr}
+		var n int64
+		req, _ := NewRequest("POST", ts.URL+tt.path, countReader{strings.NewReader(body), &n})
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Expect", "100-continue")
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status = %d; want %d", tt.path, res.StatusCode, tt.status)
+		}
+		if tt.wantRead && string(got) != body {
+			t.Errorf("%s: body = %q; want %q", tt.path, got, body)
+		}
+		if read := atomic.LoadInt64(&n) > 0; read != tt.wantRead {
+			t.Errorf("%s: request body read = %v; want %v", tt.path, read, tt.wantRead)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+// Tests that a request body is sent after ExpectContinueTimeout when
+// the server never replies "100 Continue".
+func TestTransportExpectContinueTimeout(t *testing.T) {
+	defer afterTest(t)
+	ln := newLocalListener(t)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, err := ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+	}()
+
+	tr := &Transport{ExpectContinueTimeout: 10 * time.Millisecond}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	req, _ := NewRequest("POST", "http://"+ln.Addr().String()+"/", strings.NewReader("late body"))
+	req.Header.Set("Expect", "100-continue")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(got) != "late body" {
+		t.Errorf("body = %q; want %q", got, "late body")
+	}
+}
+
+func TestTransportRequestBodyBufferSize(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d %q %d", r.ContentLength, r.TransferEncoding, len(body))
+	}))
+	defer ts.Close()
+
+	tr := &Transport{RequestBodyBufferSize: 16}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, `0 [] 0`},
+		{10, `10 [] 10`},
+		{16, `16 [] 16`},
+		{17, `-1 ["chunked"] 17`},
+		{1000, `-1 ["chunked"] 1000`},
+	}
+	for _, tt := range tests {
+		// NopCloser hides the length from NewRequest.
+		body := ioutil.NopCloser(strings.NewReader(strings.Repeat("x", tt.size)))
+		res, err := c.Post(ts.URL, "text/plain", body)
+		if err != nil {
+			t.Fatalf("size %d: %v", tt.size, err)
+		}
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(got) != tt.want {
+			t.Errorf("size %d: server saw %s; want %s", tt.size, got, tt.want)
+		}
 	}
+}
 
-	// And some other informational 1xx but non-100 responses, to test
-	// we return them but don't re-use the connection.
-	for i := 1; i <= numReqs; i++ {
-		req, _ := NewRequest("POST", "http://other.tld/", strings.NewReader(reqBody(i)))
-		req.Header.Set("X-Want-Response-Code", "123 Sesame Street")
-		testResponse(req, fmt.Sprintf("123, %d/%d", i, numReqs), 123)
+func TestTransportConnectTunnel(t *testing.T) {
+	defer afterTest(t)
+	echo := newLocalListener(t)
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	proxy := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Method != "CONNECT" || r.Host != echo.Addr().String() {
+			Error(w, "no", StatusForbidden)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn, brw, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(target, brw)
+			target.Close()
+		}()
+		io.Copy(conn, target)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	req, _ := NewRequest("CONNECT", proxy.URL, nil)
+	req.Host = "forbidden:1"
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusForbidden {
+		t.Errorf("refused CONNECT status = %d; want 403", res.StatusCode)
+	}
+	if _, ok := res.Body.(io.Writer); ok {
+		t.Error("refused CONNECT response Body is an io.Writer")
+	}
+
+	req, _ = NewRequest("CONNECT", proxy.URL, nil)
+	req.Host = echo.Addr().String()
+	res, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("CONNECT status = %d; want 200", res.StatusCode)
+	}
+	tunnel, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("CONNECT response Body is a %T; want an io.ReadWriteCloser", res.Body)
+	}
+	defer tunnel.Close()
+	if _, err := io.WriteString(tunnel, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(tunnel).ReadString('\n')
+	if line != "ping\n" || err != nil {
+		t.Errorf("read %q, %v from tunnel; want %q", line, err, "ping\n")
+	}
+	if n := len(tr.IdleConns()); n != 0 {
+		t.Errorf("%d idle conns; want the tunnel's conn not pooled", n)
+	}
+}
+
+func TestTransportSwitchingProtocols(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			Error(w, "upgrade required", StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		// Data sent right after the 101 response must reach the
+		// client before what it reads from the conn.
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: echo\r\nConnection: Upgrade\r\n\r\nhello\n")
+		line, err := brw.ReadString('\n')
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.WriteString(conn, line)
+	}))
+	defer ts.Close()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Upgrade", "echo")
+	req.Header.Set("Connection", "Upgrade")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != StatusSwitchingProtocols {
+		t.Fatalf("status = %d; want 101", res.StatusCode)
+	}
+	rwc, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("101 response Body is a %T; want an io.ReadWriteCloser", res.Body)
+	}
+	defer rwc.Close()
+	br := bufio.NewReader(rwc)
+	if line, err := br.ReadString('\n'); line != "hello\n" || err != nil {
+		t.Errorf("first read %q, %v; want %q", line, err, "hello\n")
+	}
+	io.WriteString(rwc, "ping\n")
+	if line, err := br.ReadString('\n'); line != "ping\n" || err != nil {
+		t.Errorf("echo read %q, %v; want %q", line, err, "ping\n")
+	}
+}
+
+func TestTransportMaxResponseBodyBytes(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		n, _ := strconv.Atoi(r.FormValue("n"))
+		body := strings.Repeat("x", n)
+		if r.FormValue("gzip") != "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, body)
+			gz.Close()
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer ts.Close()
+
+	const max = 100
+	tr := &Transport{MaxResponseBodyBytes: max}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	tests := []struct {
+		query   string
+		tooLong bool
+	}{
+		{"n=0", false},
+		{"n=100", false},
+		{"n=101", true},
+		{"n=100000", true},
+		{"n=100&gzip=1", false},
+		{"n=100000&gzip=1", true}, // the limit applies after decoding
+	}
+	for _, tt := range tests {
+		res, err := c.Get(ts.URL + "/?" + tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !tt.tooLong {
+			if err != nil {
+				t.Errorf("%s: read error %v", tt.query, err)
+			}
+			continue
+		}
+		if e, ok := err.(*BodyTooLargeError); !ok || e.Limit != LimitMaxResponseBodyBytes || e.Max != max {
+			t.Errorf("%s: read error = %v; want *BodyTooLargeError for MaxResponseBodyBytes %d", tt.query, err, max)
+		}
+		if len(body) != max {
+			t.Errorf("%s: read %d bytes; want %d", tt.query, len(body), max)
+		}
+	}
+}
+
+func TestTransportVerifyDigest(t *testing.T) {
+	defer afterTest(t)
+	const content = "some content worth checking"
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		body := []byte(content)
+		if r.FormValue("gzip") != "" {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(body)
+			gz.Close()
+			body = buf.Bytes()
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		if d := r.FormValue("deflate"); d != "" {
+			var buf bytes.Buffer
+			var zw io.WriteCloser
+			if d == "raw" {
+				zw, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+			} else {
+				zw = zlib.NewWriter(&buf)
+			}
+			zw.Write(body)
+			zw.Close()
+			body = buf.Bytes()
+			w.Header().Set("Content-Encoding", "deflate")
+		}
+		var sum []byte
+		switch r.FormValue("alg") {
+		case "md5":
+			s := md5.Sum(body)
+			sum = s[:]
+		case "sha256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		}
+		if r.FormValue("bad") != "" {
+			sum[0]++
+		}
+		digest := base64.StdEncoding.EncodeToString(sum)
+		if r.FormValue("alg") == "md5" {
+			w.Header().Set("Content-MD5", digest)
+		} else {
+			w.Header().Set("Digest", "UNIXsum=30637, SHA-256="+digest)
+		}
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		query   string
+		verify  bool
+		wantErr string // the DigestError's Header, if any
+	}{
+		{"alg=md5", true, ""},
+		{"alg=md5&bad=1", true, "Content-MD5"},
+		{"alg=sha256", true, ""},
+		{"alg=sha256&bad=1", true, "Digest"},
+		{"alg=md5&gzip=1", true, ""}, // the digest covers the encoded body
+		{"alg=md5&gzip=1&bad=1", true, "Content-MD5"},
+		{"alg=sha256&gzip=1&bad=1", true, "Digest"},
+		{"alg=md5&deflate=zlib", true, ""},
+		{"alg=md5&deflate=zlib&bad=1", true, "Content-MD5"},
+		{"alg=sha256&deflate=raw", true, ""},
+		{"alg=sha256&deflate=raw&bad=1", true, "Digest"},
+		{"alg=md5&bad=1", false, ""},
+	}
+	for _, tt := range tests {
+		tr := &Transport{VerifyDigest: tt.verify}
+		c := &Client{Transport: tr}
+		res, err := c.Get(ts.URL + "/?" + tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		tr.CloseIdleConnections()
+		if tt.wantErr == "" {
+			if err != nil || string(body) != content {
+				t.Errorf("%s, verify=%v: read %q, %v; want %q", tt.query, tt.verify, body, err, content)
+			}
+			continue
+		}
+		if e, ok := err.(*DigestError); !ok || e.Header != tt.wantErr {
+			t.Errorf("%s: read error = %v; want *DigestError for %s", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTransportMaxResponseHeaderBytes(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		n, _ := strconv.Atoi(r.FormValue("n"))
+		w.Header().Set("X-Big", strings.Repeat("a", n))
+		io.WriteString(w, "body")
+	}))
+	defer ts.Close()
+
+	tr := &Transport{MaxResponseHeaderBytes: 1000}
+	defer tr.CloseIdleConnections()
+	for _, n := range []int{10, 5000, 10} {
+		req, _ := NewRequest("GET", ts.URL+"/?n="+strconv.Itoa(n), nil)
+		res, err := tr.RoundTrip(req)
+		if n < 1000 {
+			if err != nil {
+				t.Fatalf("%d byte header: %v", n, err)
+			}
+			res.Body.Close()
+			continue
+		}
+		if err == nil {
+			res.Body.Close()
+			t.Fatalf("%d byte header: RoundTrip succeeded; want error", n)
+		}
+		if e, ok := err.(*HeaderTooLargeError); !ok || e.Max != 1000 {
+			t.Errorf("%d byte header: error = %#v; want *HeaderTooLargeError with Max 1000", n, err)
+		}
+	}
+}
+
+func TestTransportErrorTypes(t *testing.T) {
+	defer afterTest(t)
+	closed := newLocalListener(t)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	malformed := newLocalListener(t)
+	defer malformed.Close()
+	go func() {
+		for {
+			c, err := malformed.Accept()
+			if err != nil {
+				return
+			}
+			ReadRequest(bufio.NewReader(c))
+			io.WriteString(c, "HTTP/1.1 two hundred OK\r\n\r\n")
+			c.Close()
+		}
+	}()
+
+	tlsTS := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	tlsTS.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // bad certificate
+	tlsTS.StartTLS()
+	defer tlsTS.Close()
+
+	tr := &Transport{Resolver: staticResolver(nil)} // resolves nothing
+	defer tr.CloseIdleConnections()
+	tests := []struct {
+		url   string
+		check func(error) bool
+	}{
+		{"http://no-such-host.example/", func(err error) bool {
+			e, ok := err.(*DialError)
+			if !ok {
+				return false
+			}
+			_, dns := e.Err.(*net.DNSError)
+			return dns
+		}},
+		{"http://" + closedAddr + "/", func(err error) bool {
+			e, ok := err.(*DialError)
+			if !ok {
+				return false
+			}
+			_, op := e.Err.(*net.OpError)
+			return op
+		}},
+		{tlsTS.URL, func(err error) bool {
+			e, ok := err.(*TLSError)
+			return ok && e.ServerName == "127.0.0.1"
+		}},
+		{"http://" + malformed.Addr().String() + "/", func(err error) bool {
+			_, ok := err.(*MalformedResponseError)
+			return ok
+		}},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", tt.url, nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+			t.Errorf("%s: RoundTrip succeeded; want error", tt.url)
+			continue
+		}
+		if !tt.check(err) {
+			t.Errorf("%s: error is %T (%v)", tt.url, err, err)
+		}
+	}
+}
+
+func TestTransportDialTLSErrorTypes(t *testing.T) {
+	defer afterTest(t)
+	errRefused := errors.New("connection refused")
+	tests := []struct {
+		err   error
+		check func(error) bool
+	}{
+		{x509.UnknownAuthorityError{}, func(err error) bool {
+			e, ok := err.(*TLSError)
+			return ok && e.ServerName == "tls.test"
+		}},
+		{&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}, func(err error) bool {
+			_, ok := err.(*TLSError)
+			return ok
+		}},
+		{errRefused, func(err error) bool {
+			e, ok := err.(*DialError)
+			return ok && e.Err == errRefused
+		}},
+	}
+	for _, tt := range tests {
+		tr := &Transport{
+			DialTLS: func(network, addr string) (net.Conn, error) { return nil, tt.err },
+		}
+		req, _ := NewRequest("GET", "https://tls.test/", nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+			t.Errorf("DialTLS error %v: RoundTrip succeeded; want error", tt.err)
+			continue
+		}
+		if !tt.check(err) {
+			t.Errorf("DialTLS error %v: RoundTrip error is %T (%v)", tt.err, err, err)
+		}
+	}
+}
+
+func TestTransportHostTLSClientConfig(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	host, _, _ := net.SplitHostPort(u.Host)
+
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	tests := []struct {
+		configs map[string]*tls.Config
+		wantErr bool
+	}{
+		{nil, true},
+		{map[string]*tls.Config{"other.tld": insecure}, true},
+		{map[string]*tls.Config{host: insecure}, false},
+		{map[string]*tls.Config{u.Host: insecure}, false},
+		{map[string]*tls.Config{host: insecure, u.Host: {}}, true},
+	}
+	for i, tt := range tests {
+		tr := &Transport{HostTLSClientConfig: tt.configs}
+		c := &Client{Transport: tr}
+		res, err := c.Get(ts.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("%d. Get error = %v; want error: %v", i, err, tt.wantErr)
+		}
+		tr.CloseIdleConnections()
 	}
 }
 
@@ -1745,6 +4107,8 @@ var proxyFromEnvTests = []proxyFromEnvTest{
 	{env: "https://cache.corp.example.com", want: "https://cache.corp.example.com"},
 	{env: "http://127.0.0.1:8080", want: "http://127.0.0.1:8080"},
 	{env: "https://127.0.0.1:8080", want: "https://127.0.0.1:8080"},
+	{env: "socks5://127.0.0.1:1080", want: "socks5://127.0.0.1:1080"},
+	{env: "socks4://127.0.0.1:1080", want: "socks4://127.0.0.1:1080"},
 
 	// Don't use secure for http
 	{req: "http://insecure.tld/", env: "http.proxy.tld", httpsenv: "secure.proxy.tld", want: "http://http.proxy.tld"},
@@ -66,6 +66,6 @@ func ExampleURL_opaque() {
 	// GET /%2f/ HTTP/1.1
 	// Host: example.com
 	// User-Agent: godoc-example/0.1
-	// Accept-Encoding: gzip
+	// Accept-Encoding: gzip, deflate
 	//
 }
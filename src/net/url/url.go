@@ -23,6 +23,24 @@ type Error struct {
 
 func (e *Error) Error() string { return e.Op + " " + e.URL + ": " + e.Err.Error() }
 
+// Timeout reports whether Err is a timeout, as reported by its
+// Timeout method.
+func (e *Error) Timeout() bool {
+	t, ok := e.Err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
+
+// Temporary reports whether Err is temporary, as reported by its
+// Temporary method.
+func (e *Error) Temporary() bool {
+	t, ok := e.Err.(interface {
+		Temporary() bool
+	})
+	return ok && t.Temporary()
+}
+
 func ishex(c byte) bool {
 	switch {
 	case '0' <= c && c <= '9':
@@ -51,6 +69,7 @@ type encoding int
 
 const (
 	encodePath encoding = 1 + iota
+	encodePathSegment
 	encodeUserPassword
 	encodeQueryComponent
 	encodeFragment
@@ -85,6 +104,11 @@ func shouldEscape(c byte, mode encoding) bool {
 			// last two as well. That leaves only ? to escape.
 			return c == '?'
 
+		case encodePathSegment: // §3.3
+			// The RFC allows : @ & = + $ but saves / ; , for assigning
+			// meaning to individual path segments.
+			return c == '/' || c == ';' || c == ',' || c == '?'
+
 		case encodeUserPassword: // §3.2.1
 			// The RFC allows ';', ':', '&', '=', '+', '$', and ',' in
 			// userinfo, so we must escape only '@', '/', and '?'.
@@ -175,6 +199,22 @@ func QueryEscape(s string) string {
 	return escape(s, encodeQueryComponent)
 }
 
+// PathUnescape does the inverse transformation of PathEscape,
+// converting %AB into the byte 0xAB. Unlike QueryUnescape, it leaves
+// '+' unchanged. It returns an error if any % is not followed by two
+// hexadecimal digits.
+func PathUnescape(s string) (string, error) {
+	return unescape(s, encodePathSegment)
+}
+
+// PathEscape escapes the string so it can be safely placed inside a
+// URL path segment. Unlike QueryEscape, it encodes a space as %20
+// rather than '+', and it also escapes '/', so the result cannot be
+// mistaken for several segments.
+func PathEscape(s string) string {
+	return escape(s, encodePathSegment)
+}
+
 func escape(s string, mode encoding) string {
 	spaceCount, hexCount := 0, 0
 	for i := 0; i < len(s); i++ {
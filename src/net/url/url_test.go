@@ -535,6 +535,67 @@ func TestEscape(t *testing.T) {
 	}
 }
 
+var pathEscapeTests = []EscapeTest{
+	{
+		"",
+		"",
+		nil,
+	},
+	{
+		"abc",
+		"abc",
+		nil,
+	},
+	{
+		"one two",
+		"one%20two",
+		nil,
+	},
+	{
+		"10%",
+		"10%25",
+		nil,
+	},
+	{
+		"a+b/c;d,e?f",
+		"a+b%2Fc%3Bd%2Ce%3Ff",
+		nil,
+	},
+	{
+		"$&:=@",
+		"$&:=@",
+		nil,
+	},
+}
+
+func TestPathEscape(t *testing.T) {
+	for _, tt := range pathEscapeTests {
+		actual := PathEscape(tt.in)
+		if tt.out != actual {
+			t.Errorf("PathEscape(%q) = %q, want %q", tt.in, actual, tt.out)
+		}
+
+		roundtrip, err := PathUnescape(actual)
+		if roundtrip != tt.in || err != nil {
+			t.Errorf("PathUnescape(%q) = %q, %s; want %q, %s", actual, roundtrip, err, tt.in, "[no error]")
+		}
+	}
+}
+
+func TestPathUnescape(t *testing.T) {
+	tests := []EscapeTest{
+		{"a+b", "a+b", nil},
+		{"a%20b%2Fc", "a b/c", nil},
+		{"%zz", "", EscapeError("%zz")},
+	}
+	for _, tt := range tests {
+		actual, err := PathUnescape(tt.in)
+		if actual != tt.out || (err != nil) != (tt.err != nil) {
+			t.Errorf("PathUnescape(%q) = %q, %s; want %q, %s", tt.in, actual, err, tt.out, tt.err)
+		}
+	}
+}
+
 //var userinfoTests = []UserinfoTest{
 //	{"user", "password", "user:password"},
 //	{"foo:bar", "~!@#$%^&*()_+{}|[]\\-=`:;'\"<>?,./",
@@ -959,3 +1020,30 @@ func TestShouldEscape(t *testing.T) {
 		}
 	}
 }
+
+type timeoutError struct {
+	timeout, temporary bool
+}
+
+func (e *timeoutError) Error() string   { return "error" }
+func (e *timeoutError) Timeout() bool   { return e.timeout }
+func (e *timeoutError) Temporary() bool { return e.temporary }
+
+func TestErrorTimeoutTemporary(t *testing.T) {
+	tests := []struct {
+		err                error
+		timeout, temporary bool
+	}{
+		{&timeoutError{true, true}, true, true},
+		{&timeoutError{false, true}, false, true},
+		{&timeoutError{false, false}, false, false},
+		{EscapeError("%zz"), false, false},
+	}
+	for _, tt := range tests {
+		e := &Error{"Get", "http://example.com/", tt.err}
+		if e.Timeout() != tt.timeout || e.Temporary() != tt.temporary {
+			t.Errorf("Error wrapping %#v: Timeout, Temporary = %v, %v; want %v, %v",
+				tt.err, e.Timeout(), e.Temporary(), tt.timeout, tt.temporary)
+		}
+	}
+}